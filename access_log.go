@@ -0,0 +1,212 @@
+package ldap_pg
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
+)
+
+// accessLogger writes one JSON line per LDAP operation.
+// It's independent from the debug log, the destination is a file or stdout.
+type accessLogger struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	out  io.Writer
+}
+
+// AccessLogRecord is the record of the access log.
+type AccessLogRecord struct {
+	Time      string   `json:"time"`
+	Conn      int      `json:"conn"`
+	MsgID     int      `json:"msgid"`
+	IP        string   `json:"ip,omitempty"`
+	BoundDN   string   `json:"bound_dn"`
+	AuthzDN   string   `json:"authz_dn,omitempty"`
+	Op        string   `json:"op"`
+	DN        string   `json:"dn,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Filter    string   `json:"filter,omitempty"`
+	Attrs     []string `json:"attrs,omitempty"`
+	NewRDN    string   `json:"newrdn,omitempty"`
+	NewSup    string   `json:"newsup,omitempty"`
+	OID       string   `json:"oid,omitempty"`
+	Result    *int     `json:"result,omitempty"`
+	Entries   *int     `json:"entries,omitempty"`
+	ElapsedMS float64  `json:"elapsed_ms"`
+}
+
+// newAccessLogger opens the access log. "-" means stdout.
+func newAccessLogger(path string) (*accessLogger, error) {
+	l := &accessLogger{
+		path: path,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *accessLogger) open() error {
+	if l.path == "-" {
+		l.out = os.Stdout
+		return nil
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return xerrors.Errorf("Failed to open the access log. path: %s, err: %w", l.path, err)
+	}
+	l.file = f
+	l.out = f
+	return nil
+}
+
+// reopen reopens the access log file for the log rotation.
+func (l *accessLogger) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	old := l.file
+	if err := l.open(); err != nil {
+		return err
+	}
+	if err := old.Close(); err != nil {
+		log.Printf("warn: Failed to close the old access log. path: %s, err: %v", l.path, err)
+	}
+	return nil
+}
+
+func (l *accessLogger) write(record *AccessLogRecord) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("error: Failed to marshal the access log. err: %v", err)
+		return
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.out.Write(b); err != nil {
+		log.Printf("error: Failed to write the access log. err: %v", err)
+	}
+}
+
+// logOperation writes the record of the LDAP operation.
+// It's called after the handler, so the bound DN of the bind operation is the new one.
+func (l *accessLogger) logOperation(m *ldap.Message, w *recordingResponseWriter, elapsed time.Duration) {
+	if l == nil {
+		return
+	}
+
+	record := &AccessLogRecord{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		Conn:      m.Client.Numero,
+		MsgID:     int(m.MessageID()),
+		IP:        clientIP(m),
+		Op:        operationName(m),
+		ElapsedMS: float64(elapsed.Microseconds()) / 1000,
+	}
+	if session := getBoundAuthSession(m); session.DN != nil {
+		record.BoundDN = session.DN.DNNormStr()
+	}
+	// The proxied identity by the proxied authorization control
+	if session, ok := proxiedAuthSession(m); ok && session.DN != nil {
+		record.AuthzDN = session.DN.DNNormStr()
+	}
+	setAccessLogRequest(record, m.ProtocolOp())
+
+	if w.hasResult {
+		code := w.resultCode
+		record.Result = &code
+	}
+	if record.Op == "search" {
+		entries := w.entries
+		record.Entries = &entries
+	}
+
+	l.write(record)
+}
+
+// setAccessLogRequest sets the parameters of the request to the record.
+func setAccessLogRequest(record *AccessLogRecord, po message.ProtocolOp) {
+	switch r := po.(type) {
+	case message.BindRequest:
+		record.DN = string(r.Name())
+	case message.SearchRequest:
+		record.DN = string(r.BaseObject())
+		record.Scope = searchScopeName(int(r.Scope()))
+		record.Filter = r.FilterString()
+		for _, attr := range r.Attributes() {
+			record.Attrs = append(record.Attrs, string(attr))
+		}
+	case message.AddRequest:
+		record.DN = string(r.Entry())
+	case message.DelRequest:
+		record.DN = string(r)
+	case message.ModifyRequest:
+		record.DN = string(r.Object())
+	case message.ModifyDNRequest:
+		record.DN = string(r.Entry())
+		record.NewRDN = string(r.NewRDN())
+		if r.NewSuperior() != nil {
+			record.NewSup = string(*r.NewSuperior())
+		}
+	case message.CompareRequest:
+		record.DN = string(r.Entry())
+		record.Attrs = []string{string(r.Ava().AttributeDesc())}
+	case message.ExtendedRequest:
+		record.OID = string(r.RequestName())
+	}
+}
+
+func searchScopeName(scope int) string {
+	switch scope {
+	case message.SearchRequestScopeBaseObject:
+		return "base"
+	case message.SearchRequestSingleLevel:
+		return "one"
+	case message.SearchRequestHomeSubtree:
+		return "sub"
+	case message.SearchRequestSubordinateSubtree:
+		return "children"
+	default:
+		return "unknown"
+	}
+}
+
+// clientIP returns the IP address of the client, empty if it's unknown.
+func clientIP(m *ldap.Message) string {
+	addr := m.Client.Addr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// ReopenAccessLog reopens the access log file, it's used for the log rotation.
+func (s *Server) ReopenAccessLog() error {
+	if s.accessLog == nil {
+		return nil
+	}
+	if err := s.accessLog.reopen(); err != nil {
+		return err
+	}
+	log.Printf("info: Reopened the access log. path: %s", s.accessLog.path)
+	return nil
+}
@@ -0,0 +1,82 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openstandia/goldap/message"
+)
+
+func TestSetAccessLogRequest(t *testing.T) {
+	record := &AccessLogRecord{}
+	setAccessLogRequest(record, message.DelRequest("uid=user1,ou=Users,dc=example,dc=com"))
+	if record.DN != "uid=user1,ou=Users,dc=example,dc=com" {
+		t.Errorf("Unexpected dn: %s", record.DN)
+	}
+
+	for i, tc := range []struct {
+		Scope    int
+		Expected string
+	}{
+		{message.SearchRequestScopeBaseObject, "base"},
+		{message.SearchRequestSingleLevel, "one"},
+		{message.SearchRequestHomeSubtree, "sub"},
+		{message.SearchRequestSubordinateSubtree, "children"},
+		{9, "unknown"},
+	} {
+		if scope := searchScopeName(tc.Scope); scope != tc.Expected {
+			t.Errorf("Unexpected scope on %d: expected %s, got %s", i, tc.Expected, scope)
+		}
+	}
+}
+
+func TestAccessLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	l, err := newAccessLogger(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	code := 0
+	entries := 2
+	l.write(&AccessLogRecord{Conn: 1, MsgID: 2, Op: "search", DN: "dc=example,dc=com", Result: &code, Entries: &entries})
+
+	// Rotate the file, then the next record is written to the new file
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := l.reopen(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	l.write(&AccessLogRecord{Conn: 1, MsgID: 3, Op: "bind"})
+
+	b, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(b, &record); err != nil {
+		t.Fatalf("Invalid JSON: %s, err: %v", b, err)
+	}
+	if record["op"] != "search" || record["result"] != float64(0) || record["entries"] != float64(2) {
+		t.Errorf("Unexpected record: %s", b)
+	}
+
+	b, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"op":"bind"`) {
+		t.Errorf("Unexpected rotated log: %s", b)
+	}
+	// Not searched, no result yet
+	if strings.Contains(string(b), "entries") || strings.Contains(string(b), "result") {
+		t.Errorf("Unexpected fields: %s", b)
+	}
+}
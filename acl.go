@@ -3,19 +3,28 @@ package ldap_pg
 import (
 	"context"
 	"log"
+	"net"
+	"regexp"
 	"strings"
 
+	"github.com/openstandia/goldap/message"
 	ldap "github.com/openstandia/ldapserver"
 	"golang.org/x/xerrors"
 )
 
 type contextKey string
 
-const authContextKey contextKey = "auth"
+const (
+	authContextKey      contextKey = "auth"
+	clientIPContextKey  contextKey = "clientIP"
+	assertionContextKey contextKey = "assertion"
+	readEntryContextKey contextKey = "readEntry"
+)
 
 func SetSessionContext(parents context.Context, m *ldap.Message) context.Context {
 	session := getAuthSession(m)
-	return context.WithValue(parents, authContextKey, session)
+	ctx := context.WithValue(parents, authContextKey, session)
+	return context.WithValue(ctx, clientIPContextKey, clientIP(m))
 }
 
 func AuthSessionContext(ctx context.Context) (*AuthSession, error) {
@@ -29,6 +38,12 @@ func AuthSessionContext(ctx context.Context) (*AuthSession, error) {
 	return session, nil
 }
 
+// clientIPContext returns the IP address of the client, empty if it's unknown.
+func clientIPContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey).(string)
+	return ip
+}
+
 type LDAPAction int
 
 const (
@@ -37,6 +52,7 @@ const (
 	ModRDNOps
 	DeleteOps
 	SearchOps
+	CompareOps
 )
 
 func (c LDAPAction) String() string {
@@ -51,106 +67,522 @@ func (c LDAPAction) String() string {
 		return "delete"
 	case SearchOps:
 		return "search"
+	case CompareOps:
+		return "compare"
 	default:
 		return "unknown"
 	}
 }
 
-func (s *Server) RequiredAuthz(m *ldap.Message, ops LDAPAction, targetDN *DN) bool {
-	session := getAuthSession(m)
-	if session.DN != nil {
-		authorized := false
+const (
+	// ACLAttrEntry is the pseudo attribute to control the access to the entry itself
+	ACLAttrEntry = "entry"
+	// ACLAttrChildren is the pseudo attribute to control adding/deleting the children of the entry
+	ACLAttrChildren = "children"
+	// ACLAttrProxy is the pseudo attribute to control acting as the entry by the proxied authorization
+	ACLAttrProxy = "proxy"
+)
+
+// RequiredAuthz checks the entry level access for the operation.
+// Add requires write to "children" of the parent entry, delete and modrdn require write to
+// "entry" of the target entry and "children" of the parent entry.
+// The attribute level access is checked by RequiredAttrsAuthz.
+func (s *Server) RequiredAuthz(ctx context.Context, m *ldap.Message, ops LDAPAction, targetDN *DN) bool {
+	requester := newACLRequester(m)
+
+	authorized := false
+
+	switch ops {
+	case AddOps:
+		authorized = s.hasParentAccess(ctx, requester, targetDN)
+	case DeleteOps, ModRDNOps:
+		authorized = s.hasAccess(ctx, requester, targetDN, nil, ACLAttrEntry, AccessWrite) &&
+			s.hasParentAccess(ctx, requester, targetDN)
+	default:
+		log.Printf("error: Unsupported action for entry level authorization. action: %s", ops.String())
+	}
+
+	log.Printf("info: Authorized: %v, action: %s, authorizedDN: %s, targetDN: %s", authorized, ops.String(), requester, targetDN.DNNormStr())
+
+	return authorized
+}
+
+// RequiredSubtreeDeleteAuthz checks all the entries of the subtree are deletable like RequiredAuthz.
+// The entries are locked in the transaction of the deletion, so they're used for the filter of the access rules
+// instead of fetching them again. The parent of the top entry is checked by RequiredAuthz beforehand.
+func (s *Server) RequiredSubtreeDeleteAuthz(ctx context.Context, m *ldap.Message, dns []*DN, entries []*SearchEntry) bool {
+	requester := newACLRequester(m)
 
-		switch ops {
-		case AddOps:
-			authorized = s.simpleACL.CanWrite(session)
-		case ModifyOps:
-			authorized = s.simpleACL.CanWrite(session)
-		case ModRDNOps:
-			authorized = s.simpleACL.CanWrite(session)
-		case DeleteOps:
-			authorized = s.simpleACL.CanWrite(session)
-		case SearchOps:
-			authorized = s.simpleACL.CanRead(session)
+	subtree := make(map[string]*SearchEntry, len(dns))
+	for i, dn := range dns {
+		subtree[dn.DNNormStr()] = entries[i]
+	}
+
+	for i, dn := range dns {
+		authorized := s.hasAccess(ctx, requester, dn, entries[i], ACLAttrEntry, AccessWrite)
+		if parentDN := dn.ParentDN(); authorized && parentDN != nil {
+			if parent, ok := subtree[parentDN.DNNormStr()]; ok {
+				authorized = s.hasAccess(ctx, requester, parentDN, parent, ACLAttrChildren, AccessWrite)
+			}
 		}
+		if !authorized {
+			log.Printf("info: Not authorized. action: %s, authorizedDN: %s, targetDN: %s", DeleteOps.String(), requester, dn.DNNormStr())
+			return false
+		}
+	}
+	return true
+}
+
+// RequiredAttrsAuthz checks all the attributes of the target entry are accessible with the level.
+// The entry is used for the filter of the access rules, it's fetched if needed when it's nil.
+func (s *Server) RequiredAttrsAuthz(ctx context.Context, m *ldap.Message, targetDN *DN, entry *SearchEntry, attrs []string, level AccessLevel) bool {
+	requester := newACLRequester(m)
 
-		if session.DN.DNNormStr() == targetDN.DNNormStr() {
-			return true
+	for _, attr := range attrs {
+		if !s.hasAccess(ctx, requester, targetDN, entry, attr, level) {
+			log.Printf("info: Not authorized. level: %s, authorizedDN: %s, targetDN: %s, attr: %s", level, requester, targetDN.DNNormStr(), attr)
+			return false
 		}
-		log.Printf("info: Authorized: %v, action: %s, authorizedDN: %s, targetDN: %s", authorized, ops.String(), session.DN.DNNormStr(), targetDN.DNNormStr())
+	}
+	return true
+}
 
-		return authorized
+// RequiredWriteAuthz checks all the attributes of the target entry are writable.
+// The access rules in the ACL entry and the operational attributes require manage, others require write.
+func (s *Server) RequiredWriteAuthz(ctx context.Context, m *ldap.Message, targetDN *DN, entry *SearchEntry, attrs []string) bool {
+	requester := newACLRequester(m)
+
+	for _, attr := range attrs {
+		level := s.writeAccessLevel(targetDN, attr)
+		if !s.hasAccess(ctx, requester, targetDN, entry, attr, level) {
+			log.Printf("info: Not authorized. level: %s, authorizedDN: %s, targetDN: %s, attr: %s", level, requester, targetDN.DNNormStr(), attr)
+			return false
+		}
 	}
-	log.Printf("warn: Not Authorized for anonymous. targetDN: %s", targetDN.DNNormStr())
+	return true
+}
 
-	return false
+func (s *Server) writeAccessLevel(targetDN *DN, attr string) AccessLevel {
+	if attr == ACLAttrEntry || attr == ACLAttrChildren {
+		return AccessWrite
+	}
+	name := normalizeACLAttrName(s.schemaMap, attr)
+	if name == "olcaccess" && s.isACLEntry(targetDN) {
+		return AccessManage
+	}
+	if at, ok := s.schemaMap.AttributeType(name); ok && at.IsOperationalAttribute() {
+		return AccessManage
+	}
+	return AccessWrite
 }
 
-type SimpleACL struct {
-	list map[string]*SimpleACLDef
+// CanAuth checks the password of the entry can be used for the authentication.
+// The bind is processed as anonymous like OpenLDAP, so it requires "auth" of anonymous to userPassword.
+func (s *Server) CanAuth(ctx context.Context, dn *DN) bool {
+	requester := &ACLRequester{
+		session: &AuthSession{},
+	}
+	if ip := clientIPContext(ctx); ip != "" {
+		requester.peerIP = net.ParseIP(ip)
+	}
+	return s.hasAccess(ctx, requester, dn, nil, "userPassword", AccessAuth)
 }
 
-type SimpleACLDef struct {
-	Scope               SimpleACLScopeSet
-	InvisibleAttributes StringSet
+// AccessLevel returns the access level granted to the requester for the attribute of the target entry.
+func (s *Server) AccessLevel(ctx context.Context, m *ldap.Message, targetDN *DN, entry *SearchEntry, attr string) AccessLevel {
+	requester := newACLRequester(m)
+
+	if entry == nil {
+		entry = s.findACLEntry(ctx, targetDN)
+	}
+	return s.aclFor(targetDN).Access(requester, targetDN, entry, attr)
 }
 
-type SimpleACLScope int
+func (s *Server) hasAccess(ctx context.Context, requester *ACLRequester, targetDN *DN, entry *SearchEntry, attr string, level AccessLevel) bool {
+	if entry == nil {
+		entry = s.findACLEntry(ctx, targetDN)
+	}
+	return s.aclFor(targetDN).Access(requester, targetDN, entry, attr) >= level
+}
+
+// aclFor returns the access rules for the target entry.
+// The changelog has the changes of all entries, so only the rules for cn=changelog explicitly are used for it.
+func (s *Server) aclFor(targetDN *DN) *ACL {
+	acl := s.getACL()
+	if s.isChangelogDN(targetDN) {
+		return acl.explicitRules(s.changelogDN)
+	}
+	return acl
+}
+
+// CanProxy checks the requester can act as the identity by the proxied authorization.
+// It requires "proxy" level to "proxy" of the identity entry, the root DN can always proxy.
+func (s *Server) CanProxy(ctx context.Context, m *ldap.Message, dn *DN) bool {
+	requester := newACLRequester(m)
+
+	if requester.session.IsRoot {
+		return true
+	}
+	if requester.session.DN == nil {
+		return false
+	}
+	return s.hasAccess(ctx, requester, dn, nil, ACLAttrProxy, AccessProxy)
+}
+
+func (s *Server) hasParentAccess(ctx context.Context, requester *ACLRequester, targetDN *DN) bool {
+	parentDN := targetDN.ParentDN()
+	if parentDN == nil || !(parentDN.Equal(s.Suffix) || parentDN.IsSubOf(s.Suffix)) {
+		// The suffix entry doesn't have the parent entry
+		return requester.session.IsRoot
+	}
+	return s.hasAccess(ctx, requester, parentDN, nil, ACLAttrChildren, AccessWrite)
+}
+
+// findACLEntry fetches the entry only if the access rules have the filter.
+func (s *Server) findACLEntry(ctx context.Context, dn *DN) *SearchEntry {
+	if !s.getACL().hasFilter {
+		return nil
+	}
+	entry, err := s.Repo().FindByDN(ctx, dn, &SearchOption{
+		RequestedAssocation: []string{},
+		IsMemberOfRequested: true,
+	})
+	if err != nil {
+		log.Printf("info: Can't fetch the entry for the access rules. dn: %s, err: %v", dn.DNNormStr(), err)
+		return nil
+	}
+	return entry
+}
+
+func (s *Server) getACL() *ACL {
+	return s.aclStore.get()
+}
+
+// AccessLevel is the level of the access like OpenLDAP, the higher level includes the lower.
+// AccessProxy is the privilege only for "proxy" pseudo attribute, it isn't granted by manage.
+type AccessLevel int
 
 const (
-	ReadScope SimpleACLScope = iota
-	WriteScope
+	AccessNone AccessLevel = iota
+	AccessAuth
+	AccessCompare
+	AccessSearch
+	AccessRead
+	AccessWrite
+	AccessManage
+	AccessProxy
 )
 
-func (c SimpleACLScope) String() string {
-	switch c {
-	case ReadScope:
-		return "R"
-	case WriteScope:
-		return "W"
+func (l AccessLevel) String() string {
+	switch l {
+	case AccessNone:
+		return "none"
+	case AccessAuth:
+		return "auth"
+	case AccessCompare:
+		return "compare"
+	case AccessSearch:
+		return "search"
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessManage:
+		return "manage"
+	case AccessProxy:
+		return "proxy"
 	default:
 		return "unknown"
 	}
 }
 
-type SimpleACLScopeSet map[SimpleACLScope]struct{}
+func parseAccessLevel(s string) (AccessLevel, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return AccessNone, nil
+	case "auth":
+		return AccessAuth, nil
+	case "compare":
+		return AccessCompare, nil
+	case "search":
+		return AccessSearch, nil
+	case "read":
+		return AccessRead, nil
+	case "write":
+		return AccessWrite, nil
+	case "manage":
+		return AccessManage, nil
+	case "proxy":
+		return AccessProxy, nil
+	default:
+		return AccessNone, xerrors.Errorf("Invalid access level. Need none, auth, compare, search, read, write, manage or proxy: %s", s)
+	}
+}
+
+// ACLRequester is the subject of the access.
+type ACLRequester struct {
+	session *AuthSession
+	peerIP  net.IP
+}
 
-func (s SimpleACLScopeSet) Add(scope SimpleACLScope) {
-	s[scope] = struct{}{}
+func newACLRequester(m *ldap.Message) *ACLRequester {
+	r := &ACLRequester{
+		session: getAuthSession(m),
+	}
+	if ip := clientIP(m); ip != "" {
+		r.peerIP = net.ParseIP(ip)
+	}
+	return r
 }
 
-func (s SimpleACLScopeSet) Contains(scope SimpleACLScope) bool {
-	_, ok := s[scope]
-	return ok
+func (r *ACLRequester) String() string {
+	if r.session.DN == nil {
+		return "anonymous"
+	}
+	return r.session.DN.DNNormStr()
 }
 
-func NewSimpleACL(server *Server) (*SimpleACL, error) {
-	m := map[string]*SimpleACLDef{}
+// ACL is the ordered access rules, the first matched rule is used.
+type ACL struct {
+	schemaMap *SchemaMap
+	rules     []*ACLRule
+	hasFilter bool
+}
+
+// ACLRule is the rule like OpenLDAP's "access to <what> by <who> <level>".
+type ACLRule struct {
+	target *ACLTarget
+	by     []*ACLBy
+}
+
+// ACLTarget is <what> of the access rule.
+type ACLTarget struct {
+	dnStyle string
+	dn      *DN
+	dnRegex *regexp.Regexp
+	filter  message.Filter
+	// Normalized attribute names in lower case, nil means all attributes
+	attrs StringSet
+}
 
-	for _, d := range server.config.SimpleACL {
+// ACLBy is <who> and <level> of the access rule.
+type ACLBy struct {
+	subject ACLSubject
+	level   AccessLevel
+}
+
+type ACLSubjectType int
+
+const (
+	ACLSubjectAnyone ACLSubjectType = iota
+	ACLSubjectAnonymous
+	ACLSubjectUsers
+	ACLSubjectSelf
+	ACLSubjectDN
+	ACLSubjectGroup
+	ACLSubjectPeerName
+)
+
+// ACLSubject is <who> of the access rule.
+type ACLSubject struct {
+	subjectType ACLSubjectType
+	dnStyle     string
+	dn          *DN
+	dnRegex     *regexp.Regexp
+	peerNet     *net.IPNet
+}
+
+// Access returns the access level for the attribute of the target entry.
+// The entry is used for the filter of the access rules, the rule with the filter doesn't match if it's nil.
+func (a *ACL) Access(requester *ACLRequester, targetDN *DN, entry *SearchEntry, attr string) AccessLevel {
+	session := requester.session
+
+	if session.IsRoot {
+		return AccessManage
+	}
+	// The reset password must be changed before other operations
+	if session.MustChangePassword {
+		log.Printf("info: Not Authorized because the password must be changed. authorizedDN: %s, targetDN: %s", session.DN.DNNormStr(), targetDN.DNNormStr())
+		return AccessNone
+	}
+
+	attr = normalizeACLAttrName(a.schemaMap, attr)
+
+	for _, rule := range a.rules {
+		if !rule.target.match(a.schemaMap, targetDN, entry, attr) {
+			continue
+		}
+		for _, by := range rule.by {
+			if by.subject.match(requester, targetDN) {
+				return by.level
+			}
+		}
+		// Implicit "by * none"
+		return AccessNone
+	}
+	return AccessNone
+}
+
+// explicitRules returns the access rules whose <what> is the DN of the base entry or its subordinates.
+// The rules like "access to *" aren't included.
+func (a *ACL) explicitRules(base *DN) *ACL {
+	explicit := &ACL{
+		schemaMap: a.schemaMap,
+	}
+	for _, r := range a.rules {
+		t := r.target
+		switch t.dnStyle {
+		case "base", "one", "subtree", "children":
+			if t.dn.Equal(base) || t.dn.IsSubOf(base) {
+				explicit.rules = append(explicit.rules, r)
+				if t.filter != nil {
+					explicit.hasFilter = true
+				}
+			}
+		}
+	}
+	return explicit
+}
+
+// normalizeACLAttrName returns the attribute name in lower case for the access rules.
+func normalizeACLAttrName(schemaMap *SchemaMap, attr string) string {
+	if attr == ACLAttrEntry || attr == ACLAttrChildren || attr == ACLAttrProxy {
+		return attr
+	}
+	name, _, err := ParseLanguageTag(attr)
+	if err != nil {
+		return strings.ToLower(attr)
+	}
+	if at, ok := schemaMap.AttributeType(name); ok {
+		return strings.ToLower(at.Name)
+	}
+	return strings.ToLower(name)
+}
+
+func (t *ACLTarget) match(schemaMap *SchemaMap, targetDN *DN, entry *SearchEntry, attr string) bool {
+	if t.dnStyle != "" && !matchDNStyle(t.dnStyle, t.dn, t.dnRegex, targetDN) {
+		return false
+	}
+	if t.attrs != nil && !t.attrs.Contains(attr) {
+		return false
+	}
+	if t.filter != nil && (entry == nil || !matchFilter(schemaMap, t.filter, entry)) {
+		return false
+	}
+	return true
+}
+
+func (s *ACLSubject) match(requester *ACLRequester, targetDN *DN) bool {
+	session := requester.session
+
+	switch s.subjectType {
+	case ACLSubjectAnyone:
+		return true
+	case ACLSubjectAnonymous:
+		return session.DN == nil
+	case ACLSubjectUsers:
+		return session.DN != nil
+	case ACLSubjectSelf:
+		return session.DN != nil && session.DN.Equal(targetDN)
+	case ACLSubjectDN:
+		return session.DN != nil && matchDNStyle(s.dnStyle, s.dn, s.dnRegex, session.DN)
+	case ACLSubjectGroup:
+		for _, g := range session.Groups {
+			if g.Equal(s.dn) {
+				return true
+			}
+		}
+		return false
+	case ACLSubjectPeerName:
+		return requester.peerIP != nil && s.peerNet.Contains(requester.peerIP)
+	default:
+		return false
+	}
+}
+
+// matchDNStyle matches the DN with the style like OpenLDAP's dn.<style>.
+func matchDNStyle(style string, base *DN, regex *regexp.Regexp, dn *DN) bool {
+	switch style {
+	case "base":
+		return dn.Equal(base)
+	case "one":
+		parent := dn.ParentDN()
+		return parent != nil && parent.Equal(base)
+	case "subtree":
+		return dn.Equal(base) || dn.IsSubOf(base)
+	case "children":
+		return dn.IsSubOf(base)
+	case "regex":
+		return regex.MatchString(dn.DNNormStr())
+	default:
+		return false
+	}
+}
+
+// NewACL creates the access rules from the ACL file.
+// If the file isn't configured, the rules are converted from the simple ACL for backward compatibility.
+func NewACL(server *Server) (*ACL, error) {
+	if server.config.ACLFile != "" {
+		if len(server.config.SimpleACL) > 0 {
+			log.Printf("warn: Simple ACL is ignored because the ACL file is configured")
+		}
+		return LoadACLFile(server, server.config.ACLFile)
+	}
+	return convertSimpleACL(server, server.config.SimpleACL)
+}
+
+func newACL(server *Server, rules []*ACLRule) *ACL {
+	acl := &ACL{
+		schemaMap: server.schemaMap,
+		rules:     rules,
+	}
+	for _, r := range rules {
+		if r.target.filter != nil {
+			acl.hasFilter = true
+		}
+	}
+	return acl
+}
+
+type simpleACLDef struct {
+	dn                  *DN
+	level               AccessLevel
+	invisibleAttributes []string
+}
+
+// convertSimpleACL converts the simple ACL into the access rules.
+// The simple ACL is the format <DN(User, Group or empty(everyone))>:<Scope(R, W or RW)>:<Invisible Attributes>.
+// The user DN has priority over the group DN, then everyone. The user can always access the own entry.
+func convertSimpleACL(server *Server, defs []string) (*ACL, error) {
+	var list []*simpleACLDef
+	var defaultDef *simpleACLDef
+
+	for _, d := range defs {
 		s := strings.Split(d, ":")
 		if len(s) != 3 {
 			return nil, xerrors.Errorf("Invalid format. Need <DN(User, Group or empty(everyone))>:<Scope(R, W or RW)>:<Invisible Attributes>: %s", d)
 		}
 
-		scopeSet := SimpleACLScopeSet{}
+		def := &simpleACLDef{
+			level: AccessNone,
+		}
 		for _, v := range s[1] {
-			s := strings.ToUpper(string(v))
-
-			switch s {
+			switch strings.ToUpper(string(v)) {
 			case "R":
-				scopeSet.Add(ReadScope)
+				if def.level < AccessRead {
+					def.level = AccessRead
+				}
 			case "W":
-				scopeSet.Add(WriteScope)
+				def.level = AccessWrite
 			default:
 				return nil, xerrors.Errorf(`Invalid scope. Need "R", "W": %s`, d)
 			}
 		}
 
-		ia := strings.Split(s[2], ",")
-		iaSet := NewStringSet()
-		for _, v := range ia {
-			iaSet.Add(strings.ToLower(strings.TrimSpace(v)))
+		for _, v := range strings.Split(s[2], ",") {
+			if a := strings.TrimSpace(v); a != "" {
+				def.invisibleAttributes = append(def.invisibleAttributes, a)
+			}
 		}
 
 		if s[0] != "" {
@@ -158,79 +590,107 @@ func NewSimpleACL(server *Server) (*SimpleACL, error) {
 			if err != nil {
 				return nil, xerrors.Errorf(`Invalid DN format: %s`, d)
 			}
-			m[dn.DNNormStr()] = &SimpleACLDef{
-				Scope:               scopeSet,
-				InvisibleAttributes: iaSet,
-			}
+			def.dn = dn
+			list = append(list, def)
 		} else {
 			// For everyone
-			m["_DEFAULT_"] = &SimpleACLDef{
-				Scope:               scopeSet,
-				InvisibleAttributes: iaSet,
-			}
+			defaultDef = def
 		}
 	}
 
-	return &SimpleACL{
-		list: m,
-	}, nil
-}
-
-func (s *SimpleACL) CanRead(session *AuthSession) bool {
-	if session.IsRoot {
-		return true
+	if defaultDef != nil {
+		list = append(list, defaultDef)
 	}
 
-	if v, ok := s.list[session.DN.DNNormStr()]; ok {
-		return v.Scope.Contains(ReadScope)
-	}
-	for _, m := range session.Groups {
-		if v, ok := s.list[m.DNNormStr()]; ok {
-			return v.Scope.Contains(ReadScope)
+	acl := newACL(server, nil)
+
+	// Collect all invisible attributes to create the attribute rules
+	invisible := NewStringSet()
+	var invisibleOrder []string
+	for _, def := range list {
+		for _, a := range def.invisibleAttributes {
+			n := normalizeACLAttrName(server.schemaMap, a)
+			if !invisible.Contains(n) {
+				invisible.Add(n)
+				invisibleOrder = append(invisibleOrder, n)
+			}
 		}
 	}
-	if v, ok := s.list["_DEFAULT_"]; ok {
-		return v.Scope.Contains(ReadScope)
-	}
-	return false
-}
-
-func (s *SimpleACL) CanWrite(session *AuthSession) bool {
-	if session.IsRoot {
-		return true
-	}
 
-	if v, ok := s.list[session.DN.DNNormStr()]; ok {
-		return v.Scope.Contains(WriteScope)
-	}
-	for _, m := range session.Groups {
-		if v, ok := s.list[m.DNNormStr()]; ok {
-			return v.Scope.Contains(WriteScope)
+	byClauses := func(attr string) []*ACLBy {
+		var by []*ACLBy
+		var groups []*ACLBy
+		for _, def := range list {
+			level := def.level
+			if attr != "" {
+				for _, a := range def.invisibleAttributes {
+					if normalizeACLAttrName(server.schemaMap, a) == attr {
+						level = AccessNone
+					}
+				}
+			}
+			if def.dn == nil {
+				continue
+			}
+			by = append(by, &ACLBy{
+				subject: ACLSubject{subjectType: ACLSubjectDN, dnStyle: "base", dn: def.dn},
+				level:   level,
+			})
+			groups = append(groups, &ACLBy{
+				subject: ACLSubject{subjectType: ACLSubjectGroup, dn: def.dn},
+				level:   level,
+			})
 		}
+		by = append(by, groups...)
+		if defaultDef != nil {
+			level := defaultDef.level
+			for _, a := range defaultDef.invisibleAttributes {
+				if normalizeACLAttrName(server.schemaMap, a) == attr {
+					level = AccessNone
+				}
+			}
+			// Everyone means the authenticated users in the simple ACL
+			by = append(by, &ACLBy{
+				subject: ACLSubject{subjectType: ACLSubjectUsers},
+				level:   level,
+			})
+		}
+		return by
 	}
-	if v, ok := s.list["_DEFAULT_"]; ok {
-		return v.Scope.Contains(WriteScope)
-	}
-	return false
-}
-
-func (s *SimpleACL) CanVisible(session *AuthSession, attrName string) bool {
-	a := strings.ToLower(attrName)
 
-	if session.IsRoot {
-		return true
+	// The bind is processed as anonymous, the simple ACL doesn't restrict it
+	anonymousAuth := &ACLBy{
+		subject: ACLSubject{subjectType: ACLSubjectAnonymous},
+		level:   AccessAuth,
 	}
 
-	if v, ok := s.list[session.DN.DNNormStr()]; ok {
-		return !v.InvisibleAttributes.Contains(a)
-	}
-	for _, m := range session.Groups {
-		if v, ok := s.list[m.DNNormStr()]; ok {
-			return !v.InvisibleAttributes.Contains(a)
-		}
+	var rules []*ACLRule
+	for _, attr := range invisibleOrder {
+		by := byClauses(attr)
+		// The user who doesn't match any definition can see own attributes
+		by = append(by, &ACLBy{
+			subject: ACLSubject{subjectType: ACLSubjectSelf},
+			level:   AccessWrite,
+		})
+		rules = append(rules, &ACLRule{
+			target: &ACLTarget{attrs: NewStringSet(attr)},
+			by:     append(by, anonymousAuth),
+		})
 	}
-	if v, ok := s.list["_DEFAULT_"]; ok {
-		return !v.InvisibleAttributes.Contains(a)
+
+	by := []*ACLBy{
+		{
+			subject: ACLSubject{subjectType: ACLSubjectSelf},
+			level:   AccessWrite,
+		},
 	}
-	return true
+	by = append(by, byClauses("")...)
+	rules = append(rules, &ACLRule{
+		target: &ACLTarget{},
+		by:     append(by, anonymousAuth),
+	})
+
+	acl.rules = rules
+
+	return acl, nil
 }
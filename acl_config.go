@@ -0,0 +1,366 @@
+package ldap_pg
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// LoadACLFile loads the access rules from the file.
+// The format is like OpenLDAP's access directive, the line which starts with whitespace continues the previous line.
+//
+//	# Comment
+//	access to dn.subtree="ou=Users,dc=example,dc=com" attrs=userPassword
+//	    by self write
+//	    by anonymous auth
+//	    by * none
+//	access to *
+//	    by group="cn=admins,ou=Groups,dc=example,dc=com" write
+//	    by users read
+func LoadACLFile(server *Server, path string) (*ACL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to open the ACL file. path: %s, err: %w", path, err)
+	}
+	defer f.Close()
+
+	return ParseACL(server, f)
+}
+
+// ParseACL parses the access rules.
+func ParseACL(server *Server, r io.Reader) (*ACL, error) {
+	var directives []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(directives) > 0 {
+			directives[len(directives)-1] += " " + trimmed
+		} else {
+			directives = append(directives, trimmed)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("Failed to read the ACL. err: %w", err)
+	}
+
+	return parseACLDirectives(server, directives)
+}
+
+func parseACLDirectives(server *Server, directives []string) (*ACL, error) {
+	rules := make([]*ACLRule, len(directives))
+	for i, d := range directives {
+		rule, err := parseACLRule(server, d)
+		if err != nil {
+			return nil, xerrors.Errorf("Invalid access rule #%d: %s, err: %w", i, d, err)
+		}
+		rules[i] = rule
+	}
+	return newACL(server, rules), nil
+}
+
+// parseACLAttrValues parses the values of olcAccess attribute like "{0}to * by users read".
+// The rules are ordered by the {n} prefix, the values without the prefix follow as they are.
+func parseACLAttrValues(server *Server, values []string) (*ACL, error) {
+	type orderedRule struct {
+		index int
+		rule  *ACLRule
+	}
+
+	ordered := make([]orderedRule, len(values))
+	for i, v := range values {
+		index, rule, err := parseACLAttrValue(server, v)
+		if err != nil {
+			return nil, xerrors.Errorf("Invalid access rule #%d: %s, err: %w", i, v, err)
+		}
+		if index < 0 {
+			index = math.MaxInt32
+		}
+		ordered[i] = orderedRule{index, rule}
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].index < ordered[j].index
+	})
+
+	rules := make([]*ACLRule, len(ordered))
+	for i, o := range ordered {
+		rules[i] = o.rule
+	}
+	return newACL(server, rules), nil
+}
+
+// parseACLAttrValue parses the value of olcAccess attribute. "access" keyword can be omitted.
+// It returns the index of the {n} prefix, or -1 if the value doesn't have it.
+func parseACLAttrValue(server *Server, value string) (int, *ACLRule, error) {
+	index := -1
+	directive := strings.TrimSpace(value)
+
+	if strings.HasPrefix(directive, "{") {
+		i := strings.Index(directive, "}")
+		if i == -1 {
+			return -1, nil, xerrors.Errorf("Invalid index: %s", value)
+		}
+		n, err := strconv.Atoi(directive[1:i])
+		if err != nil || n < 0 {
+			return -1, nil, xerrors.Errorf("Invalid index: %s", value)
+		}
+		index = n
+		directive = strings.TrimSpace(directive[i+1:])
+	}
+
+	if !strings.HasPrefix(strings.ToLower(directive), "access ") {
+		directive = "access " + directive
+	}
+
+	rule, err := parseACLRule(server, directive)
+	if err != nil {
+		return -1, nil, err
+	}
+	return index, rule, nil
+}
+
+// parseACLRule parses "access to <what> [by <who> <level>]+".
+func parseACLRule(server *Server, directive string) (*ACLRule, error) {
+	tokens, err := tokenizeACL(directive)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) < 3 || !strings.EqualFold(tokens[0], "access") || !strings.EqualFold(tokens[1], "to") {
+		return nil, xerrors.Errorf(`Need "access to <what> [by <who> <level>]+"`)
+	}
+
+	rule := &ACLRule{
+		target: &ACLTarget{},
+	}
+
+	i := 2
+	for ; i < len(tokens) && !strings.EqualFold(tokens[i], "by"); i++ {
+		if err := parseACLTarget(server, rule.target, tokens[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	for i < len(tokens) {
+		// by <who> <level>
+		if i+2 >= len(tokens) || !strings.EqualFold(tokens[i], "by") {
+			return nil, xerrors.Errorf(`Need "by <who> <level>": %s`, strings.Join(tokens[i:], " "))
+		}
+		subject, err := parseACLSubject(server, tokens[i+1])
+		if err != nil {
+			return nil, err
+		}
+		level, err := parseAccessLevel(tokens[i+2])
+		if err != nil {
+			return nil, err
+		}
+		// The proxy privilege must not be mixed with the access to the attributes
+		if level == AccessProxy && (rule.target.attrs == nil || rule.target.attrs.Size() != 1 || !rule.target.attrs.Contains(ACLAttrProxy)) {
+			return nil, xerrors.Errorf(`"proxy" level can be used only for attrs=%s`, ACLAttrProxy)
+		}
+		rule.by = append(rule.by, &ACLBy{
+			subject: *subject,
+			level:   level,
+		})
+		i += 3
+	}
+
+	return rule, nil
+}
+
+func parseACLTarget(server *Server, target *ACLTarget, token string) error {
+	if token == "*" {
+		return nil
+	}
+
+	key, value, err := splitACLKeyValue(token)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case key == "filter":
+		f, err := compileFilter(value)
+		if err != nil {
+			return xerrors.Errorf("Invalid filter: %s, err: %w", value, err)
+		}
+		target.filter = f
+	case key == "attrs" || key == "attr":
+		target.attrs = NewStringSet()
+		for _, a := range strings.Split(value, ",") {
+			a = strings.TrimSpace(a)
+			if a == "" {
+				continue
+			}
+			target.attrs.Add(normalizeACLAttrName(server.schemaMap, a))
+		}
+	case key == "dn" || strings.HasPrefix(key, "dn."):
+		style, dn, regex, err := parseACLDN(server, key, value)
+		if err != nil {
+			return err
+		}
+		target.dnStyle = style
+		target.dn = dn
+		target.dnRegex = regex
+	default:
+		return xerrors.Errorf("Unsupported target: %s", token)
+	}
+	return nil
+}
+
+func parseACLSubject(server *Server, token string) (*ACLSubject, error) {
+	switch strings.ToLower(token) {
+	case "*":
+		return &ACLSubject{subjectType: ACLSubjectAnyone}, nil
+	case "anonymous":
+		return &ACLSubject{subjectType: ACLSubjectAnonymous}, nil
+	case "users":
+		return &ACLSubject{subjectType: ACLSubjectUsers}, nil
+	case "self":
+		return &ACLSubject{subjectType: ACLSubjectSelf}, nil
+	}
+
+	key, value, err := splitACLKeyValue(token)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case key == "dn" || strings.HasPrefix(key, "dn."):
+		style, dn, regex, err := parseACLDN(server, key, value)
+		if err != nil {
+			return nil, err
+		}
+		return &ACLSubject{subjectType: ACLSubjectDN, dnStyle: style, dn: dn, dnRegex: regex}, nil
+	case key == "group":
+		dn, err := server.NormalizeDN(value)
+		if err != nil {
+			return nil, xerrors.Errorf("Invalid group DN: %s, err: %w", value, err)
+		}
+		return &ACLSubject{subjectType: ACLSubjectGroup, dn: dn}, nil
+	case key == "peername" || key == "peername.ip":
+		peerNet, err := parseACLPeerName(value)
+		if err != nil {
+			return nil, err
+		}
+		return &ACLSubject{subjectType: ACLSubjectPeerName, peerNet: peerNet}, nil
+	default:
+		return nil, xerrors.Errorf("Unsupported subject: %s", token)
+	}
+}
+
+// parseACLDN parses dn[.<style>]=<pattern>. The style is one of base (exact), one (onelevel),
+// subtree (sub), children or regex. The pattern without the style is base.
+func parseACLDN(server *Server, key, value string) (string, *DN, *regexp.Regexp, error) {
+	style := "base"
+	if strings.HasPrefix(key, "dn.") {
+		style = strings.TrimPrefix(key, "dn.")
+	}
+
+	switch style {
+	case "base", "exact":
+		style = "base"
+	case "one", "onelevel":
+		style = "one"
+	case "sub", "subtree":
+		style = "subtree"
+	case "children":
+	case "regex":
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			return "", nil, nil, xerrors.Errorf("Invalid DN regex: %s, err: %w", value, err)
+		}
+		return style, nil, regex, nil
+	default:
+		return "", nil, nil, xerrors.Errorf("Unsupported DN style: %s", key)
+	}
+
+	dn, err := server.NormalizeDN(value)
+	if err != nil {
+		return "", nil, nil, xerrors.Errorf("Invalid DN: %s, err: %w", value, err)
+	}
+	return style, dn, nil, nil
+}
+
+// parseACLPeerName parses the IP address or CIDR.
+func parseACLPeerName(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, peerNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, xerrors.Errorf("Invalid peername: %s, err: %w", value, err)
+		}
+		return peerNet, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, xerrors.Errorf("Invalid peername: %s", value)
+	}
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func splitACLKeyValue(token string) (string, string, error) {
+	i := strings.Index(token, "=")
+	if i == -1 {
+		return "", "", xerrors.Errorf("Need <key>=<value>: %s", token)
+	}
+	return strings.ToLower(token[:i]), token[i+1:], nil
+}
+
+// tokenizeACL splits the directive by whitespace. The value can be quoted by double quotes
+// to contain whitespace, e.g. dn.subtree="ou=Users, dc=example, dc=com".
+// Backslashes are kept as they are except for the escaped double quote to use them in the regex.
+func tokenizeACL(directive string) ([]string, error) {
+	var tokens []string
+	var sb strings.Builder
+	inQuote := false
+	inToken := false
+
+	for i := 0; i < len(directive); i++ {
+		c := directive[i]
+		switch {
+		case c == '\\' && inQuote && i+1 < len(directive) && directive[i+1] == '"':
+			// Escaped double quote in the quoted value
+			i++
+			sb.WriteByte(directive[i])
+		case c == '"':
+			inQuote = !inQuote
+			inToken = true
+		case (c == ' ' || c == '\t') && !inQuote:
+			if inToken {
+				tokens = append(tokens, sb.String())
+				sb.Reset()
+				inToken = false
+			}
+		default:
+			sb.WriteByte(c)
+			inToken = true
+		}
+	}
+	if inQuote {
+		return nil, xerrors.Errorf("Unclosed quote")
+	}
+	if inToken {
+		tokens = append(tokens, sb.String())
+	}
+	return tokens, nil
+}
@@ -0,0 +1,184 @@
+package ldap_pg
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// aclReloadInterval is the interval to check the ACL entry is changed by the other instances.
+const aclReloadInterval = 30 * time.Second
+
+// aclStore holds the current access rules.
+// The rules stored in the directory can be reloaded without restarting the server.
+type aclStore struct {
+	mu sync.RWMutex
+	// reloadMu serializes the reloading by the local changes and the periodic check
+	reloadMu sync.Mutex
+	// The entry which has the access rules in olcAccess attribute, nil if it isn't configured
+	dn *DN
+	// The access rules from the ACL file or the simple ACL, they are used if the entry doesn't have the rules
+	base *ACL
+	acl  *ACL
+	// olcAccess values of the current rules, nil if the base rules are used.
+	// It's the version to check the ACL entry is changed
+	values []string
+}
+
+func (a *aclStore) get() *ACL {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.acl
+}
+
+func (a *aclStore) set(acl *ACL, values []string) {
+	a.mu.Lock()
+	a.acl = acl
+	a.values = values
+	a.mu.Unlock()
+}
+
+// changed returns true if the olcAccess values differ from the current rules.
+func (a *aclStore) changed(values []string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return !reflect.DeepEqual(a.values, values)
+}
+
+// initACL loads the access rules from the ACL file or the simple ACL,
+// then the rules stored in the directory if the ACL entry is configured.
+func (s *Server) initACL() error {
+	base, err := NewACL(s)
+	if err != nil {
+		return err
+	}
+
+	store := &aclStore{
+		base: base,
+		acl:  base,
+	}
+
+	if s.config.ACLDN != "" {
+		store.dn, err = s.NormalizeDN(s.config.ACLDN)
+		if err != nil {
+			return xerrors.Errorf("Invalid acl-dn: %s, err: %w", s.config.ACLDN, err)
+		}
+	}
+
+	s.aclStore = store
+
+	if store.dn != nil {
+		if err := s.reloadACL(context.Background()); err != nil {
+			return err
+		}
+		// The ACL entry can be changed by the other instances sharing the database
+		go s.reloadACLLoop()
+	}
+	return nil
+}
+
+func (s *Server) reloadACLLoop() {
+	ticker := time.NewTicker(aclReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.reloadACL(context.Background()); err != nil {
+			log.Printf("error: Failed to reload the ACL, keep the current ACL. err: %+v", err)
+		}
+	}
+}
+
+// reloadACL reloads the access rules from olcAccess attribute of the ACL entry if it's changed.
+// The rules from the ACL file or the simple ACL are used if the entry or the attribute doesn't exist.
+func (s *Server) reloadACL(ctx context.Context) error {
+	store := s.aclStore
+
+	store.reloadMu.Lock()
+	defer store.reloadMu.Unlock()
+
+	entry, err := s.Repo().FindByDN(ctx, store.dn, &SearchOption{
+		RequestedAssocation: []string{},
+	})
+	if err != nil {
+		var ldapErr *LDAPError
+		if ok := xerrors.As(err, &ldapErr); ok && ldapErr.IsNoSuchObjectError() {
+			if store.changed(nil) {
+				log.Printf("info: No ACL entry, use the ACL from the configuration. dn: %s", store.dn.DNNormStr())
+				store.set(store.base, nil)
+			}
+			return nil
+		}
+		return xerrors.Errorf("Failed to fetch the ACL entry. dn: %s, err: %w", store.dn.DNNormStr(), err)
+	}
+
+	_, values, ok := entry.GetAttrOrig("olcAccess")
+	if !ok || len(values) == 0 {
+		if store.changed(nil) {
+			log.Printf("info: No olcAccess in the ACL entry, use the ACL from the configuration. dn: %s", store.dn.DNNormStr())
+			store.set(store.base, nil)
+		}
+		return nil
+	}
+
+	if !store.changed(values) {
+		return nil
+	}
+
+	acl, err := parseACLAttrValues(s, values)
+	if err != nil {
+		return xerrors.Errorf("Invalid ACL entry. dn: %s, err: %w", store.dn.DNNormStr(), err)
+	}
+	store.set(acl, values)
+
+	log.Printf("info: Loaded the ACL from the entry. dn: %s, rules: %d", store.dn.DNNormStr(), len(acl.rules))
+
+	return nil
+}
+
+// reloadACLIfChanged reloads the access rules if one of the updated entries is the ACL entry.
+// The current rules are kept if the reloading failed.
+func (s *Server) reloadACLIfChanged(ctx context.Context, updated ...*DN) {
+	if !s.isACLEntry(updated...) {
+		return
+	}
+	if err := s.reloadACL(ctx); err != nil {
+		log.Printf("error: Failed to reload the ACL, keep the current ACL. err: %+v", err)
+	}
+}
+
+func (s *Server) isACLEntry(dns ...*DN) bool {
+	aclDN := s.aclStore.dn
+	if aclDN == nil {
+		return false
+	}
+	for _, dn := range dns {
+		if dn != nil && dn.Equal(aclDN) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateACLEntry validates olcAccess attribute before storing the ACL entry.
+func validateACLEntry(s *Server, dn *DN, attrs map[string]*SchemaValue) error {
+	if !s.isACLEntry(dn) {
+		return nil
+	}
+	sv, ok := attrs["olcAccess"]
+	if !ok {
+		return nil
+	}
+	for i, v := range sv.Orig() {
+		if _, _, err := parseACLAttrValue(s, v); err != nil {
+			log.Printf("info: Invalid access rule. dn: %s, value: %s, err: %v", dn.DNNormStr(), v, err)
+			return NewInvalidAccessRule(i, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,517 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func newACLTestServer(t *testing.T) *Server {
+	server := NewServer(&ServerConfig{
+		Suffix: "dc=example,dc=com",
+	})
+	server.schemaMap = InitSchemaMap(server)
+	return server
+}
+
+func newACLTestRequester(t *testing.T, server *Server, dn string, groups []string, peerIP string) *ACLRequester {
+	session := &AuthSession{}
+	if dn != "" {
+		d, err := server.NormalizeDN(dn)
+		if err != nil {
+			t.Fatalf("Invalid DN: %s, err: %v", dn, err)
+		}
+		session.DN = d
+	}
+	for _, g := range groups {
+		d, err := server.NormalizeDN(g)
+		if err != nil {
+			t.Fatalf("Invalid group DN: %s, err: %v", g, err)
+		}
+		session.Groups = append(session.Groups, d)
+	}
+	return &ACLRequester{
+		session: session,
+		peerIP:  net.ParseIP(peerIP),
+	}
+}
+
+func TestACLAccess(t *testing.T) {
+	server := newACLTestServer(t)
+
+	acl, err := ParseACL(server, strings.NewReader(`
+# Password
+access to attrs=userPassword
+    by self write
+    by anonymous auth
+    by * none
+access to dn.subtree="ou=Users,dc=example,dc=com" filter=(employeeType=manager)
+    by group="cn=admins,ou=Groups,dc=example,dc=com" write
+    by users read
+access to dn.children="ou=Users,dc=example,dc=com"
+    by group="cn=admins,ou=Groups,dc=example,dc=com" write
+    by peername.ip=192.168.0.0/16 read
+    by users search
+access to dn.regex="^cn=[^,]+,ou=groups,dc=example,dc=com$"
+    by dn.one="ou=Users,dc=example,dc=com" compare
+access to *
+    by users read
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	userDN := "uid=user1,ou=Users,dc=example,dc=com"
+	adminDN := "uid=admin,ou=Users,dc=example,dc=com"
+	adminGroup := "cn=admins,ou=Groups,dc=example,dc=com"
+
+	manager := NewSearchEntry(server.schemaMap, "uid=user1,ou=Users", map[string][]string{
+		"employeeType": {"Manager"},
+	})
+
+	testcases := []struct {
+		Requester string
+		Groups    []string
+		PeerIP    string
+		Target    string
+		Entry     *SearchEntry
+		Attr      string
+		Expected  AccessLevel
+	}{
+		{userDN, nil, "", userDN, nil, "userPassword", AccessWrite},
+		{"", nil, "", userDN, nil, "userPassword", AccessAuth},
+		{adminDN, []string{adminGroup}, "", userDN, nil, "USERPASSWORD", AccessNone},
+		{adminDN, []string{adminGroup}, "", userDN, manager, "cn", AccessWrite},
+		{adminDN, nil, "", userDN, manager, "cn", AccessRead},
+		{adminDN, nil, "", userDN, nil, "cn", AccessSearch},
+		{adminDN, nil, "192.168.1.1", userDN, nil, "cn", AccessRead},
+		{adminDN, nil, "10.0.0.1", userDN, nil, "cn", AccessSearch},
+		{"", nil, "", userDN, nil, "cn", AccessNone},
+		{userDN, nil, "", "ou=Users,dc=example,dc=com", nil, ACLAttrChildren, AccessRead},
+		{userDN, nil, "", "cn=group1,ou=Groups,dc=example,dc=com", nil, "member", AccessCompare},
+		{"cn=other,dc=example,dc=com", nil, "", "cn=group1,ou=Groups,dc=example,dc=com", nil, "member", AccessNone},
+		{userDN, nil, "", "dc=example,dc=com", nil, ACLAttrEntry, AccessRead},
+	}
+
+	for i, tc := range testcases {
+		requester := newACLTestRequester(t, server, tc.Requester, tc.Groups, tc.PeerIP)
+		target, err := server.NormalizeDN(tc.Target)
+		if err != nil {
+			t.Fatalf("Invalid target DN on %d: %v", i, err)
+		}
+		if level := acl.Access(requester, target, tc.Entry, tc.Attr); level != tc.Expected {
+			t.Errorf("Unexpected access level on %d: expected %s, got %s", i, tc.Expected, level)
+		}
+	}
+
+	// Root can do everything, the user who must change the password can do nothing
+	root := &ACLRequester{session: &AuthSession{IsRoot: true}}
+	if level := acl.Access(root, server.Suffix, nil, "userPassword"); level != AccessManage {
+		t.Errorf("Unexpected access level for root: %s", level)
+	}
+	reset := newACLTestRequester(t, server, userDN, nil, "")
+	reset.session.MustChangePassword = true
+	if level := acl.Access(reset, reset.session.DN, nil, "cn"); level != AccessNone {
+		t.Errorf("Unexpected access level for the reset password: %s", level)
+	}
+}
+
+func TestACLProxy(t *testing.T) {
+	server := newACLTestServer(t)
+
+	acl, err := ParseACL(server, strings.NewReader(`
+access to dn.subtree="ou=Users,dc=example,dc=com" attrs=proxy
+    by dn="cn=webapp,dc=example,dc=com" proxy
+    by * none
+access to *
+    by users manage
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	userDN := "uid=user1,ou=Users,dc=example,dc=com"
+
+	testcases := []struct {
+		Requester string
+		Target    string
+		Expected  bool
+	}{
+		{"cn=webapp,dc=example,dc=com", userDN, true},
+		{"cn=webapp,dc=example,dc=com", "cn=admin,dc=example,dc=com", false},
+		{"cn=other,dc=example,dc=com", userDN, false},
+		{"", userDN, false},
+	}
+
+	for i, tc := range testcases {
+		requester := newACLTestRequester(t, server, tc.Requester, nil, "")
+		target, err := server.NormalizeDN(tc.Target)
+		if err != nil {
+			t.Fatalf("Invalid target DN on %d: %v", i, err)
+		}
+		if ok := acl.Access(requester, target, nil, ACLAttrProxy) >= AccessProxy; ok != tc.Expected {
+			t.Errorf("Unexpected proxy privilege on %d: expected %v, got %v", i, tc.Expected, ok)
+		}
+	}
+}
+
+func TestACLSearch(t *testing.T) {
+	server := newACLTestServer(t)
+
+	acl, err := ParseACL(server, strings.NewReader(`
+access to attrs=userPassword
+    by self write
+    by * auth
+access to attrs=mail
+    by users search
+access to *
+    by users read
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server.aclStore = &aclStore{acl: acl}
+
+	filter, err := compileFilter("(&(mail=user1@example.com)(|(cn=user1)(!(userPassword=*))))")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	attrs := filterAttributes(filter)
+	if strings.Join(attrs, ",") != "mail,cn,userPassword" {
+		t.Fatalf("Unexpected filter attributes: %v", attrs)
+	}
+
+	userDN, _ := server.NormalizeDN("uid=user1,ou=Users,dc=example,dc=com")
+	requester := newACLTestRequester(t, server, "uid=user2,ou=Users,dc=example,dc=com", nil, "")
+
+	testcases := []struct {
+		Attr     string
+		Expected bool
+	}{
+		{"mail", true},
+		{"cn", true},
+		{"userPassword", false},
+	}
+
+	for i, tc := range testcases {
+		if ok := server.hasAccess(context.Background(), requester, userDN, nil, tc.Attr, AccessSearch); ok != tc.Expected {
+			t.Errorf("Unexpected search access on %d: expected %v, got %v", i, tc.Expected, ok)
+		}
+	}
+
+	// search doesn't include read
+	if server.hasAccess(context.Background(), requester, userDN, nil, "mail", AccessRead) {
+		t.Errorf("Unexpected read access to mail")
+	}
+}
+
+func TestACLAuth(t *testing.T) {
+	server := newACLTestServer(t)
+
+	acl, err := ParseACL(server, strings.NewReader(`
+access to dn.subtree="ou=Locked,dc=example,dc=com" attrs=userPassword
+    by * none
+access to attrs=userPassword
+    by peername.ip=10.0.0.0/8 none
+    by anonymous auth
+access to *
+    by users read
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server.aclStore = &aclStore{acl: acl}
+
+	testcases := []struct {
+		Target   string
+		ClientIP string
+		Expected bool
+	}{
+		{"uid=user1,ou=Users,dc=example,dc=com", "", true},
+		{"uid=user1,ou=Users,dc=example,dc=com", "192.168.0.1", true},
+		{"uid=user1,ou=Users,dc=example,dc=com", "10.0.0.1", false},
+		{"uid=user1,ou=Locked,dc=example,dc=com", "", false},
+	}
+
+	for i, tc := range testcases {
+		target, err := server.NormalizeDN(tc.Target)
+		if err != nil {
+			t.Fatalf("Invalid target DN on %d: %v", i, err)
+		}
+		ctx := context.WithValue(context.Background(), clientIPContextKey, tc.ClientIP)
+		if ok := server.CanAuth(ctx, target); ok != tc.Expected {
+			t.Errorf("Unexpected auth access on %d: expected %v, got %v", i, tc.Expected, ok)
+		}
+	}
+}
+
+func TestACLManage(t *testing.T) {
+	server := newACLTestServer(t)
+
+	aclDN, _ := server.NormalizeDN("olcDatabase={1}pg,ou=config,dc=example,dc=com")
+	otherDN, _ := server.NormalizeDN("cn=other,ou=config,dc=example,dc=com")
+	userDN, _ := server.NormalizeDN("uid=user1,ou=Users,dc=example,dc=com")
+
+	acl, err := ParseACL(server, strings.NewReader(`
+access to dn.subtree="ou=config,dc=example,dc=com"
+    by dn="cn=admin,dc=example,dc=com" manage
+    by users write
+access to *
+    by dn="cn=admin,dc=example,dc=com" manage
+    by users write
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server.aclStore = &aclStore{dn: aclDN, acl: acl}
+
+	testcases := []struct {
+		Target   *DN
+		Attr     string
+		Expected AccessLevel
+	}{
+		{userDN, ACLAttrEntry, AccessWrite},
+		{userDN, "cn", AccessWrite},
+		{userDN, "pwdPolicySubentry", AccessManage},
+		{userDN, "pwdAccountLockedTime", AccessManage},
+		{aclDN, "olcAccess", AccessManage},
+		{otherDN, "olcAccess", AccessWrite},
+	}
+
+	admin := newACLTestRequester(t, server, "cn=admin,dc=example,dc=com", nil, "")
+	user := newACLTestRequester(t, server, "uid=user2,ou=Users,dc=example,dc=com", nil, "")
+
+	for i, tc := range testcases {
+		level := server.writeAccessLevel(tc.Target, tc.Attr)
+		if level != tc.Expected {
+			t.Errorf("Unexpected write access level on %d: expected %s, got %s", i, tc.Expected, level)
+		}
+		if !server.hasAccess(context.Background(), admin, tc.Target, nil, tc.Attr, level) {
+			t.Errorf("Unexpected denied access on %d: %s", i, tc.Attr)
+		}
+		if ok := server.hasAccess(context.Background(), user, tc.Target, nil, tc.Attr, level); ok != (level == AccessWrite) {
+			t.Errorf("Unexpected access on %d: expected %v, got %v", i, level == AccessWrite, ok)
+		}
+	}
+}
+
+func TestACLChangelog(t *testing.T) {
+	server := newACLTestServer(t)
+	server.changelogDN, _ = server.NormalizeDN(ChangelogDN)
+
+	changeDN, _ := server.NormalizeDN("changeNumber=1,cn=changelog")
+	userDN, _ := server.NormalizeDN("uid=user1,ou=Users,dc=example,dc=com")
+
+	testcases := []struct {
+		Rules     string
+		Requester string
+		Target    *DN
+		Expected  AccessLevel
+	}{
+		// "access to *" doesn't grant the access to the changelog
+		{"access to * by users read", "uid=user2,ou=Users,dc=example,dc=com", changeDN, AccessNone},
+		{"access to * by users read", "uid=user2,ou=Users,dc=example,dc=com", userDN, AccessRead},
+		{"access to dn.regex=.* by users read", "uid=user2,ou=Users,dc=example,dc=com", changeDN, AccessNone},
+		// Explicit rule for the changelog
+		{`access to dn.subtree="cn=changelog" by dn="cn=auditor,dc=example,dc=com" read
+access to * by users read`, "cn=auditor,dc=example,dc=com", changeDN, AccessRead},
+		{`access to dn.subtree="cn=changelog" by dn="cn=auditor,dc=example,dc=com" read
+access to * by users read`, "uid=user2,ou=Users,dc=example,dc=com", changeDN, AccessNone},
+		{`access to dn.children="cn=changelog" by users read`, "uid=user2,ou=Users,dc=example,dc=com", changeDN, AccessRead},
+	}
+
+	for i, tc := range testcases {
+		acl, err := ParseACL(server, strings.NewReader(tc.Rules))
+		if err != nil {
+			t.Fatalf("Unexpected error on %d: %v", i, err)
+		}
+		server.aclStore = &aclStore{acl: acl}
+
+		requester := newACLTestRequester(t, server, tc.Requester, nil, "")
+		if level := server.aclFor(tc.Target).Access(requester, tc.Target, nil, ACLAttrEntry); level != tc.Expected {
+			t.Errorf("Unexpected access level on %d: expected %s, got %s", i, tc.Expected, level)
+		}
+	}
+
+	// The root DN can always access the changelog
+	root := &ACLRequester{session: &AuthSession{IsRoot: true}}
+	if level := server.aclFor(changeDN).Access(root, changeDN, nil, ACLAttrEntry); level != AccessManage {
+		t.Errorf("Unexpected access level for root: %s", level)
+	}
+}
+
+func TestParseACLInvalid(t *testing.T) {
+	server := newACLTestServer(t)
+
+	testcases := []string{
+		`to * by * read`,
+		`access to * by * readonly`,
+		`access to * by *`,
+		`access to dn.unknown="dc=example,dc=com" by * read`,
+		`access to dn.regex="(" by * read`,
+		`access to filter=(cn=a by * read`,
+		`access to * by peername=999.0.0.1 read`,
+		`access to dn="dc=example,dc=com by * read`,
+		`access to * by * proxy`,
+		`access to attrs=proxy,cn by * proxy`,
+	}
+
+	for i, tc := range testcases {
+		if _, err := ParseACL(server, strings.NewReader(tc)); err == nil {
+			t.Errorf("Expected error on %d: %s", i, tc)
+		}
+	}
+}
+
+func TestConvertSimpleACL(t *testing.T) {
+	server := newACLTestServer(t)
+
+	acl, err := convertSimpleACL(server, []string{
+		"cn=reader,dc=example,dc=com:R:userPassword",
+		"cn=writers,ou=Groups,dc=example,dc=com:RW:",
+		":R:userPassword,mail",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	userDN := "uid=user1,ou=Users,dc=example,dc=com"
+
+	testcases := []struct {
+		Requester string
+		Groups    []string
+		Target    string
+		Attr      string
+		Expected  AccessLevel
+	}{
+		{"cn=reader,dc=example,dc=com", nil, userDN, "cn", AccessRead},
+		{"cn=reader,dc=example,dc=com", nil, userDN, "userPassword", AccessNone},
+		{"cn=reader,dc=example,dc=com", nil, userDN, "mail", AccessRead},
+		{"uid=writer,dc=example,dc=com", []string{"cn=writers,ou=Groups,dc=example,dc=com"}, userDN, "userPassword", AccessWrite},
+		{"uid=writer,dc=example,dc=com", []string{"cn=writers,ou=Groups,dc=example,dc=com"}, "ou=Users,dc=example,dc=com", ACLAttrChildren, AccessWrite},
+		{"uid=other,dc=example,dc=com", nil, userDN, "cn", AccessRead},
+		{"uid=other,dc=example,dc=com", nil, userDN, "mail", AccessNone},
+		{userDN, nil, userDN, "cn", AccessWrite},
+		{userDN, nil, userDN, "mail", AccessNone},
+		{"", nil, userDN, "cn", AccessAuth},
+		{"", nil, userDN, "userPassword", AccessAuth},
+	}
+
+	for i, tc := range testcases {
+		requester := newACLTestRequester(t, server, tc.Requester, tc.Groups, "")
+		target, err := server.NormalizeDN(tc.Target)
+		if err != nil {
+			t.Fatalf("Invalid target DN on %d: %v", i, err)
+		}
+		if level := acl.Access(requester, target, nil, tc.Attr); level != tc.Expected {
+			t.Errorf("Unexpected access level on %d: expected %s, got %s", i, tc.Expected, level)
+		}
+	}
+}
+
+func TestParseACLAttrValues(t *testing.T) {
+	server := newACLTestServer(t)
+
+	acl, err := parseACLAttrValues(server, []string{
+		"{1}to * by users read",
+		"to attrs=mail by * none",
+		"{0}access to attrs=userPassword by self write by anonymous auth",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	userDN := "uid=user1,ou=Users,dc=example,dc=com"
+	target, _ := server.NormalizeDN(userDN)
+
+	testcases := []struct {
+		Requester string
+		Attr      string
+		Expected  AccessLevel
+	}{
+		{userDN, "userPassword", AccessWrite},
+		{"", "userPassword", AccessAuth},
+		{"uid=other,dc=example,dc=com", "cn", AccessRead},
+		// The rule without the index follows the ordered rules
+		{"uid=other,dc=example,dc=com", "mail", AccessRead},
+		{"", "mail", AccessNone},
+	}
+
+	for i, tc := range testcases {
+		requester := newACLTestRequester(t, server, tc.Requester, nil, "")
+		if level := acl.Access(requester, target, nil, tc.Attr); level != tc.Expected {
+			t.Errorf("Unexpected access level on %d: expected %s, got %s", i, tc.Expected, level)
+		}
+	}
+
+	for i, tc := range []string{"{a}to * by * read", "{0 to * by * read", "{0}to * by * all"} {
+		if _, err := parseACLAttrValues(server, []string{tc}); err == nil {
+			t.Errorf("Expected error on %d: %s", i, tc)
+		}
+	}
+}
+
+func TestValidateACLEntry(t *testing.T) {
+	server := newACLTestServer(t)
+
+	aclDN, _ := server.NormalizeDN("olcDatabase={1}pg,ou=config,dc=example,dc=com")
+	otherDN, _ := server.NormalizeDN("cn=other,ou=config,dc=example,dc=com")
+	server.aclStore = &aclStore{dn: aclDN}
+
+	invalid, err := NewSchemaValue(server.schemaMap, "olcAccess", []string{"{0}to * by users read", "{1}to * by users all"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	attrs := map[string]*SchemaValue{"olcAccess": invalid}
+
+	err = validateACLEntry(server, aclDN, attrs)
+	if err == nil {
+		t.Fatalf("Expected error for the invalid access rule")
+	}
+	if lerr, ok := err.(*LDAPError); !ok || lerr.Code != 21 || !strings.Contains(lerr.Msg, "value #1") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Other entries aren't validated
+	if err := validateACLEntry(server, otherDN, attrs); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestACLStoreChanged(t *testing.T) {
+	server := newACLTestServer(t)
+
+	base, err := convertSimpleACL(server, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := &aclStore{base: base, acl: base}
+
+	if store.changed(nil) {
+		t.Errorf("Unexpected changed for the base rules")
+	}
+
+	values := []string{"{0}to * by users read"}
+	if !store.changed(values) {
+		t.Errorf("Expected changed for the new rules")
+	}
+
+	acl, err := parseACLAttrValues(server, values)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.set(acl, values)
+
+	if store.changed([]string{"{0}to * by users read"}) {
+		t.Errorf("Unexpected changed for the same rules")
+	}
+	if !store.changed([]string{"{0}to * by users search"}) {
+		t.Errorf("Expected changed for the modified rules")
+	}
+	if !store.changed(nil) {
+		t.Errorf("Expected changed for the removed rules")
+	}
+}
@@ -0,0 +1,33 @@
+package ldap_pg
+
+import (
+	"context"
+	"log"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+)
+
+// SetAssertionContext returns the context which has the filter of the assertion control if the request has it.
+// It returns the protocol error if the control is invalid.
+// https://tools.ietf.org/html/rfc4528
+func SetAssertionContext(parents context.Context, m *ldap.Message) (context.Context, error) {
+	con, ok := findControl(m.Controls(), AssertionControlOID)
+	if !ok {
+		return parents, nil
+	}
+
+	filter, err := parseAssertionControl(con)
+	if err != nil {
+		log.Printf("warn: Invalid assertion control. err: %v", err)
+		return nil, NewProtocolError("invalid assertion control")
+	}
+
+	return context.WithValue(parents, assertionContextKey, filter), nil
+}
+
+// assertionContext returns the filter of the assertion control, nil if the request doesn't have it.
+func assertionContext(ctx context.Context) message.Filter {
+	filter, _ := ctx.Value(assertionContextKey).(message.Filter)
+	return filter
+}
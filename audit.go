@@ -0,0 +1,119 @@
+package ldap_pg
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// auditLogger writes the audit records to the file.
+// The file is rotated when the size exceeds the max size.
+type auditLogger struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newAuditLogger(path string, maxSizeMB int, maxBackups int) (*auditLogger, error) {
+	l := &auditLogger{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return xerrors.Errorf("Failed to open the audit log. path: %s, err: %w", l.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return xerrors.Errorf("Failed to stat the audit log. path: %s, err: %w", l.path, err)
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file to <path>.1 and shifts the backups, the oldest one is removed.
+// The new file is opened even if renaming failed.
+func (l *auditLogger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		log.Printf("warn: Failed to close the audit log. path: %s, err: %v", l.path, err)
+	}
+	l.file = nil
+
+	err := l.shiftBackups()
+	if oerr := l.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+func (l *auditLogger) shiftBackups() error {
+	if l.maxBackups <= 0 {
+		if err := os.Remove(l.path); err != nil {
+			return xerrors.Errorf("Failed to remove the audit log. path: %s, err: %w", l.path, err)
+		}
+		return nil
+	}
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", l.path, i)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", l.path, i+1)); err != nil {
+			return xerrors.Errorf("Failed to rotate the audit log. path: %s, err: %w", from, err)
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return xerrors.Errorf("Failed to rotate the audit log. path: %s, err: %w", l.path, err)
+	}
+	return nil
+}
+
+func (l *auditLogger) write(record *ChangeRecord) {
+	if l == nil || record == nil {
+		return
+	}
+
+	ldif := record.LDIF()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Retry opening if the previous rotation failed
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			log.Printf("error: Failed to write the audit log. dn_norm: %s, err: %+v", record.DNNorm, err)
+			return
+		}
+	}
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(ldif)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("error: Failed to rotate the audit log. err: %+v", err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+
+	n, err := l.file.WriteString(ldif)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("error: Failed to write the audit log. dn_norm: %s, err: %v", record.DNNorm, err)
+	}
+}
@@ -0,0 +1,128 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModifyEntryChanges(t *testing.T) {
+	server := newACLTestServer(t)
+	dn, _ := server.NormalizeDN("uid=user1,ou=Users,dc=example,dc=com")
+
+	entry, err := NewModifyEntry(server.schemaMap, dn, map[string][]string{
+		"objectClass":  {"inetOrgPerson"},
+		"cn":           {"user1"},
+		"sn":           {"user1"},
+		"mail":         {"user1@example.com"},
+		"userPassword": {"old"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entry.changes) != 0 {
+		t.Fatalf("The current values must not be recorded: %v", entry.changes)
+	}
+
+	entry.Add("description", []string{"a"})
+	entry.Replace("mail", []string{"user1@example.org"})
+	entry.Delete("sn", []string{})
+	// Failed change isn't recorded
+	entry.Delete("title", []string{"unknown"})
+	// The passwords are masked
+	entry.Delete("userPassword", []string{"old"})
+	entry.Add("userPassword", []string{"new1", "new2"})
+
+	expected := []string{"add:description:a", "replace:mail:user1@example.org", "delete:sn:",
+		"delete:userPassword:*****", "add:userPassword:*****,*****"}
+	if len(entry.changes) != len(expected) {
+		t.Fatalf("Unexpected changes: %v", entry.changes)
+	}
+	for i, c := range entry.changes {
+		if got := c.Op + ":" + c.Attr + ":" + strings.Join(c.Values, ","); got != expected[i] {
+			t.Errorf("Unexpected change on %d: expected %s, got %s", i, expected[i], got)
+		}
+	}
+}
+
+func TestMaskAttrs(t *testing.T) {
+	attrs := map[string][]string{
+		"cn":                    {"user1"},
+		"userPassword":          {"secret"},
+		"authPassword;x-scheme": {"secret"},
+		"pwdHistory":            {"20200101000000Z#1.3.6.1.4.1.1466.115.121.1.40#6#secret"},
+	}
+
+	masked := maskAttrs(attrs)
+
+	if v := masked["cn"]; len(v) != 1 || v[0] != "user1" {
+		t.Errorf("Unexpected cn: %v", v)
+	}
+	for _, k := range []string{"userPassword", "authPassword;x-scheme", "pwdHistory"} {
+		if v := masked[k]; len(v) != 1 || v[0] != maskedValue {
+			t.Errorf("Unexpected masked %s: %v", k, v)
+		}
+	}
+	// The original isn't changed
+	if attrs["userPassword"][0] != "secret" {
+		t.Errorf("The original attributes must not be changed: %v", attrs["userPassword"])
+	}
+}
+
+func TestWithoutMaskedAttrs(t *testing.T) {
+	attrs := map[string][]string{
+		"cn":                    {"user1"},
+		"userPassword":          {maskedValue},
+		"authPassword;x-scheme": {maskedValue},
+	}
+
+	filtered := withoutMaskedAttrs(attrs)
+
+	if len(filtered) != 1 {
+		t.Errorf("Unexpected attributes: %v", filtered)
+	}
+	if v := filtered["cn"]; len(v) != 1 || v[0] != "user1" {
+		t.Errorf("Unexpected cn: %v", v)
+	}
+	if len(attrs) != 3 {
+		t.Errorf("The original attributes must not be changed: %v", attrs)
+	}
+}
+
+func TestAuditLoggerRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ldif")
+
+	l, err := newAuditLogger(path, 0, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Rotate on every record
+	l.maxSize = 1
+
+	for _, dn := range []string{"cn=a,dc=example,dc=com", "cn=b,dc=example,dc=com", "cn=c,dc=example,dc=com", "cn=d,dc=example,dc=com"} {
+		l.write(&ChangeRecord{DN: dn, ChangeType: "delete"})
+	}
+
+	for _, tc := range []struct {
+		Path     string
+		Expected string
+	}{
+		{path, "cn=d"},
+		{path + ".1", "cn=c"},
+		{path + ".2", "cn=b"},
+	} {
+		b, err := os.ReadFile(tc.Path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.Contains(string(b), "dn: "+tc.Expected+",") || strings.Count(string(b), "dn: ") != 1 {
+			t.Errorf("Unexpected content of %s: %s", tc.Path, b)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("The oldest backup must be removed")
+	}
+}
@@ -0,0 +1,229 @@
+package ldap_pg
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChangeRecord is the committed change of the directory.
+// It's written to the audit log and the changelog.
+type ChangeRecord struct {
+	Time time.Time
+	// Actor is the normalized DN of the bound user, empty for anonymous
+	Actor string
+	Addr  string
+	// DN is the original DN of the target entry
+	DN         string
+	DNNorm     string
+	EntryUUID  string
+	ChangeType string
+	// Attrs is the added entry for add, or the deleted entry for delete if the persistent search or the changelog is enabled.
+	// The values of the password attributes are masked
+	Attrs map[string][]string
+	// Changes is for modify, the values of the password attributes are masked
+	Changes []*ModifyChange
+	// NewRDN, DeleteOldRDN and NewSuperior are for modrdn
+	NewRDN       string
+	DeleteOldRDN bool
+	NewSuperior  string
+	// ChangeNumber is assigned by the changelog, 0 if it's disabled
+	ChangeNumber int64
+}
+
+// newChangeRecord returns nil if both of the audit log and the changelog are disabled.
+func newChangeRecord(ctx context.Context, s *Server, changeType string, dn *DN) *ChangeRecord {
+	if !s.changeRecordEnabled() {
+		return nil
+	}
+
+	record := &ChangeRecord{
+		Time:       time.Now().UTC(),
+		Addr:       clientIPContext(ctx),
+		DN:         dn.DNOrigStr(),
+		DNNorm:     dn.DNNormStr(),
+		ChangeType: changeType,
+	}
+	if session, err := AuthSessionContext(ctx); err == nil && session.DN != nil {
+		record.Actor = session.DN.DNNormStr()
+	}
+	return record
+}
+
+// LDIF returns the change record with the comment lines of the timestamp, the actor and the client address.
+func (r *ChangeRecord) LDIF() string {
+	var b strings.Builder
+
+	actor := r.Actor
+	if actor == "" {
+		actor = "anonymous"
+	}
+	fmt.Fprintf(&b, "# %s %s\n", r.ChangeType, r.Time.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "# actor: %s\n", actor)
+	if r.Addr != "" {
+		fmt.Fprintf(&b, "# addr: %s\n", r.Addr)
+	}
+
+	writeLDIFLine(&b, "dn", r.DN)
+	writeLDIFLine(&b, "changetype", r.ChangeType)
+
+	r.writeChanges(&b)
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// ChangesLDIF returns the attributes of the added entry or the modifications in LDIF.
+// It's empty for modrdn and delete, it's used for changes attribute of the changelog.
+func (r *ChangeRecord) ChangesLDIF() string {
+	var b strings.Builder
+	switch r.ChangeType {
+	case "add", "modify":
+		r.writeChanges(&b)
+	}
+	return b.String()
+}
+
+func (r *ChangeRecord) writeChanges(b *strings.Builder) {
+	switch r.ChangeType {
+	case "add":
+		names := make([]string, 0, len(r.Attrs))
+		for k := range r.Attrs {
+			names = append(names, k)
+		}
+		// objectClass first, then sorted by the name for the stable output
+		sort.Slice(names, func(i, j int) bool {
+			if strings.EqualFold(names[i], "objectClass") != strings.EqualFold(names[j], "objectClass") {
+				return strings.EqualFold(names[i], "objectClass")
+			}
+			return names[i] < names[j]
+		})
+		for _, k := range names {
+			for _, v := range r.Attrs[k] {
+				writeLDIFLine(b, k, v)
+			}
+		}
+	case "modify":
+		for _, c := range r.Changes {
+			writeLDIFLine(b, c.Op, c.Attr)
+			for _, v := range c.Values {
+				writeLDIFLine(b, c.Attr, v)
+			}
+			b.WriteString("-\n")
+		}
+	case "modrdn":
+		writeLDIFLine(b, "newrdn", r.NewRDN)
+		if r.DeleteOldRDN {
+			b.WriteString("deleteoldrdn: 1\n")
+		} else {
+			b.WriteString("deleteoldrdn: 0\n")
+		}
+		if r.NewSuperior != "" {
+			writeLDIFLine(b, "newsuperior", r.NewSuperior)
+		}
+	}
+}
+
+// writeLDIFLine writes the attribute value, it's base64 encoded if it isn't SAFE-STRING of RFC 2849.
+func writeLDIFLine(b *strings.Builder, name, value string) {
+	if isLDIFSafeString(value) {
+		fmt.Fprintf(b, "%s: %s\n", name, value)
+	} else {
+		fmt.Fprintf(b, "%s:: %s\n", name, base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+}
+
+func isLDIFSafeString(value string) bool {
+	if value == "" {
+		return true
+	}
+	switch value[0] {
+	case ' ', ':', '<':
+		return false
+	}
+	// Trailing space is also encoded to keep it
+	if value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == 0 || c == '\n' || c == '\r' || c > 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// maskedValue replaces the values of the password attributes in the change records.
+const maskedValue = "*****"
+
+// maskedAttributes are the attributes whose values aren't written to the change records, in lower case.
+var maskedAttributes = NewStringSet(
+	"userpassword",
+	"authpassword",
+	"pwdhistory",
+	"sambalmpassword",
+	"sambantpassword",
+	"sambapasswordhistory",
+	"olcrootpw",
+)
+
+func isMaskedAttribute(attr string) bool {
+	// Strip the options (e.g. ;binary)
+	if i := strings.Index(attr, ";"); i != -1 {
+		attr = attr[:i]
+	}
+	return maskedAttributes.Contains(strings.ToLower(attr))
+}
+
+// maskValues returns the copy of the values, they are masked if the attribute is the password attribute.
+func maskValues(attr string, values []string) []string {
+	masked := append([]string{}, values...)
+	if isMaskedAttribute(attr) {
+		for i := range masked {
+			masked[i] = maskedValue
+		}
+	}
+	return masked
+}
+
+// maskAttrs returns the copy of the attributes whose password values are masked.
+func maskAttrs(attrs map[string][]string) map[string][]string {
+	if attrs == nil {
+		return nil
+	}
+	masked := make(map[string][]string, len(attrs))
+	for k, v := range attrs {
+		masked[k] = maskValues(k, v)
+	}
+	return masked
+}
+
+// withoutMaskedAttrs returns the copy of the attributes without the password attributes.
+func withoutMaskedAttrs(attrs map[string][]string) map[string][]string {
+	if attrs == nil {
+		return nil
+	}
+	filtered := make(map[string][]string, len(attrs))
+	for k, v := range attrs {
+		if !isMaskedAttribute(k) {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+func (s *Server) changeRecordEnabled() bool {
+	return s.auditLog != nil || s.config.AuditDB || s.config.Changelog || s.config.PersistentSearch
+}
+
+// entryUUIDOf returns entryUUID of the attributes, empty if it doesn't exist.
+func entryUUIDOf(attrs map[string][]string) string {
+	if v := attrs["entryUUID"]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
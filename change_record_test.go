@@ -0,0 +1,99 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChangeRecordLDIF(t *testing.T) {
+	ts := time.Date(2021, 10, 1, 1, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		Record   *ChangeRecord
+		Expected string
+	}{
+		{
+			&ChangeRecord{
+				Time: ts, Actor: "cn=manager,dc=example,dc=com", Addr: "127.0.0.1",
+				DN: "uid=user1,ou=Users,dc=example,dc=com", ChangeType: "add",
+				Attrs: map[string][]string{
+					"uid":         {"user1"},
+					"objectClass": {"inetOrgPerson"},
+					"cn":          {"User 1"},
+					"description": {" leading space", "ユーザー"},
+				},
+			},
+			`# add 2021-10-01T01:00:00Z
+# actor: cn=manager,dc=example,dc=com
+# addr: 127.0.0.1
+dn: uid=user1,ou=Users,dc=example,dc=com
+changetype: add
+objectClass: inetOrgPerson
+cn: User 1
+description:: IGxlYWRpbmcgc3BhY2U=
+description:: 44Om44O844K244O8
+uid: user1
+
+`,
+		},
+		{
+			&ChangeRecord{
+				Time: ts, Addr: "127.0.0.1",
+				DN: "uid=user1,ou=Users,dc=example,dc=com", ChangeType: "modify",
+				Changes: []*ModifyChange{
+					{Op: "replace", Attr: "mail", Values: []string{"user1@example.com"}},
+					{Op: "delete", Attr: "description"},
+				},
+			},
+			`# modify 2021-10-01T01:00:00Z
+# actor: anonymous
+# addr: 127.0.0.1
+dn: uid=user1,ou=Users,dc=example,dc=com
+changetype: modify
+replace: mail
+mail: user1@example.com
+-
+delete: description
+-
+
+`,
+		},
+		{
+			&ChangeRecord{
+				Time: ts, Actor: "cn=manager,dc=example,dc=com",
+				DN: "uid=user1,ou=Users,dc=example,dc=com", ChangeType: "modrdn",
+				NewRDN: "uid=user2", DeleteOldRDN: true, NewSuperior: "ou=Others,dc=example,dc=com",
+			},
+			`# modrdn 2021-10-01T01:00:00Z
+# actor: cn=manager,dc=example,dc=com
+dn: uid=user1,ou=Users,dc=example,dc=com
+changetype: modrdn
+newrdn: uid=user2
+deleteoldrdn: 1
+newsuperior: ou=Others,dc=example,dc=com
+
+`,
+		},
+		{
+			&ChangeRecord{
+				Time: ts, Actor: "cn=manager,dc=example,dc=com", Addr: "::1",
+				DN: "uid=user1,ou=Users,dc=example,dc=com", ChangeType: "delete",
+			},
+			`# delete 2021-10-01T01:00:00Z
+# actor: cn=manager,dc=example,dc=com
+# addr: ::1
+dn: uid=user1,ou=Users,dc=example,dc=com
+changetype: delete
+
+`,
+		},
+	}
+
+	for i, tc := range testcases {
+		if ldif := tc.Record.LDIF(); ldif != tc.Expected {
+			t.Errorf("Unexpected LDIF on %d:\nexpected:\n%s\ngot:\n%s", i, tc.Expected, ldif)
+		}
+	}
+}
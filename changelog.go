@@ -0,0 +1,255 @@
+package ldap_pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+)
+
+// ChangelogDN is the base DN of the changelog entries.
+// https://tools.ietf.org/html/draft-good-ldap-changelog-04
+const ChangelogDN = "cn=changelog"
+
+// changelogPurgeInterval is the interval to purge the changelog entries older than the max age.
+const changelogPurgeInterval = 10 * time.Minute
+
+var errSizeLimitExceeded = errors.New("size limit exceeded")
+
+// initChangelog starts purging the changelog if the retention is configured.
+func (s *Server) initChangelog() error {
+	if !s.config.Changelog {
+		return nil
+	}
+
+	dn, err := s.NormalizeDN(ChangelogDN)
+	if err != nil {
+		return err
+	}
+	s.changelogDN = dn
+
+	if s.config.ChangelogMaxAge > 0 {
+		go s.purgeChangelogLoop()
+	}
+	return nil
+}
+
+func (s *Server) purgeChangelogLoop() {
+	ticker := time.NewTicker(changelogPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		s.purgeChangelog()
+		<-ticker.C
+	}
+}
+
+func (s *Server) purgeChangelog() {
+	before := time.Now().Add(-s.config.ChangelogMaxAge)
+	num, err := s.Repo().PurgeChangelog(context.Background(), before)
+	if err != nil {
+		log.Printf("error: Failed to purge the changelog. err: %+v", err)
+		return
+	}
+	if num > 0 {
+		log.Printf("info: Purged the changelog. before: %s, entries: %d", before.Format(time.RFC3339), num)
+	}
+}
+
+// isChangelogDN checks whether the DN is cn=changelog or the changelog entry.
+func (s *Server) isChangelogDN(dn *DN) bool {
+	if s.changelogDN == nil || dn == nil {
+		return false
+	}
+	return dn.Equal(s.changelogDN) || dn.IsSubOf(s.changelogDN)
+}
+
+// handleSearchChangelog returns cn=changelog and the changelog entries.
+// The range of the change number is narrowed by the filter, then the filter is evaluated for each entry.
+func handleSearchChangelog(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, baseDN *DN) {
+	scope := int(r.Scope())
+	sizeLimit := r.SizeLimit().Int()
+	sent := 0
+
+	send := func(dn string, entry *SearchEntry) error {
+		if !matchFilter(s.schemaMap, r.Filter(), entry) {
+			return nil
+		}
+		if sizeLimit > 0 && sent >= sizeLimit {
+			return errSizeLimitExceeded
+		}
+		responseEntryWithDN(ctx, s, w, m, r, dn, entry)
+		sent++
+		return nil
+	}
+
+	option := &ChangelogSearchOption{}
+
+	if baseDN.Equal(s.changelogDN) {
+		if scope == message.SearchRequestScopeBaseObject || scope == message.SearchRequestHomeSubtree {
+			send(ChangelogDN, changelogContainerEntry(s))
+		}
+		if scope == message.SearchRequestScopeBaseObject {
+			w.Write(ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess))
+			return
+		}
+		changeNumberRange(r.Filter(), option)
+	} else {
+		changeNumber, ok := parseChangelogEntryDN(s, baseDN)
+		if !ok {
+			responseSearchError(w, NewNoSuchObject())
+			return
+		}
+		option.FirstChangeNumber = changeNumber
+		option.LastChangeNumber = changeNumber
+	}
+
+	found := false
+	err := s.Repo().SearchChangelog(ctx, option, func(e *ChangelogEntry) error {
+		found = true
+		// The changelog entry doesn't have the children
+		if !baseDN.Equal(s.changelogDN) && (scope == message.SearchRequestSingleLevel || scope == message.SearchRequestSubordinateSubtree) {
+			return nil
+		}
+		return send(changelogEntryDN(e.ChangeNumber), changelogSearchEntry(s, e))
+	})
+	if err != nil {
+		if errors.Is(err, errSizeLimitExceeded) {
+			w.Write(ldap.NewSearchResultDoneResponse(ldap.LDAPResultSizeLimitExceeded))
+			return
+		}
+		responseSearchError(w, err)
+		return
+	}
+
+	if !found && !baseDN.Equal(s.changelogDN) {
+		responseSearchError(w, NewNoSuchObject())
+		return
+	}
+
+	w.Write(ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess))
+}
+
+// parseChangelogEntryDN returns the change number of the DN like changeNumber=1,cn=changelog.
+func parseChangelogEntryDN(s *Server, dn *DN) (int64, bool) {
+	if len(dn.RDNs) != len(s.changelogDN.RDNs)+1 || !dn.ParentDN().Equal(s.changelogDN) {
+		return 0, false
+	}
+	rdn := dn.RDNs[0]
+	if len(rdn.Attributes) != 1 || !strings.EqualFold(rdn.Attributes[0].TypeOrig, "changeNumber") {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(rdn.Attributes[0].ValueOrig, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// changeNumberRange narrows the range of the change number by the filter, e.g. (&(changeNumber>=10)(changeNumber<=20)).
+// Only the top level filter and the children of the top level AND filter are used.
+func changeNumberRange(filter message.Filter, option *ChangelogSearchOption) {
+	var filters []message.Filter
+	if and, ok := filter.(message.FilterAnd); ok {
+		filters = and
+	} else {
+		filters = []message.Filter{filter}
+	}
+
+	parse := func(attr message.AttributeDescription, value message.AssertionValue) (int64, bool) {
+		if !strings.EqualFold(string(attr), "changeNumber") {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(string(value), 10, 64)
+		return n, err == nil
+	}
+	narrowFirst := func(n int64) {
+		if n > option.FirstChangeNumber {
+			option.FirstChangeNumber = n
+		}
+	}
+	narrowLast := func(n int64) {
+		if option.LastChangeNumber <= 0 || n < option.LastChangeNumber {
+			option.LastChangeNumber = n
+		}
+	}
+
+	for _, f := range filters {
+		switch f := f.(type) {
+		case message.FilterGreaterOrEqual:
+			if n, ok := parse(f.AttributeDesc(), f.AssertionValue()); ok {
+				narrowFirst(n)
+			}
+		case message.FilterLessOrEqual:
+			if n, ok := parse(f.AttributeDesc(), f.AssertionValue()); ok {
+				narrowLast(n)
+			}
+		case message.FilterEqualityMatch:
+			if n, ok := parse(f.AttributeDesc(), f.AssertionValue()); ok {
+				narrowFirst(n)
+				narrowLast(n)
+			}
+		}
+	}
+}
+
+func changelogEntryDN(changeNumber int64) string {
+	return fmt.Sprintf("changeNumber=%d,%s", changeNumber, ChangelogDN)
+}
+
+func changelogContainerEntry(s *Server) *SearchEntry {
+	return NewSearchEntry(s.schemaMap, ChangelogDN, map[string][]string{
+		"objectClass": {"top"},
+		"cn":          {"changelog"},
+	})
+}
+
+func changelogSearchEntry(s *Server, e *ChangelogEntry) *SearchEntry {
+	attrs := map[string][]string{
+		"objectClass":  {"top", "changeLogEntry"},
+		"changeNumber": {strconv.FormatInt(e.ChangeNumber, 10)},
+		"targetDN":     {e.TargetDN},
+		"changeType":   {e.ChangeType},
+		"changeTime":   {e.ChangeTime.In(time.UTC).Format(TIMESTAMP_FORMAT)},
+	}
+	if e.Changes.Valid {
+		attrs["changes"] = []string{e.Changes.String}
+	}
+	if e.NewRDN.Valid {
+		attrs["newRDN"] = []string{e.NewRDN.String}
+	}
+	if e.DeleteOldRDN.Valid {
+		if e.DeleteOldRDN.Bool {
+			attrs["deleteOldRDN"] = []string{"TRUE"}
+		} else {
+			attrs["deleteOldRDN"] = []string{"FALSE"}
+		}
+	}
+	if e.NewSuperior.Valid {
+		attrs["newSuperior"] = []string{e.NewSuperior.String}
+	}
+	return NewSearchEntry(s.schemaMap, changelogEntryDN(e.ChangeNumber), attrs)
+}
+
+// changelogRootDSEAttrs returns the attributes of the changelog for the root DSE.
+func changelogRootDSEAttrs(ctx context.Context, s *Server) map[string][]string {
+	if s.changelogDN == nil {
+		return nil
+	}
+	first, last, err := s.Repo().ChangeNumberRange(ctx)
+	if err != nil {
+		log.Printf("error: Failed to fetch the range of the change number. err: %+v", err)
+		return nil
+	}
+	return map[string][]string{
+		"changelog":         {ChangelogDN},
+		"firstChangeNumber": {strconv.FormatInt(first, 10)},
+		"lastChangeNumber":  {strconv.FormatInt(last, 10)},
+	}
+}
@@ -0,0 +1,119 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func newChangelogTestServer(t *testing.T) *Server {
+	server := newACLTestServer(t)
+	server.config.Changelog = true
+	if err := server.initChangelog(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return server
+}
+
+func TestChangeNumberRange(t *testing.T) {
+	testcases := []struct {
+		Filter      string
+		First, Last int64
+	}{
+		{"(objectClass=*)", 0, 0},
+		{"(changeNumber=5)", 5, 5},
+		{"(changeNumber>=10)", 10, 0},
+		{"(&(changeNumber>=10)(changeNumber<=20)(targetDN=*))", 10, 20},
+		{"(&(changeNumber>=10)(changeNumber>=15)(changeNumber<=30)(changeNumber<=20))", 15, 20},
+		// OR isn't used to narrow the range
+		{"(|(changeNumber=1)(changeNumber=2))", 0, 0},
+		{"(changeNumber>=abc)", 0, 0},
+	}
+
+	for i, tc := range testcases {
+		f, err := compileFilter(tc.Filter)
+		if err != nil {
+			t.Fatalf("Unexpected error on %d: %v", i, err)
+		}
+		option := &ChangelogSearchOption{}
+		changeNumberRange(f, option)
+		if option.FirstChangeNumber != tc.First || option.LastChangeNumber != tc.Last {
+			t.Errorf("Unexpected range on %d: expected %d-%d, got %d-%d", i, tc.First, tc.Last, option.FirstChangeNumber, option.LastChangeNumber)
+		}
+	}
+}
+
+func TestParseChangelogEntryDN(t *testing.T) {
+	server := newChangelogTestServer(t)
+
+	testcases := []struct {
+		DN       string
+		Expected int64
+		OK       bool
+	}{
+		{"changeNumber=10,cn=changelog", 10, true},
+		{"CHANGENUMBER=3,CN=Changelog", 3, true},
+		{"cn=changelog", 0, false},
+		{"changeNumber=0,cn=changelog", 0, false},
+		{"cn=10,cn=changelog", 0, false},
+		{"cn=a,changeNumber=10,cn=changelog", 0, false},
+	}
+
+	for i, tc := range testcases {
+		dn, err := server.NormalizeDN(tc.DN)
+		if err != nil {
+			t.Fatalf("Unexpected error on %d: %v", i, err)
+		}
+		if !server.isChangelogDN(dn) {
+			t.Errorf("Expected the changelog DN on %d", i)
+		}
+		n, ok := parseChangelogEntryDN(server, dn)
+		if n != tc.Expected || ok != tc.OK {
+			t.Errorf("Unexpected result on %d: expected %d %v, got %d %v", i, tc.Expected, tc.OK, n, ok)
+		}
+	}
+
+	dn, _ := server.NormalizeDN("uid=user1,ou=Users,dc=example,dc=com")
+	if server.isChangelogDN(dn) {
+		t.Errorf("Unexpected changelog DN: %s", dn.DNOrigStr())
+	}
+}
+
+func TestChangelogSearchEntry(t *testing.T) {
+	server := newChangelogTestServer(t)
+
+	e := changelogSearchEntry(server, &ChangelogEntry{
+		ChangeNumber: 7,
+		ChangeTime:   time.Date(2021, 10, 1, 10, 0, 0, 0, time.FixedZone("JST", 9*60*60)),
+		TargetDN:     "uid=user1,ou=Users,dc=example,dc=com",
+		ChangeType:   "modrdn",
+		NewRDN:       sql.NullString{String: "uid=user2", Valid: true},
+		DeleteOldRDN: sql.NullBool{Bool: true, Valid: true},
+	})
+
+	if e.DNOrig() != "changeNumber=7,cn=changelog" {
+		t.Errorf("Unexpected DN: %s", e.DNOrig())
+	}
+	for attr, expected := range map[string]string{
+		"changeNumber": "7",
+		"changeTime":   "20211001010000Z",
+		"newRDN":       "uid=user2",
+		"deleteOldRDN": "TRUE",
+	} {
+		if _, v, ok := e.GetAttrOrig(attr); !ok || len(v) != 1 || v[0] != expected {
+			t.Errorf("Unexpected %s: expected %s, got %v", attr, expected, v)
+		}
+	}
+	for _, attr := range []string{"changes", "newSuperior"} {
+		if _, v, ok := e.GetAttrOrig(attr); ok && len(v) > 0 {
+			t.Errorf("Unexpected %s: %v", attr, v)
+		}
+	}
+
+	f, _ := compileFilter("(&(objectClass=changeLogEntry)(changeNumber>=5))")
+	if !matchFilter(server.schemaMap, f, e) {
+		t.Errorf("The filter must match the changelog entry")
+	}
+}
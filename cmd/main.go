@@ -89,6 +89,56 @@ var (
 		"",
 		"Bind address of pprof server (Don't start the server with default)",
 	)
+	metricsServer = fs.String(
+		"metrics",
+		"",
+		"Bind address of Prometheus metrics server (Don't start the server with default). /metrics is also served by pprof server",
+	)
+	accessLogFile = fs.String(
+		"access-log",
+		"",
+		"Path of JSON access log, one line per operation. \"-\" means stdout (Don't write the access log with default)",
+	)
+	auditLogFile = fs.String(
+		"audit-log",
+		"",
+		"Path of audit log which has LDIF change records of the successful writes (Don't write the audit log with default)",
+	)
+	auditLogMaxSize = fs.Int(
+		"audit-log-max-size",
+		100,
+		"Max size (MB) of audit log file before rotating. 0 disables the rotation",
+	)
+	auditLogMaxBackups = fs.Int(
+		"audit-log-max-backups",
+		5,
+		"Number of rotated audit log files to keep",
+	)
+	auditDB = fs.Bool(
+		"audit-db",
+		false,
+		"Write audit records to ldap_audit table in the same transaction as the change (default false)",
+	)
+	changelog = fs.Bool(
+		"changelog",
+		false,
+		"Record the changes to ldap_changelog table and expose them as cn=changelog entries (default false)",
+	)
+	changelogMaxAge = fs.Duration(
+		"changelog-max-age",
+		0,
+		"Retention of the changelog entries, e.g. 168h. 0 means the changelog isn't purged",
+	)
+	persistentSearch = fs.Bool(
+		"persistent-search",
+		false,
+		"Enable the persistent search control. The changes are shared with the other instances by PostgreSQL LISTEN/NOTIFY (default false)",
+	)
+	vlvMaxWindowSize = fs.Int(
+		"vlv-max-window-size",
+		500,
+		"Max number of the entries in the window of the virtual list view, the larger window is rejected with adminLimitExceeded",
+	)
 	gomaxprocs = fs.Int(
 		"gomaxprocs",
 		0,
@@ -144,6 +194,81 @@ var (
 		"",
 		"DN of the default password policy entry (e.g. cn=standard-policy,ou=Policies,dc=example,dc=com)",
 	)
+	ldapsBindAddress = fs.String(
+		"ldaps-b",
+		"",
+		"Bind address of LDAPS (Don't start LDAPS with default) (e.g. 127.0.0.1:8636)",
+	)
+	tlsCert = fs.String(
+		"tls-cert",
+		"",
+		"Path of the certificate file (PEM) for LDAPS and StartTLS. It's reloaded by SIGHUP",
+	)
+	tlsKey = fs.String(
+		"tls-key",
+		"",
+		"Path of the private key file (PEM) for LDAPS and StartTLS. It's reloaded by SIGHUP",
+	)
+	tlsCACert = fs.String(
+		"tls-ca-cert",
+		"",
+		"Path of the CA bundle file (PEM) to verify client certificates",
+	)
+	tlsMinVersion = fs.String(
+		"tls-min-version",
+		"1.2",
+		"Minimum TLS version, one of: 1.0, 1.1, 1.2, 1.3",
+	)
+	tlsCipherPolicy = fs.String(
+		"tls-cipher-policy",
+		"intermediate",
+		"TLS cipher policy, one of: intermediate, modern (TLS 1.3 only) or comma separated cipher suite names",
+	)
+	tlsRequired = fs.Bool(
+		"tls-required",
+		false,
+		"Require TLS for simple bind and write operations, otherwise confidentialityRequired is returned (default false)",
+	)
+	passwordHash = fs.String(
+		"password-hash",
+		"",
+		"Hash scheme of the clear-text password on add/modify, one of: SSHA512, ARGON2, BCRYPT, PBKDF2, SCRAM-SHA-256. The clear-text password is stored as it is with default (SSHA512 is used for the password modify extended operation)",
+	)
+	passwordHashArgon2Memory = fs.Uint(
+		"password-hash-argon2-memory",
+		64*1024,
+		"Memory (KiB) parameter of ARGON2 password hash",
+	)
+	passwordHashArgon2Iterations = fs.Uint(
+		"password-hash-argon2-iterations",
+		3,
+		"Iterations parameter of ARGON2 password hash",
+	)
+	rejectPrehashedPassword = fs.Bool(
+		"reject-prehashed-password",
+		false,
+		"Reject the pre-hashed userPassword from non-root users on add/modify (default false)",
+	)
+	aclFile = fs.String(
+		"acl-file",
+		"",
+		`Path of the access rules file like OpenLDAP's access directive (e.g. access to dn.subtree="ou=Users,dc=example,dc=com" attrs=userPassword by self write by anonymous auth by * none). -acl is ignored if it's configured`,
+	)
+	aclDN = fs.String(
+		"acl-dn",
+		"",
+		`DN of the entry which has the access rules in olcAccess attribute (e.g. olcDatabase={1}pg,ou=config,dc=example,dc=com). The rules are reloaded when the entry is updated, -acl-file or -acl is used if the entry doesn't have the rules`,
+	)
+	saslExternalMapping = fs.String(
+		"sasl-external-mapping",
+		"",
+		"SASL EXTERNAL: Rule to map the client certificate to the entry, the format is <Source(subject, cn, email, dns or uri)>:<Base DN>:<Filter> (e.g. email:ou=Users,dc=example,dc=com:(mail=%s)). The certificate subject is used as the DN with default",
+	)
+	saslIdentityMapping = fs.String(
+		"sasl-identity-mapping",
+		"",
+		"SASL PLAIN/SCRAM-SHA-256: Rule to map the user name to the entry, the format is <Base DN>:<Filter> (e.g. ou=Users,dc=example,dc=com:(mail=%s)). (uid=%s) under the suffix is used with default",
+	)
 )
 
 func main() {
@@ -194,30 +319,70 @@ func main() {
 	defer stop()
 
 	server := ldap_pg.NewServer(&ldap_pg.ServerConfig{
-		DBHostName:        *dbHostName,
-		DBPort:            *dbPort,
-		DBName:            *dbName,
-		DBSchema:          *dbSchema,
-		DBUser:            *dbUser,
-		DBPassword:        *dbPassword,
-		DBMaxOpenConns:    *dbMaxOpenConns,
-		DBMaxIdleConns:    *dbMaxIdleConns,
-		Suffix:            *suffix,
-		RootDN:            *rootdn,
-		RootPW:            rootPW,
-		BindAddress:       *bindAddress,
-		PassThroughConfig: passThroughConfig,
-		LogLevel:          *logLevel,
-		PProfServer:       *pprofServer,
-		GoMaxProcs:        *gomaxprocs,
-		MigrationEnabled:  *migrationEnabled,
-		QueryTranslator:   "default",
-		SimpleACL:         acl,
-		DefaultPPolicyDN:  *defaultPPolicyDN,
+		DBHostName:                   *dbHostName,
+		DBPort:                       *dbPort,
+		DBName:                       *dbName,
+		DBSchema:                     *dbSchema,
+		DBUser:                       *dbUser,
+		DBPassword:                   *dbPassword,
+		DBMaxOpenConns:               *dbMaxOpenConns,
+		DBMaxIdleConns:               *dbMaxIdleConns,
+		Suffix:                       *suffix,
+		RootDN:                       *rootdn,
+		RootPW:                       rootPW,
+		BindAddress:                  *bindAddress,
+		PassThroughConfig:            passThroughConfig,
+		LogLevel:                     *logLevel,
+		PProfServer:                  *pprofServer,
+		GoMaxProcs:                   *gomaxprocs,
+		MigrationEnabled:             *migrationEnabled,
+		QueryTranslator:              "default",
+		SimpleACL:                    acl,
+		ACLFile:                      *aclFile,
+		ACLDN:                        *aclDN,
+		DefaultPPolicyDN:             *defaultPPolicyDN,
+		LDAPSBindAddress:             *ldapsBindAddress,
+		TLSCertFile:                  *tlsCert,
+		TLSKeyFile:                   *tlsKey,
+		TLSCACertFile:                *tlsCACert,
+		TLSMinVersion:                *tlsMinVersion,
+		TLSCipherPolicy:              *tlsCipherPolicy,
+		TLSRequired:                  *tlsRequired,
+		MetricsServer:                *metricsServer,
+		AccessLogFile:                *accessLogFile,
+		AuditLogFile:                 *auditLogFile,
+		AuditLogMaxSize:              *auditLogMaxSize,
+		AuditLogMaxBackups:           *auditLogMaxBackups,
+		AuditDB:                      *auditDB,
+		Changelog:                    *changelog,
+		ChangelogMaxAge:              *changelogMaxAge,
+		PersistentSearch:             *persistentSearch,
+		VLVMaxWindowSize:             *vlvMaxWindowSize,
+		SASLExternalMapping:          *saslExternalMapping,
+		SASLIdentityMapping:          *saslIdentityMapping,
+		PasswordHash:                 *passwordHash,
+		PasswordHashArgon2Memory:     uint32(*passwordHashArgon2Memory),
+		PasswordHashArgon2Iterations: uint32(*passwordHashArgon2Iterations),
+		RejectPrehashedPassword:      *rejectPrehashedPassword,
 	})
 
 	go server.Start(*bindAddress)
 
+	// When SIGHUP signal occurs
+	// Then reload the certificate and reopen the access log
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := server.ReloadTLSCertificate(); err != nil {
+				log.Printf("error: Failed to reload the certificate. err: %+v", err)
+			}
+			if err := server.ReopenAccessLog(); err != nil {
+				log.Printf("error: Failed to reopen the access log. err: %+v", err)
+			}
+		}
+	}()
+
 	<-ctx.Done()
 	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
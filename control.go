@@ -0,0 +1,734 @@
+package ldap_pg
+
+import (
+	"github.com/google/uuid"
+	"github.com/openstandia/goldap/message"
+	"golang.org/x/xerrors"
+	ber "gopkg.in/asn1-ber.v1"
+)
+
+const (
+	SortRequestControlOID  = "1.2.840.113556.1.4.473"
+	SortResponseControlOID = "1.2.840.113556.1.4.474"
+	VLVRequestControlOID   = "2.16.840.1.113730.3.4.9"
+	VLVResponseControlOID  = "2.16.840.1.113730.3.4.10"
+	PPolicyControlOID      = "1.3.6.1.4.1.42.2.27.8.5.1"
+	SyncRequestControlOID  = "1.3.6.1.4.1.4203.1.9.1.1"
+	SyncStateControlOID    = "1.3.6.1.4.1.4203.1.9.1.2"
+	SyncDoneControlOID     = "1.3.6.1.4.1.4203.1.9.1.3"
+	SyncInfoOID            = "1.3.6.1.4.1.4203.1.9.1.4"
+
+	PersistentSearchControlOID        = "2.16.840.1.113730.3.4.3"
+	EntryChangeNotificationControlOID = "2.16.840.1.113730.3.4.7"
+
+	ProxiedAuthzControlOID = "2.16.840.1.113730.3.4.18"
+	AssertionControlOID    = "1.3.6.1.1.12"
+	PreReadControlOID      = "1.3.6.1.1.13.1"
+	PostReadControlOID     = "1.3.6.1.1.13.2"
+	TreeDeleteControlOID   = "1.2.840.113556.1.4.805"
+)
+
+// The modes of the sync request control.
+const (
+	SyncModeRefreshOnly       = 1
+	SyncModeRefreshAndPersist = 3
+)
+
+// The states of the sync state control.
+const (
+	SyncStatePresent = 0
+	SyncStateAdd     = 1
+	SyncStateModify  = 2
+	SyncStateDelete  = 3
+)
+
+// The change types of the persistent search control and the entry change notification control.
+const (
+	PSearchChangeAdd    = 1
+	PSearchChangeDelete = 2
+	PSearchChangeModify = 4
+	PSearchChangeModDN  = 8
+	PSearchChangeAll    = PSearchChangeAdd | PSearchChangeDelete | PSearchChangeModify | PSearchChangeModDN
+)
+
+// LDAPResultSyncRefreshRequired is e-syncRefreshRequired result code.
+// The client needs to restart the synchronization without the cookie.
+const LDAPResultSyncRefreshRequired = 4096
+
+// LDAPResultAuthorizationDenied is the result code when the proxied authorization is denied.
+// https://tools.ietf.org/html/rfc4370
+const LDAPResultAuthorizationDenied = 123
+
+// LDAPResultAssertionFailed is the result code when the filter of the assertion control doesn't match the target entry.
+// https://tools.ietf.org/html/rfc4528
+const LDAPResultAssertionFailed = 122
+
+// The error codes of the password policy response control.
+const (
+	PPolicyErrorPasswordExpired             = 0
+	PPolicyErrorAccountLocked               = 1
+	PPolicyErrorChangeAfterReset            = 2
+	PPolicyErrorPasswordModNotAllowed       = 3
+	PPolicyErrorMustSupplyOldPassword       = 4
+	PPolicyErrorInsufficientPasswordQuality = 5
+	PPolicyErrorPasswordTooShort            = 6
+	PPolicyErrorPasswordTooYoung            = 7
+	PPolicyErrorPasswordInHistory           = 8
+)
+
+// newControl returns the control which has the specified type, criticality and value.
+// goldap doesn't provide the constructor of the control except for simple paged results control.
+// So we build a dummy LDAP message which contains the control, then read it back using goldap.
+func newControl(controlType string, criticality bool, value *ber.Packet) (message.Control, error) {
+	c := ber.NewSequence("Control")
+	c.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, controlType, "Control Type"))
+	if criticality {
+		c.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, criticality, "Criticality"))
+	}
+	if value != nil {
+		c.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value.Bytes()), "Control Value"))
+	}
+
+	controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+	controls.AppendChild(c)
+
+	msg, err := readDummyLDAPMessage(nil, controls)
+	if err != nil {
+		return message.Control{}, xerrors.Errorf("Failed to build the control. type: %s, err: %w", controlType, err)
+	}
+
+	return (*msg.Controls())[0], nil
+}
+
+// readDummyLDAPMessage builds LDAP message which has the specified protocolOp and controls, then read it using goldap.
+// If the protocolOp is nil, abandon request is used as the dummy.
+func readDummyLDAPMessage(protocolOp, controls *ber.Packet) (*message.LDAPMessage, error) {
+	packet := ber.NewSequence("LDAP Message")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 1, "MessageID"))
+	if protocolOp == nil {
+		protocolOp = ber.NewInteger(ber.ClassApplication, ber.TypePrimitive, 16, 0, "Abandon Request")
+	}
+	packet.AppendChild(protocolOp)
+	if controls != nil {
+		packet.AppendChild(controls)
+	}
+
+	msg, err := message.ReadLDAPMessage(message.NewBytes(0, packet.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// readDummyFilter reads the BER encoded filter using goldap.
+// goldap doesn't provide the reader of the filter, so we read it back as a dummy search request.
+func readDummyFilter(filter *ber.Packet) (message.Filter, error) {
+	req := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 3, nil, "Search Request")
+	req.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Base DN"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 0, "Scope"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 0, "Deref Aliases"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, "Size Limit"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, "Time Limit"))
+	req.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, "Types Only"))
+	req.AppendChild(filter)
+	req.AppendChild(ber.NewSequence("Attributes"))
+
+	msg, err := readDummyLDAPMessage(req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sr, ok := msg.ProtocolOp().(message.SearchRequest)
+	if !ok {
+		return nil, xerrors.Errorf("Unexpected protocolOp: %s", msg.ProtocolOpName())
+	}
+	return sr.Filter(), nil
+}
+
+// newExtendedResponse returns the extended response which has the response name and value.
+// The value is omitted if it's nil.
+// goldap doesn't provide the setter of the response value, so we read it back from a dummy LDAP message like the control.
+func newExtendedResponse(resultCode int, diagnosticMessage, responseName string, value []byte) (message.ExtendedResponse, error) {
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 24, nil, "Extended Response")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, "resultCode"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, diagnosticMessage, "diagnosticMessage"))
+	if responseName != "" {
+		res.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 10, responseName, "responseName"))
+	}
+	if value != nil {
+		res.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 11, string(value), "responseValue"))
+	}
+
+	msg, err := readDummyLDAPMessage(res, nil)
+	if err != nil {
+		return message.ExtendedResponse{}, xerrors.Errorf("Failed to build the extended response. name: %s, err: %w", responseName, err)
+	}
+
+	return msg.ProtocolOp().(message.ExtendedResponse), nil
+}
+
+// decodeControlValue decodes the value of the control as BER.
+// It returns nil if the control doesn't have the value.
+func decodeControlValue(con *message.Control) (*ber.Packet, error) {
+	if con.ControlValue() == nil {
+		return nil, nil
+	}
+	packet, err := ber.DecodePacketErr(con.ControlValue().Bytes())
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to decode the control value. type: %s, err: %w", con.ControlType(), err)
+	}
+	return packet, nil
+}
+
+// SortRequestControl is the server side sort request control.
+// https://tools.ietf.org/html/rfc2891
+//
+//	SortKeyList ::= SEQUENCE OF SEQUENCE {
+//	           attributeType   AttributeDescription,
+//	           orderingRule    [0] MatchingRuleId OPTIONAL,
+//	           reverseOrder    [1] BOOLEAN DEFAULT FALSE }
+type SortRequestControl struct {
+	Criticality bool
+	Keys        []*SortRequestKey
+}
+
+type SortRequestKey struct {
+	AttributeType string
+	OrderingRule  string
+	ReverseOrder  bool
+}
+
+func parseSortRequestControl(con *message.Control) (*SortRequestControl, error) {
+	packet, err := decodeControlValue(con)
+	if err != nil {
+		return nil, err
+	}
+	if packet == nil || len(packet.Children) == 0 {
+		return nil, xerrors.Errorf("Invalid sort request control. No sort keys.")
+	}
+
+	keys := make([]*SortRequestKey, len(packet.Children))
+
+	for i, child := range packet.Children {
+		if len(child.Children) == 0 {
+			return nil, xerrors.Errorf("Invalid sort request control. No attributeType. index: %d", i)
+		}
+
+		key := &SortRequestKey{
+			AttributeType: child.Children[0].Data.String(),
+		}
+
+		for _, v := range child.Children[1:] {
+			switch v.Tag {
+			case 0:
+				key.OrderingRule = v.Data.String()
+			case 1:
+				key.ReverseOrder = len(v.Data.Bytes()) > 0 && v.Data.Bytes()[0] != 0
+			default:
+				return nil, xerrors.Errorf("Invalid sort request control. Unexpected tag: %d, index: %d", v.Tag, i)
+			}
+		}
+
+		keys[i] = key
+	}
+
+	return &SortRequestControl{
+		Criticality: bool(con.Criticality()),
+		Keys:        keys,
+	}, nil
+}
+
+// newSortResponseControl returns the server side sort response control.
+//
+//	SortResult ::= SEQUENCE {
+//	   sortResult  ENUMERATED,
+//	   attributeType [0] AttributeDescription OPTIONAL }
+func newSortResponseControl(resultCode int, attributeType string) (message.Control, error) {
+	value := ber.NewSequence("SortResult")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, "sortResult"))
+	if attributeType != "" {
+		value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, attributeType, "attributeType"))
+	}
+	return newControl(SortResponseControlOID, false, value)
+}
+
+// VLVRequestControl is the virtual list view request control.
+// https://tools.ietf.org/html/draft-ietf-ldapext-ldapv3-vlv-09
+//
+//	VirtualListViewRequest ::= SEQUENCE {
+//	        beforeCount    INTEGER (0..maxInt),
+//	        afterCount     INTEGER (0..maxInt),
+//	        target       CHOICE {
+//	                       byOffset        [0] SEQUENCE {
+//	                            offset          INTEGER (1 .. maxInt),
+//	                            contentCount    INTEGER (0 .. maxInt) },
+//	                       greaterThanOrEqual [1] AssertionValue },
+//	        contextID     OCTET STRING OPTIONAL }
+type VLVRequestControl struct {
+	Criticality  bool
+	BeforeCount  int32
+	AfterCount   int32
+	Offset       int32
+	ContentCount int32
+	// GreaterThanOrEqual is nil when the target is specified by offset
+	GreaterThanOrEqual *string
+	ContextID          string
+}
+
+func parseVLVRequestControl(con *message.Control) (*VLVRequestControl, error) {
+	packet, err := decodeControlValue(con)
+	if err != nil {
+		return nil, err
+	}
+	if packet == nil || len(packet.Children) < 3 {
+		return nil, xerrors.Errorf("Invalid vlv request control. Not enough components.")
+	}
+
+	beforeCount, err := parseInt32(packet.Children[0])
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid vlv request control. Invalid beforeCount. err: %w", err)
+	}
+	afterCount, err := parseInt32(packet.Children[1])
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid vlv request control. Invalid afterCount. err: %w", err)
+	}
+
+	c := &VLVRequestControl{
+		Criticality: bool(con.Criticality()),
+		BeforeCount: beforeCount,
+		AfterCount:  afterCount,
+	}
+
+	target := packet.Children[2]
+	switch target.Tag {
+	case 0:
+		if len(target.Children) != 2 {
+			return nil, xerrors.Errorf("Invalid vlv request control. Invalid byOffset.")
+		}
+		if c.Offset, err = parseInt32(target.Children[0]); err != nil {
+			return nil, xerrors.Errorf("Invalid vlv request control. Invalid offset. err: %w", err)
+		}
+		if c.ContentCount, err = parseInt32(target.Children[1]); err != nil {
+			return nil, xerrors.Errorf("Invalid vlv request control. Invalid contentCount. err: %w", err)
+		}
+	case 1:
+		v := target.Data.String()
+		c.GreaterThanOrEqual = &v
+	default:
+		return nil, xerrors.Errorf("Invalid vlv request control. Unexpected target tag: %d", target.Tag)
+	}
+
+	if len(packet.Children) > 3 {
+		c.ContextID = packet.Children[3].Data.String()
+	}
+
+	return c, nil
+}
+
+// newVLVResponseControl returns the virtual list view response control.
+//
+//	VirtualListViewResponse ::= SEQUENCE {
+//	        targetPosition    INTEGER (0 .. maxInt),
+//	        contentCount     INTEGER (0 .. maxInt),
+//	        virtualListViewResult ENUMERATED {...},
+//	        contextID     OCTET STRING OPTIONAL }
+func newVLVResponseControl(targetPosition, contentCount int32, resultCode int, contextID string) (message.Control, error) {
+	value := ber.NewSequence("VirtualListViewResponse")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, targetPosition, "targetPosition"))
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, contentCount, "contentCount"))
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, "virtualListViewResult"))
+	if contextID != "" {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, contextID, "contextID"))
+	}
+	return newControl(VLVResponseControlOID, false, value)
+}
+
+// hasControl returns true if the request has the control of the type.
+func hasControl(controls *message.Controls, controlType string) bool {
+	_, ok := findControl(controls, controlType)
+	return ok
+}
+
+// findControl returns the first control of the type in the request.
+func findControl(controls *message.Controls, controlType string) (*message.Control, bool) {
+	if controls == nil {
+		return nil, false
+	}
+	for i, con := range *controls {
+		if string(con.ControlType()) == controlType {
+			return &(*controls)[i], true
+		}
+	}
+	return nil, false
+}
+
+// newPPolicyResponseControl returns the password policy response control.
+// The negative value means the warning or the error is omitted.
+// https://tools.ietf.org/html/draft-behera-ldap-password-policy-10
+//
+//	PasswordPolicyResponseValue ::= SEQUENCE {
+//	   warning [0] CHOICE {
+//	      timeBeforeExpiration [0] INTEGER (0 .. maxInt),
+//	      graceAuthNsRemaining [1] INTEGER (0 .. maxInt) } OPTIONAL,
+//	   error   [1] ENUMERATED {...} OPTIONAL }
+func newPPolicyResponseControl(timeBeforeExpiration int64, graceAuthNsRemaining, ppolicyError int) (message.Control, error) {
+	value := ber.NewSequence("PasswordPolicyResponseValue")
+	if timeBeforeExpiration >= 0 || graceAuthNsRemaining >= 0 {
+		warning := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "warning")
+		if timeBeforeExpiration >= 0 {
+			warning.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, 0, timeBeforeExpiration, "timeBeforeExpiration"))
+		} else {
+			warning.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, 1, graceAuthNsRemaining, "graceAuthNsRemaining"))
+		}
+		value.AppendChild(warning)
+	}
+	if ppolicyError >= 0 {
+		value.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, 1, ppolicyError, "error"))
+	}
+	return newControl(PPolicyControlOID, false, value)
+}
+
+// newIntermediateResponse returns the intermediate response which has the response name and value.
+// Like the extended response, it's read back from a dummy LDAP message.
+func newIntermediateResponse(responseName string, value *ber.Packet) (message.IntermediateResponse, error) {
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 25, nil, "Intermediate Response")
+	if responseName != "" {
+		res.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, responseName, "responseName"))
+	}
+	if value != nil {
+		res.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, string(value.Bytes()), "responseValue"))
+	}
+
+	msg, err := readDummyLDAPMessage(res, nil)
+	if err != nil {
+		return message.IntermediateResponse{}, xerrors.Errorf("Failed to build the intermediate response. name: %s, err: %w", responseName, err)
+	}
+
+	return msg.ProtocolOp().(message.IntermediateResponse), nil
+}
+
+// SyncRequestControl is the sync request control of the content synchronization operation.
+// https://tools.ietf.org/html/rfc4533
+//
+//	syncRequestValue ::= SEQUENCE {
+//	    mode ENUMERATED {
+//	        -- 0 unused
+//	        refreshOnly       (1),
+//	        -- 2 reserved
+//	        refreshAndPersist (3)
+//	    },
+//	    cookie     syncCookie OPTIONAL,
+//	    reloadHint BOOLEAN DEFAULT FALSE
+//	}
+type SyncRequestControl struct {
+	Criticality bool
+	Mode        int
+	Cookie      string
+	ReloadHint  bool
+}
+
+func parseSyncRequestControl(con *message.Control) (*SyncRequestControl, error) {
+	packet, err := decodeControlValue(con)
+	if err != nil {
+		return nil, err
+	}
+	if packet == nil || len(packet.Children) == 0 {
+		return nil, xerrors.Errorf("Invalid sync request control. No mode.")
+	}
+
+	mode, err := parseInt32(packet.Children[0])
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid sync request control. Invalid mode. err: %w", err)
+	}
+	if mode != SyncModeRefreshOnly && mode != SyncModeRefreshAndPersist {
+		return nil, xerrors.Errorf("Invalid sync request control. Unexpected mode: %d", mode)
+	}
+
+	c := &SyncRequestControl{
+		Criticality: bool(con.Criticality()),
+		Mode:        int(mode),
+	}
+
+	for _, v := range packet.Children[1:] {
+		switch v.Tag {
+		case ber.TagOctetString:
+			c.Cookie = v.Data.String()
+		case ber.TagBoolean:
+			c.ReloadHint = len(v.Data.Bytes()) > 0 && v.Data.Bytes()[0] != 0
+		default:
+			return nil, xerrors.Errorf("Invalid sync request control. Unexpected tag: %d", v.Tag)
+		}
+	}
+
+	return c, nil
+}
+
+// newSyncStateControl returns the sync state control attached to the search result entry.
+//
+//	syncStateValue ::= SEQUENCE {
+//	    state ENUMERATED {
+//	        present (0),
+//	        add (1),
+//	        modify (2),
+//	        delete (3)
+//	    },
+//	    entryUUID syncUUID,
+//	    cookie    syncCookie OPTIONAL
+//	}
+func newSyncStateControl(state int, entryUUID, cookie string) (message.Control, error) {
+	u, err := syncUUID(entryUUID)
+	if err != nil {
+		return message.Control{}, err
+	}
+	value := ber.NewSequence("syncStateValue")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, state, "state"))
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, u, "entryUUID"))
+	if cookie != "" {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cookie, "cookie"))
+	}
+	return newControl(SyncStateControlOID, false, value)
+}
+
+// newSyncDoneControl returns the sync done control attached to the search result done.
+//
+//	syncDoneValue ::= SEQUENCE {
+//	    cookie          syncCookie OPTIONAL,
+//	    refreshDeletes  BOOLEAN DEFAULT FALSE
+//	}
+func newSyncDoneControl(cookie string, refreshDeletes bool) (message.Control, error) {
+	value := ber.NewSequence("syncDoneValue")
+	if cookie != "" {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cookie, "cookie"))
+	}
+	if refreshDeletes {
+		value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "refreshDeletes"))
+	}
+	return newControl(SyncDoneControlOID, false, value)
+}
+
+// newSyncInfoNewCookie returns the sync info message which has the new cookie.
+//
+//	syncInfoValue ::= CHOICE {
+//	    newcookie      [0] syncCookie,
+//	    ...
+//	}
+func newSyncInfoNewCookie(cookie string) (message.IntermediateResponse, error) {
+	value := ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, cookie, "newcookie")
+	return newIntermediateResponse(SyncInfoOID, value)
+}
+
+// newSyncInfoRefreshDone returns the sync info message which ends the refresh stage of refreshAndPersist mode.
+// refreshPresent is used after the present phase, otherwise refreshDelete is used.
+//
+//	syncInfoValue ::= CHOICE {
+//	    ...
+//	    refreshDelete  [1] SEQUENCE {
+//	        cookie         syncCookie OPTIONAL,
+//	        refreshDone    BOOLEAN DEFAULT TRUE
+//	    },
+//	    refreshPresent [2] SEQUENCE {
+//	        cookie         syncCookie OPTIONAL,
+//	        refreshDone    BOOLEAN DEFAULT TRUE
+//	    },
+//	    ...
+//	}
+func newSyncInfoRefreshDone(present bool, cookie string) (message.IntermediateResponse, error) {
+	tag := ber.Tag(1)
+	if present {
+		tag = 2
+	}
+	value := ber.Encode(ber.ClassContext, ber.TypeConstructed, tag, nil, "refreshDone")
+	if cookie != "" {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cookie, "cookie"))
+	}
+	return newIntermediateResponse(SyncInfoOID, value)
+}
+
+// newSyncInfoIDSet returns the sync info message which has the set of entryUUID.
+// They are the deleted entries if refreshDeletes is true, otherwise they are the present entries.
+//
+//	syncInfoValue ::= CHOICE {
+//	    ...
+//	    syncIdSet      [3] SEQUENCE {
+//	        cookie         syncCookie OPTIONAL,
+//	        refreshDeletes BOOLEAN DEFAULT FALSE,
+//	        syncUUIDs      SET OF syncUUID
+//	    }
+//	}
+func newSyncInfoIDSet(cookie string, refreshDeletes bool, entryUUIDs []string) (message.IntermediateResponse, error) {
+	value := ber.Encode(ber.ClassContext, ber.TypeConstructed, 3, nil, "syncIdSet")
+	if cookie != "" {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, cookie, "cookie"))
+	}
+	if refreshDeletes {
+		value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "refreshDeletes"))
+	}
+	set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "syncUUIDs")
+	for _, v := range entryUUIDs {
+		u, err := syncUUID(v)
+		if err != nil {
+			return message.IntermediateResponse{}, err
+		}
+		set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, u, "syncUUID"))
+	}
+	value.AppendChild(set)
+	return newIntermediateResponse(SyncInfoOID, value)
+}
+
+// PersistentSearchControl is the persistent search control.
+// https://tools.ietf.org/html/draft-ietf-ldapext-psearch-03
+//
+//	PersistentSearch ::= SEQUENCE {
+//	    changeTypes INTEGER,
+//	    changesOnly BOOLEAN,
+//	    returnECs BOOLEAN
+//	}
+type PersistentSearchControl struct {
+	Criticality bool
+	ChangeTypes int
+	ChangesOnly bool
+	ReturnECs   bool
+}
+
+func parsePersistentSearchControl(con *message.Control) (*PersistentSearchControl, error) {
+	packet, err := decodeControlValue(con)
+	if err != nil {
+		return nil, err
+	}
+	if packet == nil || len(packet.Children) != 3 {
+		return nil, xerrors.Errorf("Invalid persistent search control. Unexpected value.")
+	}
+
+	changeTypes, err := parseInt32(packet.Children[0])
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid persistent search control. Invalid changeTypes. err: %w", err)
+	}
+	if changeTypes <= 0 || changeTypes&^PSearchChangeAll != 0 {
+		return nil, xerrors.Errorf("Invalid persistent search control. Unexpected changeTypes: %d", changeTypes)
+	}
+
+	parseBool := func(p *ber.Packet, name string) (bool, error) {
+		if p.Tag != ber.TagBoolean {
+			return false, xerrors.Errorf("Invalid persistent search control. Unexpected tag of %s: %d", name, p.Tag)
+		}
+		return len(p.Data.Bytes()) > 0 && p.Data.Bytes()[0] != 0, nil
+	}
+	changesOnly, err := parseBool(packet.Children[1], "changesOnly")
+	if err != nil {
+		return nil, err
+	}
+	returnECs, err := parseBool(packet.Children[2], "returnECs")
+	if err != nil {
+		return nil, err
+	}
+
+	return &PersistentSearchControl{
+		Criticality: bool(con.Criticality()),
+		ChangeTypes: int(changeTypes),
+		ChangesOnly: changesOnly,
+		ReturnECs:   returnECs,
+	}, nil
+}
+
+// newEntryChangeNotificationControl returns the entry change notification control attached to the search result entry.
+//
+//	EntryChangeNotification ::= SEQUENCE {
+//	    changeType ENUMERATED {
+//	        add             (1),
+//	        delete          (2),
+//	        modify          (4),
+//	        modDN           (8)
+//	    },
+//	    previousDN   LDAPDN OPTIONAL,     -- modifyDN ops. only
+//	    changeNumber INTEGER OPTIONAL     -- if supported
+//	}
+func newEntryChangeNotificationControl(changeType int, previousDN string) (message.Control, error) {
+	value := ber.NewSequence("EntryChangeNotification")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, changeType, "changeType"))
+	if changeType == PSearchChangeModDN {
+		value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, previousDN, "previousDN"))
+	}
+	return newControl(EntryChangeNotificationControlOID, false, value)
+}
+
+// parseAssertionControl returns the filter of the assertion control.
+// https://tools.ietf.org/html/rfc4528
+func parseAssertionControl(con *message.Control) (message.Filter, error) {
+	packet, err := decodeControlValue(con)
+	if err != nil {
+		return nil, err
+	}
+	if packet == nil {
+		return nil, xerrors.Errorf("Invalid assertion control. No value.")
+	}
+
+	filter, err := readDummyFilter(packet)
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid assertion control. Invalid filter. err: %w", err)
+	}
+	return filter, nil
+}
+
+// parseReadEntryControl returns the attribute selection of the pre-read or post-read control.
+// https://tools.ietf.org/html/rfc4527
+//
+//	AttributeSelection ::= SEQUENCE OF selector LDAPString
+func parseReadEntryControl(con *message.Control) (message.AttributeSelection, error) {
+	packet, err := decodeControlValue(con)
+	if err != nil {
+		return nil, err
+	}
+	if packet == nil || packet.Tag != ber.TagSequence {
+		return nil, xerrors.Errorf("Invalid read entry control. Unexpected value. type: %s", con.ControlType())
+	}
+
+	attrs := message.AttributeSelection{}
+	for _, child := range packet.Children {
+		if child.Tag != ber.TagOctetString {
+			return nil, xerrors.Errorf("Invalid read entry control. Unexpected tag of the selector: %d, type: %s", child.Tag, con.ControlType())
+		}
+		attrs = append(attrs, message.LDAPString(child.Data.String()))
+	}
+	return attrs, nil
+}
+
+// newReadEntryControl returns the pre-read or post-read response control which has the entry.
+// goldap doesn't provide the encoder of the search result entry, so we take it from the encoded LDAP message.
+//
+//	SearchResultEntry ::= [APPLICATION 4] SEQUENCE {
+//	    objectName      LDAPDN,
+//	    attributes      PartialAttributeList }
+func newReadEntryControl(controlType string, entry message.SearchResultEntry) (message.Control, error) {
+	b, err := message.NewLDAPMessageWithProtocolOp(entry).Write()
+	if err != nil {
+		return message.Control{}, xerrors.Errorf("Failed to encode the entry. type: %s, err: %w", controlType, err)
+	}
+	packet, err := ber.DecodePacketErr(b.Bytes())
+	if err != nil {
+		return message.Control{}, xerrors.Errorf("Failed to decode the entry. type: %s, err: %w", controlType, err)
+	}
+	return newControl(controlType, false, packet.Children[1])
+}
+
+// syncUUID returns the 16 octets of the entryUUID.
+func syncUUID(entryUUID string) (string, error) {
+	u, err := uuid.Parse(entryUUID)
+	if err != nil {
+		return "", xerrors.Errorf("Invalid entryUUID: %s, err: %w", entryUUID, err)
+	}
+	return string(u[:]), nil
+}
+
+func parseInt32(packet *ber.Packet) (int32, error) {
+	if packet.ClassType == ber.ClassUniversal {
+		if v, ok := packet.Value.(int64); ok {
+			return int32(v), nil
+		}
+	}
+	v, err := ber.ParseInt64(packet.Data.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return int32(v), nil
+}
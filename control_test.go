@@ -0,0 +1,563 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"reflect"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+	ber "gopkg.in/asn1-ber.v1"
+)
+
+func TestSortRequestControl(t *testing.T) {
+	testcases := []struct {
+		Criticality bool
+		Keys        []*SortRequestKey
+	}{
+		{
+			false,
+			[]*SortRequestKey{
+				{AttributeType: "sn"},
+			},
+		},
+		{
+			true,
+			[]*SortRequestKey{
+				{AttributeType: "sn", ReverseOrder: true},
+				{AttributeType: "createTimestamp", OrderingRule: "generalizedTimeOrderingMatch"},
+			},
+		},
+	}
+
+	for i, tc := range testcases {
+		value := ber.NewSequence("SortKeyList")
+		for _, k := range tc.Keys {
+			key := ber.NewSequence("SortKey")
+			key.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k.AttributeType, "attributeType"))
+			if k.OrderingRule != "" {
+				key.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, k.OrderingRule, "orderingRule"))
+			}
+			if k.ReverseOrder {
+				key.AppendChild(ber.NewBoolean(ber.ClassContext, ber.TypePrimitive, 1, true, "reverseOrder"))
+			}
+			value.AppendChild(key)
+		}
+
+		con, err := newControl(SortRequestControlOID, tc.Criticality, value)
+		if err != nil {
+			t.Errorf("Unexpected error on %d: %+v", i, err)
+			continue
+		}
+
+		sc, err := parseSortRequestControl(&con)
+		if err != nil {
+			t.Errorf("Unexpected error on %d: %+v", i, err)
+			continue
+		}
+
+		if sc.Criticality != tc.Criticality {
+			t.Errorf("Unexpected criticality on %d: expected %v, got %v", i, tc.Criticality, sc.Criticality)
+		}
+		if !reflect.DeepEqual(sc.Keys, tc.Keys) {
+			t.Errorf("Unexpected keys on %d: expected %v, got %v", i, tc.Keys, sc.Keys)
+		}
+	}
+}
+
+func TestSortResponseControl(t *testing.T) {
+	con, err := newSortResponseControl(18, "cn")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	if string(con.ControlType()) != SortResponseControlOID {
+		t.Errorf("Unexpected control type: %s", con.ControlType())
+	}
+
+	packet, err := decodeControlValue(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(packet.Children) != 2 {
+		t.Fatalf("Unexpected children: %d", len(packet.Children))
+	}
+	if packet.Children[0].Value.(int64) != 18 {
+		t.Errorf("Unexpected sortResult: %v", packet.Children[0].Value)
+	}
+	if packet.Children[1].Data.String() != "cn" {
+		t.Errorf("Unexpected attributeType: %s", packet.Children[1].Data.String())
+	}
+}
+
+func TestVLVRequestControl(t *testing.T) {
+	byOffset := ber.NewSequence("VirtualListViewRequest")
+	byOffset.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 2, "beforeCount"))
+	byOffset.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 10, "afterCount"))
+	target := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "byOffset")
+	target.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 5000, "offset"))
+	target.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, "contentCount"))
+	byOffset.AppendChild(target)
+
+	con, err := newControl(VLVRequestControlOID, true, byOffset)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	vc, err := parseVLVRequestControl(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	expected := &VLVRequestControl{
+		Criticality: true,
+		BeforeCount: 2,
+		AfterCount:  10,
+		Offset:      5000,
+	}
+	if !reflect.DeepEqual(vc, expected) {
+		t.Errorf("Unexpected vlv request control: expected %v, got %v", expected, vc)
+	}
+
+	gte := ber.NewSequence("VirtualListViewRequest")
+	gte.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 0, "beforeCount"))
+	gte.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 20, "afterCount"))
+	gte.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, "Smith", "greaterThanOrEqual"))
+	gte.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "ctx", "contextID"))
+
+	con, err = newControl(VLVRequestControlOID, false, gte)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	vc, err = parseVLVRequestControl(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if vc.GreaterThanOrEqual == nil || *vc.GreaterThanOrEqual != "Smith" || vc.AfterCount != 20 || vc.ContextID != "ctx" {
+		t.Errorf("Unexpected vlv request control: %v", vc)
+	}
+}
+
+func TestResolveVLVWindowSize(t *testing.T) {
+	server := NewServer(&ServerConfig{
+		Suffix:           "dc=example,dc=com",
+		QueryTranslator:  "default",
+		VLVMaxWindowSize: 10,
+	})
+	server.LoadSchema()
+
+	at, _ := server.schemaMap.AttributeType("cn")
+	sortKeys := []*SortKey{{AttributeType: at}}
+
+	testcases := []struct {
+		BeforeCount int32
+		AfterCount  int32
+		Expected    int
+	}{
+		{0, 9, ldap.LDAPResultSuccess},
+		{4, 5, ldap.LDAPResultSuccess},
+		{0, 10, ldap.LDAPResultAdminLimitExceeded},
+		{-1, 0, ldap.LDAPResultAdminLimitExceeded},
+	}
+
+	for i, tc := range testcases {
+		_, result := resolveVLVOption(server, &VLVRequestControl{
+			BeforeCount: tc.BeforeCount,
+			AfterCount:  tc.AfterCount,
+			Offset:      1,
+		}, sortKeys, nil)
+		if result != tc.Expected {
+			t.Errorf("Unexpected result on %d: expected %d, got %d", i, tc.Expected, result)
+		}
+	}
+
+	// The default limit is used if it isn't configured
+	server.config.VLVMaxWindowSize = 0
+	if _, result := resolveVLVOption(server, &VLVRequestControl{AfterCount: defaultVLVMaxWindowSize - 1, Offset: 1}, sortKeys, nil); result != ldap.LDAPResultSuccess {
+		t.Errorf("Unexpected result with the default limit: %d", result)
+	}
+	if _, result := resolveVLVOption(server, &VLVRequestControl{AfterCount: defaultVLVMaxWindowSize, Offset: 1}, sortKeys, nil); result != ldap.LDAPResultAdminLimitExceeded {
+		t.Errorf("Unexpected result over the default limit: %d", result)
+	}
+}
+
+func TestPPolicyResponseControl(t *testing.T) {
+	testcases := []struct {
+		TimeBeforeExpiration int64
+		GraceAuthNsRemaining int
+		Error                int
+		// Expected tag and value of the warning, nil if omitted
+		Warning []int64
+		// Expected value of the error, nil if omitted
+		ExpectedError []int64
+	}{
+		{-1, -1, -1, nil, nil},
+		{600, -1, -1, []int64{0, 600}, nil},
+		{-1, 2, -1, []int64{1, 2}, nil},
+		{-1, -1, PPolicyErrorAccountLocked, nil, []int64{1}},
+		{-1, 0, PPolicyErrorChangeAfterReset, []int64{1, 0}, []int64{2}},
+	}
+
+	for i, tc := range testcases {
+		con, err := newPPolicyResponseControl(tc.TimeBeforeExpiration, tc.GraceAuthNsRemaining, tc.Error)
+		if err != nil {
+			t.Fatalf("Unexpected error on %d: %+v", i, err)
+		}
+		if con.ControlType() != PPolicyControlOID {
+			t.Errorf("Unexpected control type on %d: %s", i, con.ControlType())
+		}
+
+		value, err := decodeControlValue(&con)
+		if err != nil {
+			t.Fatalf("Unexpected error on %d: %+v", i, err)
+		}
+
+		var warning, ppolicyError []int64
+		for _, child := range value.Children {
+			switch child.Tag {
+			case 0:
+				v, err := ber.ParseInt64(child.Children[0].Data.Bytes())
+				if err != nil {
+					t.Fatalf("Unexpected error on %d: %+v", i, err)
+				}
+				warning = []int64{int64(child.Children[0].Tag), v}
+			case 1:
+				v, err := ber.ParseInt64(child.Data.Bytes())
+				if err != nil {
+					t.Fatalf("Unexpected error on %d: %+v", i, err)
+				}
+				ppolicyError = []int64{v}
+			}
+		}
+
+		if !reflect.DeepEqual(warning, tc.Warning) {
+			t.Errorf("Unexpected warning on %d: expected %v, got %v", i, tc.Warning, warning)
+		}
+		if !reflect.DeepEqual(ppolicyError, tc.ExpectedError) {
+			t.Errorf("Unexpected error on %d: expected %v, got %v", i, tc.ExpectedError, ppolicyError)
+		}
+	}
+}
+
+func TestSyncRequestControl(t *testing.T) {
+	value := ber.NewSequence("syncRequestValue")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, SyncModeRefreshAndPersist, "mode"))
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "rid=001,changeNumber=10", "cookie"))
+	value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "reloadHint"))
+
+	con, err := newControl(SyncRequestControlOID, true, value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	sc, err := parseSyncRequestControl(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	expected := &SyncRequestControl{
+		Criticality: true,
+		Mode:        SyncModeRefreshAndPersist,
+		Cookie:      "rid=001,changeNumber=10",
+		ReloadHint:  true,
+	}
+	if !reflect.DeepEqual(sc, expected) {
+		t.Errorf("Unexpected sync request control: expected %v, got %v", expected, sc)
+	}
+
+	// Unknown mode
+	value = ber.NewSequence("syncRequestValue")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, 2, "mode"))
+	con, err = newControl(SyncRequestControlOID, false, value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if _, err := parseSyncRequestControl(&con); err == nil {
+		t.Errorf("Expected error for the reserved mode")
+	}
+}
+
+func TestSyncStateControl(t *testing.T) {
+	con, err := newSyncStateControl(SyncStateModify, "0b05df74-1219-495d-9d95-dc0c05e00aa9", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if string(con.ControlType()) != SyncStateControlOID {
+		t.Errorf("Unexpected control type: %s", con.ControlType())
+	}
+
+	packet, err := decodeControlValue(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(packet.Children) != 2 {
+		t.Fatalf("Unexpected children: %d", len(packet.Children))
+	}
+	if packet.Children[0].Value.(int64) != SyncStateModify {
+		t.Errorf("Unexpected state: %v", packet.Children[0].Value)
+	}
+	if u := packet.Children[1].Data.Bytes(); len(u) != 16 || u[0] != 0x0b || u[15] != 0xa9 {
+		t.Errorf("Unexpected entryUUID: %x", u)
+	}
+
+	if _, err := newSyncStateControl(SyncStateAdd, "invalid", ""); err == nil {
+		t.Errorf("Expected error for the invalid entryUUID")
+	}
+}
+
+func TestSyncDoneControl(t *testing.T) {
+	con, err := newSyncDoneControl("changeNumber=10", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	packet, err := decodeControlValue(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(packet.Children) != 2 || packet.Children[0].Data.String() != "changeNumber=10" || packet.Children[1].Value != true {
+		t.Errorf("Unexpected sync done control: %v", packet.Children)
+	}
+
+	// refreshDeletes is omitted when it's the default value
+	con, err = newSyncDoneControl("changeNumber=10", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	packet, err = decodeControlValue(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(packet.Children) != 1 {
+		t.Errorf("Unexpected sync done control: %v", packet.Children)
+	}
+}
+
+func TestSyncInfoIDSet(t *testing.T) {
+	info, err := newSyncInfoIDSet("", true, []string{
+		"0b05df74-1219-495d-9d95-dc0c05e00aa9",
+		"1b05df74-1219-495d-9d95-dc0c05e00aa9",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	b, err := message.NewLDAPMessageWithProtocolOp(info).Write()
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	packet, err := ber.DecodePacketErr(b.Bytes())
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	res := packet.Children[1]
+	if res.Tag != 25 || len(res.Children) != 2 {
+		t.Fatalf("Unexpected intermediate response: %v", res)
+	}
+	if res.Children[0].Data.String() != SyncInfoOID {
+		t.Errorf("Unexpected responseName: %s", res.Children[0].Data.String())
+	}
+
+	value, err := ber.DecodePacketErr(res.Children[1].Data.Bytes())
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if value.Tag != 3 || len(value.Children) != 2 {
+		t.Fatalf("Unexpected syncIdSet: %v", value)
+	}
+	if value.Children[0].Value != true {
+		t.Errorf("Unexpected refreshDeletes: %v", value.Children[0].Value)
+	}
+	if len(value.Children[1].Children) != 2 {
+		t.Errorf("Unexpected syncUUIDs: %v", value.Children[1].Children)
+	}
+}
+
+func TestPersistentSearchControl(t *testing.T) {
+	value := ber.NewSequence("PersistentSearch")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, PSearchChangeAdd|PSearchChangeModDN, "changeTypes"))
+	value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "changesOnly"))
+	value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, "returnECs"))
+
+	con, err := newControl(PersistentSearchControlOID, true, value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	pc, err := parsePersistentSearchControl(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	expected := &PersistentSearchControl{
+		Criticality: true,
+		ChangeTypes: PSearchChangeAdd | PSearchChangeModDN,
+		ChangesOnly: true,
+		ReturnECs:   false,
+	}
+	if !reflect.DeepEqual(pc, expected) {
+		t.Errorf("Unexpected persistent search control: expected %v, got %v", expected, pc)
+	}
+
+	// Unknown change type
+	value = ber.NewSequence("PersistentSearch")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 16, "changeTypes"))
+	value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "changesOnly"))
+	value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "returnECs"))
+	con, err = newControl(PersistentSearchControlOID, false, value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if _, err := parsePersistentSearchControl(&con); err == nil {
+		t.Errorf("Expected error for the unknown change type")
+	}
+
+	// Missing returnECs
+	value = ber.NewSequence("PersistentSearch")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, PSearchChangeAll, "changeTypes"))
+	value.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "changesOnly"))
+	con, err = newControl(PersistentSearchControlOID, false, value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if _, err := parsePersistentSearchControl(&con); err == nil {
+		t.Errorf("Expected error for the missing returnECs")
+	}
+}
+
+func TestEntryChangeNotificationControl(t *testing.T) {
+	con, err := newEntryChangeNotificationControl(PSearchChangeModDN, "uid=user1,ou=Users,dc=example,dc=com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if string(con.ControlType()) != EntryChangeNotificationControlOID {
+		t.Errorf("Unexpected control type: %s", con.ControlType())
+	}
+	packet, err := decodeControlValue(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(packet.Children) != 2 || packet.Children[0].Value.(int64) != PSearchChangeModDN || packet.Children[1].Data.String() != "uid=user1,ou=Users,dc=example,dc=com" {
+		t.Errorf("Unexpected entry change notification control: %v", packet.Children)
+	}
+
+	// previousDN is only for modDN
+	con, err = newEntryChangeNotificationControl(PSearchChangeModify, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	packet, err = decodeControlValue(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(packet.Children) != 1 || packet.Children[0].Value.(int64) != PSearchChangeModify {
+		t.Errorf("Unexpected entry change notification control: %v", packet.Children)
+	}
+}
+
+func TestAssertionControl(t *testing.T) {
+	expected, err := compileFilter("(&(objectClass=person)(|(cn=user1)(!(sn=*))))")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	packet, err := goldap.CompileFilter("(&(objectClass=person)(|(cn=user1)(!(sn=*))))")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	value, err := ber.DecodePacketErr(packet.Bytes())
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	con, err := newControl(AssertionControlOID, true, value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	filter, err := parseAssertionControl(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Unexpected assertion filter: %#v", filter)
+	}
+
+	// The filter is required
+	con, err = newControl(AssertionControlOID, true, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if _, err := parseAssertionControl(&con); err == nil {
+		t.Errorf("Expected error for the missing filter")
+	}
+
+	con, err = newControl(AssertionControlOID, true, ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "cn=user1", "filter"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if _, err := parseAssertionControl(&con); err == nil {
+		t.Errorf("Expected error for the invalid filter")
+	}
+}
+
+func TestReadEntryControl(t *testing.T) {
+	value := ber.NewSequence("AttributeSelection")
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "entryUUID", "selector"))
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "modifyTimestamp", "selector"))
+
+	con, err := newControl(PostReadControlOID, true, value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	attrs, err := parseReadEntryControl(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(attrs, message.AttributeSelection{"entryUUID", "modifyTimestamp"}) {
+		t.Errorf("Unexpected attribute selection: %v", attrs)
+	}
+
+	// Empty selection means all user attributes
+	con, err = newControl(PreReadControlOID, true, ber.NewSequence("AttributeSelection"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	attrs, err = parseReadEntryControl(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(attrs) != 0 {
+		t.Errorf("Unexpected attribute selection: %v", attrs)
+	}
+
+	con, err = newControl(PreReadControlOID, true, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if _, err := parseReadEntryControl(&con); err == nil {
+		t.Errorf("Expected error for the missing value")
+	}
+
+	// Response
+	e := message.SearchResultEntry{}
+	e.SetObjectName("uid=user1,ou=Users,dc=example,dc=com")
+	e.AddAttribute("entryUUID", "7b0c3a1e-2b1c-4c5a-9f5e-1d2c3b4a5f60")
+	e.AddAttribute("cn", "user1", "user 1")
+
+	con, err = newReadEntryControl(PostReadControlOID, e)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if string(con.ControlType()) != PostReadControlOID || bool(con.Criticality()) {
+		t.Errorf("Unexpected control: %v", con)
+	}
+	packet, err := decodeControlValue(&con)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if packet.ClassType != ber.ClassApplication || packet.Tag != 4 || len(packet.Children) != 2 {
+		t.Fatalf("Unexpected search result entry: %v", packet)
+	}
+	if packet.Children[0].Data.String() != "uid=user1,ou=Users,dc=example,dc=com" {
+		t.Errorf("Unexpected objectName: %s", packet.Children[0].Data.String())
+	}
+	attrList := packet.Children[1].Children
+	if len(attrList) != 2 || attrList[1].Children[0].Data.String() != "cn" || len(attrList[1].Children[1].Children) != 2 {
+		t.Errorf("Unexpected attributes: %v", attrList)
+	}
+}
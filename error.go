@@ -38,6 +38,29 @@ func (e *LDAPError) IsAccountLocking() bool {
 	return e.Code == ldap.LDAPResultInvalidCredentials && e.Subtype == "Account locking"
 }
 
+func (e *LDAPError) IsPasswordExpired() bool {
+	return e.Code == ldap.LDAPResultInvalidCredentials && e.Subtype == "Password expired"
+}
+
+// PPolicyError returns the error code of the password policy response control, or -1 if no error.
+func (e *LDAPError) PPolicyError() int {
+	switch e.Subtype {
+	case "Password expired":
+		return PPolicyErrorPasswordExpired
+	case "Account locked":
+		return PPolicyErrorAccountLocked
+	case "Insufficient password quality":
+		return PPolicyErrorInsufficientPasswordQuality
+	case "Password too short":
+		return PPolicyErrorPasswordTooShort
+	case "Password too young":
+		return PPolicyErrorPasswordTooYoung
+	case "Password in history":
+		return PPolicyErrorPasswordInHistory
+	}
+	return -1
+}
+
 func NewSuccess() *LDAPError {
 	return &LDAPError{
 		Code: ldap.LDAPResultSuccess,
@@ -58,6 +81,13 @@ func NewUndefinedType(attr string) *LDAPError {
 	}
 }
 
+func NewInappropriateMatching(attr string) *LDAPError {
+	return &LDAPError{
+		Code: 18,
+		Msg:  fmt.Sprintf("%s: no equality matching rule", attr),
+	}
+}
+
 func NewMultipleValuesProvidedError(attr string) *LDAPError {
 	return &LDAPError{
 		Code: 19,
@@ -100,6 +130,13 @@ func NewInvalidPerSyntax(attr string, valueidx int) *LDAPError {
 	}
 }
 
+func NewInvalidAccessRule(valueidx int, msg string) *LDAPError {
+	return &LDAPError{
+		Code: 21,
+		Msg:  fmt.Sprintf("olcAccess: value #%d invalid access rule: %s", valueidx, msg),
+	}
+}
+
 func NewNoSuchObjectWithMatchedDN(dn string) *LDAPError {
 	return &LDAPError{
 		Code:      ldap.LDAPResultNoSuchObject,
@@ -140,12 +177,107 @@ func NewAccountLocked() *LDAPError {
 	}
 }
 
+func NewPasswordExpired() *LDAPError {
+	return &LDAPError{
+		Code:    ldap.LDAPResultInvalidCredentials,
+		Msg:     "password expired",
+		Subtype: "Password expired",
+	}
+}
+
+func NewPasswordTooShort() *LDAPError {
+	return &LDAPError{
+		Code:    19,
+		Msg:     "password is too short",
+		Subtype: "Password too short",
+	}
+}
+
+func NewInsufficientPasswordQuality() *LDAPError {
+	return &LDAPError{
+		Code:    19,
+		Msg:     "password fails quality checking policy",
+		Subtype: "Insufficient password quality",
+	}
+}
+
+func NewPasswordTooYoung() *LDAPError {
+	return &LDAPError{
+		Code:    19,
+		Msg:     "password is too young to change",
+		Subtype: "Password too young",
+	}
+}
+
+func NewPasswordInHistory() *LDAPError {
+	return &LDAPError{
+		Code:    19,
+		Msg:     "password is in history of old passwords",
+		Subtype: "Password in history",
+	}
+}
+
+func NewPrehashedPasswordNotAllowed() *LDAPError {
+	return &LDAPError{
+		Code: 19,
+		Msg:  "userPassword: pre-hashed password is not allowed",
+	}
+}
+
+func NewNoSuchPPolicy(dn string) *LDAPError {
+	return &LDAPError{
+		Code: 19,
+		Msg:  fmt.Sprintf("pwdPolicySubentry: no such password policy entry: %s", dn),
+	}
+}
+
+func NewAuthMethodNotSupported(mechanism string) *LDAPError {
+	return &LDAPError{
+		Code: ldap.LDAPResultAuthMethodNotSupported,
+		Msg:  fmt.Sprintf("%s: SASL mechanism not supported", mechanism),
+	}
+}
+
+func NewInappropriateAuthentication(msg string) *LDAPError {
+	return &LDAPError{
+		Code: ldap.LDAPResultInappropriateAuthentication,
+		Msg:  msg,
+	}
+}
+
 func NewInsufficientAccess() *LDAPError {
 	return &LDAPError{
 		Code: 50,
 	}
 }
 
+func NewAuthorizationDenied(msg string) *LDAPError {
+	return &LDAPError{
+		Code: LDAPResultAuthorizationDenied,
+		Msg:  msg,
+	}
+}
+
+func NewAssertionFailed() *LDAPError {
+	return &LDAPError{
+		Code: LDAPResultAssertionFailed,
+	}
+}
+
+func NewConfidentialityRequired() *LDAPError {
+	return &LDAPError{
+		Code: ldap.LDAPResultConfidentialityRequired,
+		Msg:  "TLS confidentiality required",
+	}
+}
+
+func NewUnwillingToPerform(msg string) *LDAPError {
+	return &LDAPError{
+		Code: ldap.LDAPResultUnwillingToPerform,
+		Msg:  msg,
+	}
+}
+
 func NewNoGlobalSuperiorKnowledge() *LDAPError {
 	return &LDAPError{
 		Code: ldap.LDAPResultUnwillingToPerform,
@@ -214,6 +346,13 @@ func NewUnavailable() *LDAPError {
 	}
 }
 
+func NewProtocolError(msg string) *LDAPError {
+	return &LDAPError{
+		Code: ldap.LDAPResultProtocolError,
+		Msg:  msg,
+	}
+}
+
 func NewOperationsError() *LDAPError {
 	return &LDAPError{
 		Code: ldap.LDAPResultOperationsError,
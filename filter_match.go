@@ -0,0 +1,214 @@
+package ldap_pg
+
+import (
+	"log"
+	"strings"
+
+	"github.com/openstandia/goldap/message"
+)
+
+// filterAttributes returns the attribute descriptions used in the filter.
+func filterAttributes(filter message.Filter) []string {
+	var attrs []string
+
+	switch f := filter.(type) {
+	case message.FilterAnd:
+		for _, child := range f {
+			attrs = append(attrs, filterAttributes(child)...)
+		}
+	case message.FilterOr:
+		for _, child := range f {
+			attrs = append(attrs, filterAttributes(child)...)
+		}
+	case message.FilterNot:
+		attrs = filterAttributes(f.Filter)
+	case message.FilterPresent:
+		attrs = []string{string(f)}
+	case message.FilterEqualityMatch:
+		attrs = []string{string(f.AttributeDesc())}
+	case message.FilterApproxMatch:
+		attrs = []string{string(f.AttributeDesc())}
+	case message.FilterGreaterOrEqual:
+		attrs = []string{string(f.AttributeDesc())}
+	case message.FilterLessOrEqual:
+		attrs = []string{string(f.AttributeDesc())}
+	case message.FilterSubstrings:
+		attrs = []string{string(f.Type_())}
+	}
+	return attrs
+}
+
+// matchFilter evaluates the filter against the entry in memory.
+// It's used when the entry is already fetched, e.g. the filter of the access rule.
+func matchFilter(schemaMap *SchemaMap, filter message.Filter, entry *SearchEntry) bool {
+	switch f := filter.(type) {
+	case message.FilterAnd:
+		for _, child := range f {
+			if !matchFilter(schemaMap, child, entry) {
+				return false
+			}
+		}
+		return true
+	case message.FilterOr:
+		for _, child := range f {
+			if matchFilter(schemaMap, child, entry) {
+				return true
+			}
+		}
+		return false
+	case message.FilterNot:
+		return !matchFilter(schemaMap, f.Filter, entry)
+	case message.FilterPresent:
+		_, values, ok := entry.GetAttrOrig(string(f))
+		return ok && len(values) > 0
+	case message.FilterEqualityMatch:
+		return matchEquality(schemaMap, entry, string(f.AttributeDesc()), string(f.AssertionValue()))
+	case message.FilterApproxMatch:
+		return matchEquality(schemaMap, entry, string(f.AttributeDesc()), string(f.AssertionValue()))
+	case message.FilterGreaterOrEqual:
+		return matchOrdering(schemaMap, entry, string(f.AttributeDesc()), string(f.AssertionValue()), func(c int) bool {
+			return c >= 0
+		})
+	case message.FilterLessOrEqual:
+		return matchOrdering(schemaMap, entry, string(f.AttributeDesc()), string(f.AssertionValue()), func(c int) bool {
+			return c <= 0
+		})
+	case message.FilterSubstrings:
+		return matchSubstrings(schemaMap, entry, f)
+	default:
+		log.Printf("warn: Unsupported filter for matching in memory: %T", filter)
+		return false
+	}
+}
+
+func matchEquality(schemaMap *SchemaMap, entry *SearchEntry, attrDesc, assertion string) bool {
+	name, _, err := ParseLanguageTag(attrDesc)
+	if err != nil {
+		return false
+	}
+	at, ok := schemaMap.AttributeType(name)
+	if !ok {
+		return false
+	}
+	_, values, ok := entry.GetAttrOrig(attrDesc)
+	if !ok || len(values) == 0 {
+		return false
+	}
+
+	a, err := normalizeAssertionValue(schemaMap, at, attrDesc, assertion)
+	if err != nil {
+		log.Printf("info: Invalid assertion value for matching in memory. attr: %s, err: %v", attrDesc, err)
+		return false
+	}
+
+	for _, v := range values {
+		sv, err := NewSchemaValue(schemaMap, attrDesc, []string{v})
+		if err != nil {
+			continue
+		}
+		for _, nv := range sv.NormStr() {
+			if nv == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchOrdering(schemaMap *SchemaMap, entry *SearchEntry, attrDesc, assertion string, match func(c int) bool) bool {
+	_, values, ok := entry.GetAttrOrig(attrDesc)
+	if !ok || len(values) == 0 {
+		return false
+	}
+
+	asv, err := NewSchemaValue(schemaMap, attrDesc, []string{assertion})
+	if err != nil {
+		log.Printf("info: Invalid assertion value for matching in memory. attr: %s, err: %v", attrDesc, err)
+		return false
+	}
+
+	for _, v := range values {
+		sv, err := NewSchemaValue(schemaMap, attrDesc, []string{v})
+		if err != nil {
+			continue
+		}
+		if match(compareNormValue(sv, asv)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareNormValue compares the first normalized values, integer values are compared as number.
+func compareNormValue(v, a *SchemaValue) int {
+	if vi, ok := v.Norm()[0].(int64); ok {
+		if ai, ok := a.Norm()[0].(int64); ok {
+			switch {
+			case vi < ai:
+				return -1
+			case vi > ai:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	return strings.Compare(v.NormStr()[0], a.NormStr()[0])
+}
+
+func matchSubstrings(schemaMap *SchemaMap, entry *SearchEntry, f message.FilterSubstrings) bool {
+	attrDesc := string(f.Type_())
+	_, values, ok := entry.GetAttrOrig(attrDesc)
+	if !ok || len(values) == 0 {
+		return false
+	}
+
+	normalize := func(s string) (string, bool) {
+		sv, err := NewSchemaValue(schemaMap, attrDesc, []string{s})
+		if err != nil {
+			return "", false
+		}
+		return sv.NormStr()[0], true
+	}
+
+	for _, v := range values {
+		nv, ok := normalize(v)
+		if !ok {
+			continue
+		}
+		if matchSubstringsValue(nv, f.Substrings(), normalize) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSubstringsValue(value string, substrings []message.Substring, normalize func(string) (string, bool)) bool {
+	pos := 0
+	for _, fs := range substrings {
+		switch fsv := fs.(type) {
+		case message.SubstringInitial:
+			s, ok := normalize(string(fsv))
+			if !ok || !strings.HasPrefix(value, s) {
+				return false
+			}
+			pos = len(s)
+		case message.SubstringAny:
+			s, ok := normalize(string(fsv))
+			if !ok {
+				return false
+			}
+			i := strings.Index(value[pos:], s)
+			if i == -1 {
+				return false
+			}
+			pos += i + len(s)
+		case message.SubstringFinal:
+			s, ok := normalize(string(fsv))
+			if !ok || len(value)-len(s) < pos || !strings.HasSuffix(value, s) {
+				return false
+			}
+		}
+	}
+	return true
+}
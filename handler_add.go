@@ -3,6 +3,7 @@ package ldap_pg
 import (
 	"context"
 	"log"
+	"time"
 
 	ldap "github.com/openstandia/ldapserver"
 	"golang.org/x/xerrors"
@@ -20,7 +21,23 @@ func handleAdd(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 		return
 	}
 
-	if !s.RequiredAuthz(m, AddOps, dn) {
+	if s.RequiredConfidentiality(m) {
+		responseAddError(w, NewConfidentialityRequired())
+		return
+	}
+
+	if s.isChangelogDN(dn) {
+		responseAddError(w, NewUnwillingToPerform("the changelog is read-only"))
+		return
+	}
+
+	ctx, readEntry, err := SetReadEntryContext(ctx, m)
+	if err != nil {
+		responseAddError(w, err)
+		return
+	}
+
+	if !s.RequiredAuthz(ctx, m, AddOps, dn) {
 		// TODO return errror message
 		// ldap_add: Insufficient access (50)
 		// additional info: no write access to parent
@@ -36,6 +53,20 @@ func handleAdd(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 
 	log.Printf("debug: Start adding DN: %v", dn)
 
+	// Keep the clear-text passwords for the password policy before hashing
+	var newPasswords []string
+	for _, attr := range r.Attributes() {
+		if isPasswordAttribute(s, string(attr.Type_())) {
+			for _, v := range attr.Vals() {
+				newPasswords = append(newPasswords, string(v))
+			}
+		}
+	}
+	if err := validatePrehashedPasswords(s, getAuthSession(m), newPasswords); err != nil {
+		responseAddError(w, err)
+		return
+	}
+
 	addEntry, err := mapper.LDAPMessageToAddEntry(dn, r.Attributes())
 	if err != nil {
 		log.Printf("error: ")
@@ -43,6 +74,41 @@ func handleAdd(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 		return
 	}
 
+	// Check write access to the new entry and its attributes
+	_, attrsOrig := addEntry.Attrs()
+	attrs := []string{ACLAttrEntry}
+	for k := range attrsOrig {
+		attrs = append(attrs, k)
+	}
+	if !s.RequiredWriteAuthz(ctx, m, dn, NewSearchEntry(s.schemaMap, dn.DNOrigStr(), attrsOrig), attrs) {
+		responseAddError(w, NewInsufficientAccess())
+		return
+	}
+
+	if err := validatePPolicySubentry(ctx, s, addEntry.attributes); err != nil {
+		responseAddError(w, err)
+		return
+	}
+
+	if err := validateACLEntry(s, dn, addEntry.attributes); err != nil {
+		responseAddError(w, err)
+		return
+	}
+
+	if addEntry.HasAttr("userPassword") {
+		ppolicy, err := findPPolicy(ctx, s, addEntry.attributes)
+		if err != nil {
+			responseAddError(w, xerrors.Errorf("Failed to find the password policy. err: %w", err))
+			return
+		}
+		if ppolicy != nil {
+			if err := applyPasswordPolicy(s, ppolicy, addEntry.attributes, nil, newPasswords, false, time.Now()); err != nil {
+				responseAddError(w, err)
+				return
+			}
+		}
+	}
+
 	log.Printf("info: Adding entry: %s", r.Entry())
 
 	i := 0
@@ -55,6 +121,7 @@ Retry:
 			if i < maxRetry {
 				i++
 				log.Printf("warn: Detect consistency error. Do retry. try_count: %d", i)
+				observeRetry("add")
 				goto Retry
 			}
 			log.Printf("error: Give up to retry. try_count: %d", i)
@@ -66,8 +133,10 @@ Retry:
 
 	log.Printf("debug: Added. Id: %d, DN: %v", id, dn)
 
+	s.reloadACLIfChanged(ctx, dn)
+
 	res := ldap.NewAddResponse(ldap.LDAPResultSuccess)
-	w.Write(res)
+	withReadEntryControls(ctx, s, w, m, readEntry).Write(res)
 
 	log.Printf("debug: End Adding entry: %s", r.Entry())
 }
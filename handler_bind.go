@@ -6,20 +6,21 @@ import (
 	"strings"
 	"time"
 
-	"github.com/jsimonetti/pwscheme/ssha"
-	"github.com/jsimonetti/pwscheme/ssha256"
-	"github.com/jsimonetti/pwscheme/ssha512"
+	"github.com/openstandia/goldap/message"
 	ldap "github.com/openstandia/ldapserver"
 	"golang.org/x/xerrors"
 )
 
 func handleBind(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
-	ctx := context.Background()
+	ctx := context.WithValue(context.Background(), clientIPContextKey, clientIP(m))
 
 	r := m.GetBindRequest()
 	res := ldap.NewBindResponse(ldap.LDAPResultSuccess)
 
 	if r.AuthenticationChoice() == "simple" {
+		// Simple bind aborts the SASL bind in progress
+		clearSASLBindState(m)
+
 		name := string(r.Name())
 		input := string(r.AuthenticationSimple())
 
@@ -32,6 +33,14 @@ func handleBind(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 			return
 		}
 
+		// Simple bind with the password requires TLS if configured
+		if !dn.IsAnonymous() && s.RequiredConfidentiality(m) {
+			res.SetResultCode(ldap.LDAPResultConfidentialityRequired)
+			res.SetDiagnosticMessage("TLS confidentiality required")
+			w.Write(res)
+			return
+		}
+
 		// For rootdn
 		if dn.Equal(s.GetRootDN()) {
 			// TODO implement password policy for root user
@@ -60,34 +69,7 @@ func handleBind(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 
 		log.Printf("info: Find bind user. DN: %s", dn.DNNormStr())
 
-		err = s.Repo().Bind(ctx, dn, func(current *FetchedCredential) error {
-			// If the user doesn't have credentials, always return 'invalid credential'.
-			if len(current.Credential) == 0 {
-				log.Printf("info: Bind failed - Not found credentials. dn_norm: %s, err: %s", dn.DNNormStr(), err)
-				return NewInvalidCredentials()
-			}
-
-			if isLocked(current) {
-				log.Printf("info: Bind failed - Account locked. dn_norm: %s", dn.DNNormStr())
-				return NewAccountLocked()
-			}
-
-			bindOK := validateCreds(s, input, current)
-
-			if !bindOK {
-				if current.PPolicy.ShouldLockout(current.PwdFailureCount) {
-					log.Printf("info: Bind failed - Invalid credentials then locking now. dn_norm: %s", dn.DNNormStr())
-					return NewAccountLocking()
-				}
-
-				log.Printf("info: Bind failed - Invalid credentials. dn_norm: %s", dn.DNNormStr())
-				return NewInvalidCredentials()
-			}
-
-			saveAuthencatedDN(m, dn, current.MemberOf)
-
-			return nil
-		})
+		result, err := bindByPassword(ctx, s, dn, input)
 
 		// Bind failure
 		if err != nil {
@@ -96,10 +78,13 @@ func handleBind(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 				if !lerr.IsInvalidCredentials() {
 					log.Printf("error: Bind failed - LDAP error. dn_norm: %s, err: %+v", dn.DNNormStr(), err)
 				}
+				if lerr.IsAccountLocking() {
+					metricAccountLockouts.Inc()
+				}
 
 				res.SetResultCode(lerr.Code)
 				res.SetDiagnosticMessage(lerr.Msg)
-				w.Write(res)
+				writeBindResponse(w, m, res, -1, -1, lerr.PPolicyError())
 				return
 			} else {
 				log.Printf("error: Bind failed - System error. dn_norm: %s, err: %+v", dn.DNNormStr(), err)
@@ -111,10 +96,16 @@ func handleBind(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 			return
 		}
 
+		saveAuthencatedDN(m, dn, result.Groups, result.MustChangePassword)
+
 		// Bind success
-		log.Printf("info: Bind ok. dn_norm: %s", dn.DNNormStr())
+		log.Printf("info: Bind ok. dn_norm: %s, must_change_password: %v", dn.DNNormStr(), result.MustChangePassword)
 
-		w.Write(res)
+		writeBindResult(w, m, res, result)
+		return
+
+	} else if r.AuthenticationChoice() == "sasl" {
+		handleSASLBind(s, w, m, r)
 		return
 
 	} else {
@@ -125,6 +116,140 @@ func handleBind(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 	w.Write(res)
 }
 
+// writeBindResponse writes the bind response with the password policy response control
+// if the client requested it. The negative value means the warning or the error is omitted.
+func writeBindResponse(w ldap.ResponseWriter, m *ldap.Message, res message.BindResponse, timeBeforeExpiration int64, graceAuthNsRemaining, ppolicyError int) {
+	if !hasControl(m.Controls(), PPolicyControlOID) {
+		w.Write(res)
+		return
+	}
+
+	control, err := newPPolicyResponseControl(timeBeforeExpiration, graceAuthNsRemaining, ppolicyError)
+	if err != nil {
+		log.Printf("error: Failed to create ppolicy response control. err: %+v", err)
+		w.Write(res)
+		return
+	}
+	w.WriteControls(res, &message.Controls{control})
+}
+
+// writeBindResult writes the successful bind response with the password policy state of the result.
+// It's shared by the simple bind and the SASL bind.
+func writeBindResult(w ldap.ResponseWriter, m *ldap.Message, res message.BindResponse, result *BindResult) {
+	timeBeforeExpiration := int64(-1)
+	if result.TimeBeforeExpiration > 0 {
+		timeBeforeExpiration = result.TimeBeforeExpiration
+	}
+	graceAuthNsRemaining := -1
+	if result.PasswordExpired {
+		graceAuthNsRemaining = result.GraceAuthNsRemaining
+	}
+	ppolicyError := -1
+	if result.MustChangePassword {
+		ppolicyError = PPolicyErrorChangeAfterReset
+	}
+	writeBindResponse(w, m, res, timeBeforeExpiration, graceAuthNsRemaining, ppolicyError)
+}
+
+// BindResult is the result of the successful bind with the password policy state.
+type BindResult struct {
+	Groups []*DN
+	// MustChangePassword is true if the password was reset and it must be changed before other operations
+	MustChangePassword bool
+	// TimeBeforeExpiration is the seconds before the password expires, or 0 if no warning
+	TimeBeforeExpiration int64
+	// PasswordExpired is true if the bind succeeded by the grace authentication
+	PasswordExpired bool
+	// GraceAuthNsRemaining is the remaining grace authentications after this bind
+	GraceAuthNsRemaining int
+}
+
+// bindByPassword verifies the password of the entry.
+// It returns the groups of the entry and the password policy state if the password is valid.
+func bindByPassword(ctx context.Context, s *Server, dn *DN, input string) (*BindResult, error) {
+	return bindWithVerifier(ctx, s, dn, func(current *FetchedCredential) bool {
+		i := findMatchedCred(s, input, current)
+		if i == -1 {
+			return false
+		}
+
+		// Re-hash the password if it uses a weaker scheme or weaker parameters than configured.
+		// It's stored in the same transaction of Repository.Bind after the bind succeeded
+		if needsPasswordRehash(s.config, current.Credential[i]) {
+			hashed, err := hashPassword(s.config, input)
+			if err != nil {
+				log.Printf("error: Failed to rehash the password. dn_norm: %s, err: %+v", dn.DNNormStr(), err)
+				return true
+			}
+			rehashed := append([]string{}, current.Credential...)
+			rehashed[i] = hashed
+			current.RehashedCredential = rehashed
+		}
+		return true
+	})
+}
+
+// bindWithVerifier verifies the credential of the entry through Repository.Bind
+// which records the bind result with the password policy (e.g. account lockout).
+func bindWithVerifier(ctx context.Context, s *Server, dn *DN, verify func(current *FetchedCredential) bool) (*BindResult, error) {
+	result := &BindResult{}
+
+	if !s.CanAuth(ctx, dn) {
+		log.Printf("info: Bind failed - No auth access. dn_norm: %s", dn.DNNormStr())
+		return nil, NewInvalidCredentials()
+	}
+
+	err := s.Repo().Bind(ctx, dn, func(current *FetchedCredential) error {
+		// If the user doesn't have credentials, always return 'invalid credential'.
+		if len(current.Credential) == 0 {
+			log.Printf("info: Bind failed - Not found credentials. dn_norm: %s", dn.DNNormStr())
+			return NewInvalidCredentials()
+		}
+
+		if isLocked(current) {
+			log.Printf("info: Bind failed - Account locked. dn_norm: %s", dn.DNNormStr())
+			return NewAccountLocked()
+		}
+
+		bindOK := verify(current)
+
+		if !bindOK {
+			if current.PPolicy.ShouldLockout(current.PwdFailureCount) {
+				log.Printf("info: Bind failed - Invalid credentials then locking now. dn_norm: %s", dn.DNNormStr())
+				return NewAccountLocking()
+			}
+
+			log.Printf("info: Bind failed - Invalid credentials. dn_norm: %s", dn.DNNormStr())
+			return NewInvalidCredentials()
+		}
+
+		now := time.Now()
+
+		if current.PPolicy.IsPasswordExpired(current.PwdChangedTime, now) {
+			remaining := current.PPolicy.GraceAuthNLimit() - current.PwdGraceUseCount
+			if remaining <= 0 {
+				log.Printf("info: Bind failed - Password expired. dn_norm: %s", dn.DNNormStr())
+				return NewPasswordExpired()
+			}
+			log.Printf("info: Bind with grace authentication. dn_norm: %s, remaining: %d", dn.DNNormStr(), remaining-1)
+			result.PasswordExpired = true
+			result.GraceAuthNsRemaining = remaining - 1
+		} else {
+			result.TimeBeforeExpiration = current.PPolicy.TimeBeforeExpiration(current.PwdChangedTime, now)
+		}
+
+		result.Groups = current.MemberOf
+		result.MustChangePassword = current.PwdReset && current.PPolicy.MustChange()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // isLocked checks the account is locked if the lock is enabled in the password policy
 func isLocked(cred *FetchedCredential) bool {
 	if cred.PPolicy.IsLockoutEnabled() {
@@ -147,29 +272,24 @@ func isLocked(cred *FetchedCredential) bool {
 }
 
 func validateCreds(s *Server, input string, cred *FetchedCredential) bool {
-	for _, v := range cred.Credential {
+	return findMatchedCred(s, input, cred) != -1
+}
+
+// findMatchedCred returns the index of the credential which matches the input, or -1.
+func findMatchedCred(s *Server, input string, cred *FetchedCredential) int {
+	for i, v := range cred.Credential {
 		if ok := validateCred(s, input, v); ok {
-			return true
+			return i
 		}
 	}
-	return false
+	return -1
 }
 
 func validateCred(s *Server, input, cred string) bool {
 	var ok bool
 	var err error
-	if len(cred) > 7 && string(cred[0:6]) == "{SSHA}" {
-		ok, err = ssha.Validate(input, cred)
-
-	} else if len(cred) > 10 && string(cred[0:9]) == "{SSHA256}" {
-		ok, err = ssha256.Validate(input, cred)
-
-	} else if len(cred) > 10 && string(cred[0:9]) == "{SSHA512}" {
-		ok, err = ssha512.Validate(input, cred)
-	} else if len(cred) > 9 && string(cred[0:8]) == "{ARGON2}" {
-		ok, err = comparePasswordAndHash(input, cred)
-	} else if len(cred) > 7 && string(cred[0:6]) == "{SASL}" {
-		ok, err = doPassThrough(s, input, cred[6:])
+	if verifier, value, found := findPasswordVerifier(cred); found {
+		ok, err = verifier(s, input, value)
 	} else {
 		// Plain
 		ok = input == cred
@@ -224,11 +344,13 @@ func saveAuthencatedDNAsRoot(m *ldap.Message, dn *DN) {
 		log.Printf("info: Switching authenticated user: %s -> %s", session.DN.DNNormStr(), dn.DNNormStr())
 	}
 	session.DN = dn
+	session.Groups = nil
 	session.IsRoot = true
+	session.MustChangePassword = false
 	log.Printf("Saved authenticated DN: %s", dn.DNNormStr())
 }
 
-func saveAuthencatedDN(m *ldap.Message, dn *DN, groups []*DN) {
+func saveAuthencatedDN(m *ldap.Message, dn *DN, groups []*DN, mustChangePassword bool) {
 	session := getAuthSession(m)
 	if session.DN != nil {
 		log.Printf("info: Switching authenticated user: %s -> %s", session.DN.DNNormStr(), dn.DNNormStr())
@@ -236,5 +358,6 @@ func saveAuthencatedDN(m *ldap.Message, dn *DN, groups []*DN) {
 	session.DN = dn
 	session.Groups = groups
 	session.IsRoot = false
+	session.MustChangePassword = mustChangePassword
 	log.Printf("Saved authenticated DN: %s", dn.DNNormStr())
 }
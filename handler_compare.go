@@ -1,9 +1,12 @@
 package ldap_pg
 
 import (
+	"context"
 	"log"
+	"strings"
 
 	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
 )
 
 // The resultCode is set to compareTrue, compareFalse, or an appropriate
@@ -14,14 +17,139 @@ import (
 // subtype did not match.  Other result codes indicate either that the
 // result of the comparison was Undefined, or that
 // some error occurred.
-func handleCompare(w ldap.ResponseWriter, m *ldap.Message) {
+func handleCompare(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
+	ctx := SetSessionContext(context.Background(), m)
+
 	r := m.GetCompareRequest()
-	log.Printf("[INFO] Comparing entry: %s", r.Entry())
-	//attributes values
-	log.Printf(" attribute name to compare : \"%s\"", r.Ava().AttributeDesc())
-	log.Printf(" attribute value expected : \"%s\"", r.Ava().AssertionValue())
+	attrDesc := string(r.Ava().AttributeDesc())
+	assertion := string(r.Ava().AssertionValue())
+
+	log.Printf("info: Comparing entry: %s, attr: %s", r.Entry(), attrDesc)
+
+	dn, err := s.NormalizeDN(string(r.Entry()))
+	if err != nil {
+		log.Printf("warn: Invalid dn: %s err: %s", r.Entry(), err)
+
+		responseCompareError(w, NewInvalidDNSyntax())
+		return
+	}
+
+	if dn.DNNormStr() == "" {
+		responseCompareError(w, NewNoSuchObject())
+		return
+	}
+
+	name, _, err := ParseLanguageTag(attrDesc)
+	if err != nil {
+		responseCompareError(w, NewUndefinedType(attrDesc))
+		return
+	}
+	at, ok := s.schemaMap.AttributeType(name)
+	if !ok {
+		responseCompareError(w, NewUndefinedType(attrDesc))
+		return
+	}
+
+	if s.AccessLevel(ctx, m, dn, nil, at.Name) < AccessCompare {
+		log.Printf("info: Not comparable attribute. dn: %s, attr: %s", dn.DNNormStr(), at.Name)
+
+		if s.AccessLevel(ctx, m, dn, nil, ACLAttrEntry) == AccessNone {
+			// Same as search, hide the existence of the entry
+			responseCompareError(w, NewNoSuchObject())
+			return
+		}
+		responseCompareError(w, NewInsufficientAccess())
+		return
+	}
+
+	if at.Equality == "" && at.Name != "objectClass" {
+		responseCompareError(w, NewInappropriateMatching(at.Name))
+		return
+	}
+
+	option := &SearchOption{
+		RequestedAssocation:        []string{},
+		IsMemberOfRequested:        at.IsReverseAssociationAttribute(),
+		IsHasSubordinatesRequested: strings.EqualFold(at.Name, "hasSubordinates"),
+	}
+	if at.IsAssociationAttribute() {
+		option.RequestedAssocation = []string{at.Name}
+	}
+
+	entry, err := s.Repo().FindByDN(ctx, dn, option)
+	if err != nil {
+		responseCompareError(w, err)
+		return
+	}
+
+	_, values, ok := entry.GetAttrOrig(attrDesc)
+	if !ok || len(values) == 0 {
+		responseCompareError(w, NewNoSuchAttribute("compare", attrDesc))
+		return
+	}
+
+	assertionNorm, err := normalizeAssertionValue(s.schemaMap, at, attrDesc, assertion)
+	if err != nil {
+		responseCompareError(w, err)
+		return
+	}
+
+	for _, v := range values {
+		sv, err := NewSchemaValue(s.schemaMap, attrDesc, []string{v})
+		if err != nil {
+			log.Printf("warn: Ignore the value which can't be normalized when comparing. dn: %s, attr: %s, err: %v", dn.DNNormStr(), attrDesc, err)
+			continue
+		}
+		for _, nv := range sv.NormStr() {
+			if nv == assertionNorm {
+				log.Printf("info: Compared. dn: %s, attr: %s, result: true", dn.DNNormStr(), attrDesc)
+
+				w.Write(ldap.NewCompareResponse(ldap.LDAPResultCompareTrue))
+				return
+			}
+		}
+	}
+
+	log.Printf("info: Compared. dn: %s, attr: %s, result: false", dn.DNNormStr(), attrDesc)
+
+	w.Write(ldap.NewCompareResponse(ldap.LDAPResultCompareFalse))
+}
+
+// normalizeAssertionValue normalizes the assertion value using the attribute's EQUALITY rule.
+func normalizeAssertionValue(schemaMap *SchemaMap, at *AttributeType, attrDesc, value string) (string, error) {
+	// objectClass value is expanded with the superior objectClasses by the normalization.
+	// The assertion value must be compared as is.
+	if at.Name == "objectClass" {
+		oc, ok := schemaMap.ObjectClass(value)
+		if !ok {
+			return strings.ToLower(value), nil
+		}
+		return strings.ToLower(oc.Name), nil
+	}
+
+	sv, err := NewSchemaValue(schemaMap, attrDesc, []string{value})
+	if err != nil {
+		return "", err
+	}
+	return sv.NormStr()[0], nil
+}
 
-	res := ldap.NewCompareResponse(ldap.LDAPResultCompareTrue)
+func responseCompareError(w ldap.ResponseWriter, err error) {
+	var ldapErr *LDAPError
+	if ok := xerrors.As(err, &ldapErr); ok {
+		if !ldapErr.IsNoSuchObjectError() {
+			log.Printf("warn: Compare LDAP error. err: %+v", err)
+		}
 
-	w.Write(res)
+		res := ldap.NewCompareResponse(ldapErr.Code)
+		if ldapErr.Msg != "" {
+			res.SetDiagnosticMessage(ldapErr.Msg)
+		}
+		w.Write(res)
+	} else {
+		log.Printf("error: Compare error. err: %+v", err)
+		// TODO
+		res := ldap.NewCompareResponse(ldap.LDAPResultProtocolError)
+		w.Write(res)
+	}
 }
@@ -22,23 +22,62 @@ func handleDelete(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 		return
 	}
 
-	if !s.RequiredAuthz(m, DeleteOps, dn) {
+	if s.RequiredConfidentiality(m) {
+		responseDeleteError(w, NewConfidentialityRequired())
+		return
+	}
+
+	if s.isChangelogDN(dn) {
+		responseDeleteError(w, NewUnwillingToPerform("the changelog is read-only"))
+		return
+	}
+
+	ctx, err = SetAssertionContext(ctx, m)
+	if err != nil {
+		responseDeleteError(w, err)
+		return
+	}
+
+	ctx, readEntry, err := SetReadEntryContext(ctx, m)
+	if err != nil {
+		responseDeleteError(w, err)
+		return
+	}
+
+	if !s.RequiredAuthz(ctx, m, DeleteOps, dn) {
 		responseDeleteError(w, NewInsufficientAccess())
 		return
 	}
 
-	log.Printf("info: Deleting entry: %s", dn.DNNormStr())
+	// The tree delete control deletes the entry with all its descendants
+	treeDelete := hasControl(m.Controls(), TreeDeleteControlOID)
+
+	log.Printf("info: Deleting entry: %s, tree: %v", dn.DNNormStr(), treeDelete)
+
+	deletedDNs := []*DN{dn}
 
 	i := 0
 Retry:
 
-	err = s.Repo().DeleteByDN(ctx, dn)
+	if treeDelete {
+		err = s.Repo().DeleteTreeByDN(ctx, dn, func(dns []*DN, entries []*SearchEntry) error {
+			// Each entry of the subtree requires the same access as deleting it
+			if !s.RequiredSubtreeDeleteAuthz(ctx, m, dns, entries) {
+				return NewInsufficientAccess()
+			}
+			deletedDNs = dns
+			return nil
+		})
+	} else {
+		err = s.Repo().DeleteByDN(ctx, dn)
+	}
 	if err != nil {
 		var retryError *RetryError
 		if ok := xerrors.As(err, &retryError); ok {
 			if i < maxRetry {
 				i++
 				log.Printf("warn: Detect consistency error. Do retry. try_count: %d", i)
+				observeRetry("delete")
 				goto Retry
 			}
 			log.Printf("error: Give up to retry. try_count: %d", i)
@@ -52,8 +91,10 @@ Retry:
 
 	log.Printf("info: Deleted. dn: %s", dn.DNNormStr())
 
+	s.reloadACLIfChanged(ctx, deletedDNs...)
+
 	res := ldap.NewDeleteResponse(ldap.LDAPResultSuccess)
-	w.Write(res)
+	withReadEntryControls(ctx, s, w, m, readEntry).Write(res)
 }
 
 func responseDeleteError(w ldap.ResponseWriter, err error) {
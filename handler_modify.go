@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"time"
 
 	ldap "github.com/openstandia/ldapserver"
 	"golang.org/x/xerrors"
@@ -24,17 +25,79 @@ func handleModify(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 		return
 	}
 
-	if !s.RequiredAuthz(m, ModifyOps, dn) {
+	if s.RequiredConfidentiality(m) {
+		responseModifyError(w, NewConfidentialityRequired())
+		return
+	}
+
+	if s.isChangelogDN(dn) {
+		responseModifyError(w, NewUnwillingToPerform("the changelog is read-only"))
+		return
+	}
+
+	ctx, err = SetAssertionContext(ctx, m)
+	if err != nil {
+		responseModifyError(w, err)
+		return
+	}
+
+	ctx, readEntry, err := SetReadEntryContext(ctx, m)
+	if err != nil {
+		responseModifyError(w, err)
+		return
+	}
+
+	session := getAuthSession(m)
+	isSelfChange := !session.IsRoot && session.DN != nil && dn.Equal(session.DN)
+
+	changesPassword := false
+	changesPPolicy := false
+	changesOthers := false
+	var newPasswords []string
+	var changedAttrs []string
+	for _, change := range r.Changes() {
+		attrName := string(change.Modification().Type_())
+		changedAttrs = append(changedAttrs, attrName)
+		if isPasswordAttribute(s, attrName) {
+			changesPassword = true
+			// Keep the clear-text passwords for the password policy before hashing
+			if change.Operation() != ldap.ModifyRequestChangeOperationDelete {
+				for _, v := range change.Modification().Vals() {
+					newPasswords = append(newPasswords, string(v))
+				}
+			}
+		} else {
+			changesOthers = true
+			if at, ok := s.schemaMap.AttributeType(attrName); ok && at.Name == "pwdPolicySubentry" {
+				changesPPolicy = true
+			}
+		}
+	}
+
+	// The user who must change the reset password is allowed to modify own password only
+	if session.MustChangePassword && isSelfChange && changesPassword && !changesOthers {
+		log.Printf("info: Allow to change the reset password. dn_norm: %s", dn.DNNormStr())
+	} else if !s.RequiredWriteAuthz(ctx, m, dn, nil, changedAttrs) {
 		responseModifyError(w, NewInsufficientAccess())
 		return
 	}
 
+	if err := validatePrehashedPasswords(s, session, newPasswords); err != nil {
+		responseModifyError(w, err)
+		return
+	}
+
 	log.Printf("info: Modify entry: %s", dn.DNNormStr())
 
 	i := 0
 Retry:
 
 	err = s.Repo().Update(ctx, dn, func(newEntry *ModifyEntry) error {
+		var oldPasswords []string
+		if sv, ok := newEntry.attributes["userPassword"]; ok {
+			oldPasswords = append(oldPasswords, sv.Orig()...)
+		}
+
 		for _, change := range r.Changes() {
 			modification := change.Modification()
 			attrName := string(modification.Type_())
@@ -74,6 +137,28 @@ Retry:
 			return err
 		}
 
+		if changesPPolicy {
+			if err := validatePPolicySubentry(ctx, s, newEntry.attributes); err != nil {
+				return err
+			}
+		}
+
+		if err := validateACLEntry(s, dn, newEntry.attributes); err != nil {
+			return err
+		}
+
+		if changesPassword {
+			ppolicy, err := findPPolicy(ctx, s, newEntry.attributes)
+			if err != nil {
+				return xerrors.Errorf("Failed to find the password policy. err: %w", err)
+			}
+			if ppolicy != nil {
+				if err := applyPasswordPolicy(s, ppolicy, newEntry.attributes, oldPasswords, newPasswords, isSelfChange, time.Now()); err != nil {
+					return err
+				}
+			}
+		}
+
 		return nil
 	})
 
@@ -83,6 +168,7 @@ Retry:
 			if i < maxRetry {
 				i++
 				log.Printf("warn: Detect consistency error. Do retry. try_count: %d", i)
+				observeRetry("modify")
 				goto Retry
 			}
 			log.Printf("error: Give up to retry. try_count: %d", i)
@@ -96,8 +182,14 @@ Retry:
 		return
 	}
 
+	if isSelfChange && changesPassword {
+		session.MustChangePassword = false
+	}
+
+	s.reloadACLIfChanged(ctx, dn)
+
 	res := ldap.NewModifyResponse(ldap.LDAPResultSuccess)
-	w.Write(res)
+	withReadEntryControls(ctx, s, w, m, readEntry).Write(res)
 }
 
 func responseModifyError(w ldap.ResponseWriter, err error) {
@@ -22,7 +22,29 @@ func handleModifyDN(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 		return
 	}
 
-	if !s.RequiredAuthz(m, ModRDNOps, dn) {
+	if s.RequiredConfidentiality(m) {
+		responseModifyDNError(w, NewConfidentialityRequired())
+		return
+	}
+
+	if s.isChangelogDN(dn) {
+		responseModifyDNError(w, NewUnwillingToPerform("the changelog is read-only"))
+		return
+	}
+
+	ctx, err = SetAssertionContext(ctx, m)
+	if err != nil {
+		responseModifyDNError(w, err)
+		return
+	}
+
+	ctx, readEntry, err := SetReadEntryContext(ctx, m)
+	if err != nil {
+		responseModifyDNError(w, err)
+		return
+	}
+
+	if !s.RequiredAuthz(ctx, m, ModRDNOps, dn) {
 		responseModifyDNError(w, NewInsufficientAccess())
 		return
 	}
@@ -53,6 +75,17 @@ func handleModifyDN(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 			responseModifyDNError(w, NewInvalidDNSyntax())
 			return
 		}
+
+		// Moving the entry requires write access to the new parent too
+		if !s.RequiredAuthz(ctx, m, AddOps, newDN) {
+			responseModifyDNError(w, NewInsufficientAccess())
+			return
+		}
+	}
+
+	if s.isChangelogDN(newDN) {
+		responseModifyDNError(w, NewUnwillingToPerform("the changelog is read-only"))
+		return
 	}
 
 	i := 0
@@ -65,6 +98,7 @@ Retry:
 			if i < maxRetry {
 				i++
 				log.Printf("warn: Detect consistency error. Do retry. try_count: %d", i)
+				observeRetry("modrdn")
 				goto Retry
 			}
 			log.Printf("error: Give up to retry. try_count: %d", i)
@@ -76,8 +110,10 @@ Retry:
 		return
 	}
 
+	s.reloadACLIfChanged(ctx, dn, newDN)
+
 	res := ldap.NewModifyDNResponse(ldap.LDAPResultSuccess)
-	w.Write(res)
+	withReadEntryControls(ctx, s, w, m, readEntry).Write(res)
 }
 
 func responseModifyDNError(w ldap.ResponseWriter, err error) {
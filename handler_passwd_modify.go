@@ -0,0 +1,251 @@
+package ldap_pg
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
+	ber "gopkg.in/asn1-ber.v1"
+)
+
+const (
+	PasswordModifyOID = "1.3.6.1.4.1.4203.1.11.1"
+)
+
+// PasswordModifyRequest is the password modify extended request.
+// https://tools.ietf.org/html/rfc3062
+//
+//	PasswdModifyRequestValue ::= SEQUENCE {
+//	  userIdentity    [0]  OCTET STRING OPTIONAL
+//	  oldPasswd       [1]  OCTET STRING OPTIONAL
+//	  newPasswd       [2]  OCTET STRING OPTIONAL }
+type PasswordModifyRequest struct {
+	UserIdentity string
+	OldPasswd    *string
+	NewPasswd    *string
+}
+
+func parsePasswordModifyRequest(value *message.OCTETSTRING) (*PasswordModifyRequest, error) {
+	req := &PasswordModifyRequest{}
+
+	// The request value is optional
+	if value == nil || len(*value) == 0 {
+		return req, nil
+	}
+
+	packet, err := ber.DecodePacketErr([]byte(*value))
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to decode the password modify request. err: %w", err)
+	}
+
+	for _, v := range packet.Children {
+		s := v.Data.String()
+		switch v.Tag {
+		case 0:
+			req.UserIdentity = s
+		case 1:
+			req.OldPasswd = &s
+		case 2:
+			req.NewPasswd = &s
+		default:
+			return nil, xerrors.Errorf("Invalid password modify request. Unexpected tag: %d", v.Tag)
+		}
+	}
+
+	return req, nil
+}
+
+// newPasswordModifyResponseValue returns the response value which has the generated password.
+//
+//	PasswdModifyResponseValue ::= SEQUENCE {
+//	  genPasswd       [0]     OCTET STRING OPTIONAL }
+func newPasswordModifyResponseValue(genPasswd string) *ber.Packet {
+	value := ber.NewSequence("PasswdModifyResponseValue")
+	value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, genPasswd, "genPasswd"))
+	return value
+}
+
+func handlePasswordModify(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
+	ctx := SetSessionContext(context.Background(), m)
+
+	r := m.GetExtendedRequest()
+
+	req, err := parsePasswordModifyRequest(r.RequestValue())
+	if err != nil {
+		log.Printf("warn: Invalid password modify request. err: %v", err)
+
+		responseExtendedError(w, NewProtocolError("invalid password modify request"))
+		return
+	}
+
+	if s.RequiredConfidentiality(m) {
+		responseExtendedError(w, NewConfidentialityRequired())
+		return
+	}
+
+	session := getAuthSession(m)
+	if session.DN == nil {
+		responseExtendedError(w, NewUnwillingToPerform("authentication required"))
+		return
+	}
+
+	dn, err := resolvePasswordModifyTarget(ctx, s, session, req.UserIdentity)
+	if err != nil {
+		responseExtendedError(w, err)
+		return
+	}
+
+	if dn.Equal(s.GetRootDN()) {
+		responseExtendedError(w, NewUnwillingToPerform("unable to modify the password of the root DN"))
+		return
+	}
+
+	isSelfChange := !session.IsRoot && dn.Equal(session.DN)
+
+	// Users can change their own password even if it was reset, otherwise write rights are required
+	if !(session.MustChangePassword && isSelfChange) && !s.RequiredAttrsAuthz(ctx, m, dn, nil, []string{"userPassword"}, AccessWrite) {
+		responseExtendedError(w, NewInsufficientAccess())
+		return
+	}
+
+	var genPasswd string
+	newPasswd := req.NewPasswd
+	if newPasswd == nil || *newPasswd == "" {
+		genPasswd, err = generatePassword()
+		if err != nil {
+			responseExtendedError(w, xerrors.Errorf("Failed to generate the password. err: %w", err))
+			return
+		}
+		newPasswd = &genPasswd
+	}
+
+	hashed, err := hashPassword(s.config, *newPasswd)
+	if err != nil {
+		responseExtendedError(w, xerrors.Errorf("Failed to hash the password. err: %w", err))
+		return
+	}
+
+	log.Printf("info: Modify password: %s", dn.DNNormStr())
+
+	i := 0
+Retry:
+
+	err = s.Repo().Update(ctx, dn, func(newEntry *ModifyEntry) error {
+		var current []string
+		if sv, ok := newEntry.attributes["userPassword"]; ok {
+			current = append(current, sv.Orig()...)
+		}
+
+		if req.OldPasswd != nil {
+			if !validateCreds(s, *req.OldPasswd, &FetchedCredential{Credential: current}) {
+				log.Printf("info: Password modify failed - Invalid old password. dn_norm: %s", dn.DNNormStr())
+				return NewUnwillingToPerform("unwilling to verify old password")
+			}
+		}
+
+		if err := newEntry.Replace("userPassword", []string{hashed}); err != nil {
+			return err
+		}
+
+		ppolicy, err := findPPolicy(ctx, s, newEntry.attributes)
+		if err != nil {
+			return xerrors.Errorf("Failed to find the password policy. err: %w", err)
+		}
+		if ppolicy != nil {
+			// Check with the plain password since the stored one is hashed
+			if err := applyPasswordPolicy(s, ppolicy, newEntry.attributes, current, []string{*newPasswd}, isSelfChange, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		var retryError *RetryError
+		if ok := xerrors.As(err, &retryError); ok {
+			if i < maxRetry {
+				i++
+				log.Printf("warn: Detect consistency error. Do retry. try_count: %d", i)
+				observeRetry("passwd_modify")
+				goto Retry
+			}
+			log.Printf("error: Give up to retry. try_count: %d", i)
+		}
+
+		if err == sql.ErrNoRows {
+			responseExtendedError(w, NewNoSuchObject())
+			return
+		}
+		responseExtendedError(w, xerrors.Errorf("Failed to modify the password. dn: %s, err: %w", dn.DNNormStr(), err))
+		return
+	}
+
+	log.Printf("info: Modified password: %s", dn.DNNormStr())
+
+	if isSelfChange {
+		session.MustChangePassword = false
+	}
+
+	if genPasswd == "" {
+		w.Write(ldap.NewExtendedResponse(ldap.LDAPResultSuccess))
+		return
+	}
+
+	res, err := newExtendedResponse(ldap.LDAPResultSuccess, "", "", newPasswordModifyResponseValue(genPasswd).Bytes())
+	if err != nil {
+		responseExtendedError(w, err)
+		return
+	}
+	w.Write(res)
+}
+
+// resolvePasswordModifyTarget returns the DN whose password is modified.
+// The userIdentity is the DN or the authzId ("dn:<DN>" or "u:<user name>").
+func resolvePasswordModifyTarget(ctx context.Context, s *Server, session *AuthSession, userIdentity string) (*DN, error) {
+	if userIdentity == "" {
+		return session.DN, nil
+	}
+
+	if strings.HasPrefix(userIdentity, "u:") {
+		dn, _, err := s.saslIdentityMapping.Resolve(ctx, s, strings.TrimPrefix(userIdentity, "u:"))
+		if err != nil {
+			var lerr *LDAPError
+			if ok := xerrors.As(err, &lerr); ok && lerr.IsInvalidCredentials() {
+				return nil, NewNoSuchObject()
+			}
+			return nil, err
+		}
+		return dn, nil
+	}
+
+	dn, err := s.NormalizeDN(strings.TrimPrefix(userIdentity, "dn:"))
+	if err != nil || dn.IsAnonymous() {
+		log.Printf("warn: Invalid userIdentity: %s, err: %v", userIdentity, err)
+		return nil, NewInvalidDNSyntax()
+	}
+	return dn, nil
+}
+
+func responseExtendedError(w ldap.ResponseWriter, err error) {
+	var ldapErr *LDAPError
+	if ok := xerrors.As(err, &ldapErr); ok {
+		log.Printf("warn: Extended operation LDAP error. err: %+v", err)
+
+		res := ldap.NewExtendedResponse(ldapErr.Code)
+		if ldapErr.Msg != "" {
+			res.SetDiagnosticMessage(ldapErr.Msg)
+		}
+		w.Write(res)
+	} else {
+		log.Printf("error: Extended operation error. err: %+v", err)
+
+		res := ldap.NewExtendedResponse(ldap.LDAPResultOperationsError)
+		w.Write(res)
+	}
+}
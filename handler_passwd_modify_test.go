@@ -0,0 +1,88 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openstandia/goldap/message"
+	ber "gopkg.in/asn1-ber.v1"
+)
+
+func TestParsePasswordModifyRequest(t *testing.T) {
+	value := ber.NewSequence("PasswdModifyRequestValue")
+	value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, "uid=user1,dc=example,dc=com", "userIdentity"))
+	value.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 2, "newpassword", "newPasswd"))
+
+	v := message.OCTETSTRING(value.Bytes())
+	req, err := parsePasswordModifyRequest(&v)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if req.UserIdentity != "uid=user1,dc=example,dc=com" {
+		t.Errorf("Unexpected userIdentity: %s", req.UserIdentity)
+	}
+	if req.OldPasswd != nil {
+		t.Errorf("Unexpected oldPasswd: %s", *req.OldPasswd)
+	}
+	if req.NewPasswd == nil || *req.NewPasswd != "newpassword" {
+		t.Errorf("Unexpected newPasswd: %v", req.NewPasswd)
+	}
+
+	// No request value
+	req, err = parsePasswordModifyRequest(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if req.UserIdentity != "" || req.OldPasswd != nil || req.NewPasswd != nil {
+		t.Errorf("Unexpected request: %v", req)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	for _, scheme := range []string{"", "SSHA512", "argon2", "BCRYPT", "PBKDF2", "SCRAM-SHA-256"} {
+		hashed, err := hashPassword(&ServerConfig{PasswordHash: scheme}, "secret")
+		if err != nil {
+			t.Fatalf("Unexpected error on %s: %+v", scheme, err)
+		}
+		if !validateCred(nil, "secret", hashed) {
+			t.Errorf("Expected valid password on %s: %s", scheme, hashed)
+		}
+		if validateCred(nil, "invalid", hashed) {
+			t.Errorf("Expected invalid password on %s: %s", scheme, hashed)
+		}
+	}
+
+	if _, err := hashPassword(&ServerConfig{PasswordHash: "MD5"}, "secret"); err == nil {
+		t.Errorf("Expected error for unsupported scheme")
+	}
+}
+
+func TestHashClearTextPasswords(t *testing.T) {
+	// The clear-text password is stored as it is with default
+	values, err := hashClearTextPasswords(&ServerConfig{}, []string{"secret"})
+	if err != nil || len(values) != 1 || values[0] != "secret" {
+		t.Errorf("Unexpected values: %v, err: %+v", values, err)
+	}
+
+	config := &ServerConfig{
+		PasswordHash:                 "ARGON2",
+		PasswordHashArgon2Memory:     1024,
+		PasswordHashArgon2Iterations: 1,
+	}
+	values, err = hashClearTextPasswords(config, []string{"secret", "{SSHA}prehashed", "{x}secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if !strings.HasPrefix(values[0], "{ARGON2}$argon2id$v=19$m=1024,t=1,p=2$") || !validateCred(nil, "secret", values[0]) {
+		t.Errorf("Unexpected hashed password: %s", values[0])
+	}
+	if values[1] != "{SSHA}prehashed" {
+		t.Errorf("Unexpected pre-hashed password: %s", values[1])
+	}
+	// The unknown scheme isn't pre-hashed
+	if !strings.HasPrefix(values[2], "{ARGON2}") || !validateCred(nil, "{x}secret", values[2]) {
+		t.Errorf("Unexpected hashed password: %s", values[2])
+	}
+}
@@ -1,6 +1,7 @@
 package ldap_pg
 
 import (
+	"context"
 	"log"
 
 	"github.com/openstandia/goldap/message"
@@ -23,7 +24,7 @@ func handleSearchDSE(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 	// e.AddAttribute("objectClass", "top")
 	// e.AddAttribute("namingContexts", "ou=system", "ou=schema", "dc=example,dc=com", "ou=config")
 
-	searchEntry := NewSearchEntry(s.schemaMap, "", map[string][]string{
+	attrs := map[string][]string{
 		"objectClass":          {"top"},
 		"subschemaSubentry":    {"cn=Subschema"},
 		"namingContexts":       {s.GetSuffix()},
@@ -33,8 +34,41 @@ func handleSearchDSE(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 		},
 		"supportedControl": {
 			"1.2.840.113556.1.4.319",
+			SortRequestControlOID,
+			VLVRequestControlOID,
+			PPolicyControlOID,
+			ProxiedAuthzControlOID,
+			AssertionControlOID,
+			PreReadControlOID,
+			PostReadControlOID,
+			TreeDeleteControlOID,
 		},
-	})
+		"supportedExtension": {
+			PasswordModifyOID,
+			string(ldap.NoticeOfWhoAmI),
+		},
+	}
+
+	if s.tlsConfig != nil {
+		attrs["supportedExtension"] = append(attrs["supportedExtension"], string(ldap.NoticeOfStartTLS))
+	}
+
+	if mechanisms := availableSASLMechanisms(s); len(mechanisms) > 0 {
+		attrs["supportedSASLMechanisms"] = mechanisms
+	}
+
+	if s.changelogDN != nil {
+		attrs["supportedControl"] = append(attrs["supportedControl"], SyncRequestControlOID)
+	}
+	if s.psearch != nil {
+		attrs["supportedControl"] = append(attrs["supportedControl"], PersistentSearchControlOID, EntryChangeNotificationControlOID)
+	}
+
+	for k, v := range changelogRootDSEAttrs(context.Background(), s) {
+		attrs[k] = v
+	}
+
+	searchEntry := NewSearchEntry(s.schemaMap, "", attrs)
 
 	sentAttrs := map[string]struct{}{}
 
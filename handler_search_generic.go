@@ -7,6 +7,7 @@ import (
 	ldap "github.com/openstandia/ldapserver"
 	"golang.org/x/xerrors"
 	"log"
+	"strings"
 )
 
 func handleSearch(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
@@ -15,6 +16,10 @@ func handleSearch(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 	r := m.GetSearchRequest()
 
 	var pageControl *message.SimplePagedResultsControl
+	var sortControl *SortRequestControl
+	var vlvControl *VLVRequestControl
+	var syncControl *SyncRequestControl
+	var psearchControl *PersistentSearchControl
 
 	if m.Controls() != nil {
 		for _, con := range *m.Controls() {
@@ -22,6 +27,54 @@ func handleSearch(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 			if pc, ok := con.PagedResultsControl(); ok {
 				pageControl = pc
 			}
+			if con.ControlType() == SortRequestControlOID {
+				sc, err := parseSortRequestControl(&con)
+				if err != nil {
+					log.Printf("warn: Invalid sort request control. err: %v", err)
+
+					res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultProtocolError)
+					res.SetDiagnosticMessage("invalid sort request control")
+					w.Write(res)
+					return
+				}
+				sortControl = sc
+			}
+			if con.ControlType() == VLVRequestControlOID {
+				vc, err := parseVLVRequestControl(&con)
+				if err != nil {
+					log.Printf("warn: Invalid vlv request control. err: %v", err)
+
+					res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultProtocolError)
+					res.SetDiagnosticMessage("invalid vlv request control")
+					w.Write(res)
+					return
+				}
+				vlvControl = vc
+			}
+			if con.ControlType() == SyncRequestControlOID {
+				sc, err := parseSyncRequestControl(&con)
+				if err != nil {
+					log.Printf("warn: Invalid sync request control. err: %v", err)
+
+					res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultProtocolError)
+					res.SetDiagnosticMessage("invalid sync request control")
+					w.Write(res)
+					return
+				}
+				syncControl = sc
+			}
+			if con.ControlType() == PersistentSearchControlOID {
+				pc, err := parsePersistentSearchControl(&con)
+				if err != nil {
+					log.Printf("warn: Invalid persistent search control. err: %v", err)
+
+					res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultProtocolError)
+					res.SetDiagnosticMessage("invalid persistent search control")
+					w.Write(res)
+					return
+				}
+				psearchControl = pc
+			}
 		}
 
 		if pageControl != nil {
@@ -72,14 +125,82 @@ func handleSearch(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 		return
 	}
 
-	// Phase 2: authorization
-	if !s.RequiredAuthz(m, SearchOps, baseDN) {
-		// Return 32 No such object
-		responseSearchError(w, NewNoSuchObject())
+	if s.isChangelogDN(baseDN) {
+		handleSearchChangelog(ctx, s, w, m, r, baseDN)
 		return
 	}
 
-	// Phase 4: execute SQL and return entries
+	if syncControl != nil {
+		// The sync cookie requires the changelog
+		if s.changelogDN != nil {
+			handleSyncSearch(ctx, s, w, m, r, baseDN, syncControl)
+			return
+		}
+		if syncControl.Criticality {
+			res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultUnavailableCriticalExtension)
+			res.SetDiagnosticMessage("the changelog is disabled")
+			w.Write(res)
+			return
+		}
+	}
+
+	if psearchControl != nil {
+		if s.psearch != nil {
+			handlePersistentSearch(ctx, s, w, m, r, baseDN, psearchControl)
+			return
+		}
+		if psearchControl.Criticality {
+			res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultUnavailableCriticalExtension)
+			res.SetDiagnosticMessage("the persistent search is disabled")
+			w.Write(res)
+			return
+		}
+	}
+
+	// Phase 2: resolve sort keys and virtual list view
+	// The access to the entries are checked when returning them
+	var controls message.Controls
+
+	var sortKeys []*SortKey
+	var sortResult int
+	var sortErrorAttr string
+	if sortControl != nil {
+		sortKeys, sortResult, sortErrorAttr = resolveSortKeys(ctx, s, m, baseDN, sortControl)
+		controls = appendSortResponseControl(controls, sortResult, sortErrorAttr)
+
+		if sortResult != ldap.LDAPResultSuccess {
+			log.Printf("info: Unsortable. result: %d, attr: %s, critical: %v", sortResult, sortErrorAttr, sortControl.Criticality)
+
+			if sortControl.Criticality {
+				// The server can't perform the search with the critical sort control
+				res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultUnavailableCriticalExtension)
+				writeSearchResultDone(w, res, controls)
+				return
+			}
+			// Return the results without sorting
+			sortKeys = nil
+		}
+	}
+
+	var vlv *VLVOption
+	if vlvControl != nil {
+		var vlvResult int
+		vlv, vlvResult = resolveVLVOption(s, vlvControl, sortKeys, pageControl)
+
+		if vlvResult != ldap.LDAPResultSuccess {
+			log.Printf("info: Can't process vlv. result: %d, critical: %v", vlvResult, vlvControl.Criticality)
+
+			controls = appendVLVResponseControl(controls, 0, 0, vlvResult, vlvControl.ContextID)
+
+			if vlvControl.Criticality {
+				res := ldap.NewSearchResultDoneResponse(vlvResult)
+				writeSearchResultDone(w, res, controls)
+				return
+			}
+		}
+	}
+
+	// Phase 3: execute SQL and return entries
 	// TODO configurable default pageSize
 	var pageSize int32 = 500
 	if pageControl != nil {
@@ -109,6 +230,7 @@ func handleSearch(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 			}
 		}
 	}
+
 	option := &SearchOption{
 		Scope:                      scope,
 		Filter:                     r.Filter(),
@@ -117,62 +239,244 @@ func handleSearch(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
 		RequestedAssocation:        getRequestedMemberAttrs(r),
 		IsMemberOfRequested:        isMemberOfRequested(r),
 		IsHasSubordinatesRequested: isHasSubOrdinatesRequested(r),
+		SortKeys:                   sortKeys,
+		VLV:                        vlv,
 	}
 
 	maxCount, limittedCount, err := s.Repo().Search(ctx, baseDN, option, func(searchEntry *SearchEntry) error {
-		responseEntry(s, w, m, r, searchEntry)
+		responseEntry(ctx, s, w, m, r, searchEntry)
 		return nil
 	})
 	if err != nil {
+		if sortControl != nil {
+			// The sort response control is returned with the failed search too.
+			// The sortResult is limited to the codes of RFC 2891, the error is returned only as the result code
+			responseSearchErrorWithControls(w, err, appendSortResponseControl(nil, sortResult, sortErrorAttr))
+			return
+		}
 		responseSearchError(w, err)
 		return
 	}
 
+	if vlv != nil {
+		controls = appendVLVResponseControl(controls, vlv.ResultTargetPosition, vlv.ResultContentCount, ldap.LDAPResultSuccess, vlvControl.ContextID)
+	}
+
 	if maxCount == 0 {
 		log.Printf("debug: Not found")
 
 		// Must return success if no hit
 		res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess)
-		w.Write(res)
+		writeSearchResultDone(w, res, controls)
 		return
 	}
 
-	var nextCookie string
-
-	if limittedCount+offset < maxCount {
-		uuid, _ := uuid.NewRandom()
-		nextCookie = uuid.String()
+	if pageControl != nil {
+		var nextCookie string
 
-		sessionMap := getPageSession(m)
-		sessionMap[nextCookie] = offset + pageSize
-	}
+		if limittedCount+offset < maxCount {
+			uuid, _ := uuid.NewRandom()
+			nextCookie = uuid.String()
 
-	res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess)
+			sessionMap := getPageSession(m)
+			sessionMap[nextCookie] = offset + pageSize
+		}
 
-	if pageControl != nil {
 		// https://www.ietf.org/rfc/rfc2696.txt
 		control := message.NewSimplePagedResultsControl(maxCount, false, nextCookie)
-		var controls message.Controls = []message.Control{control}
+		controls = append(controls, control)
+	}
+
+	res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess)
+	writeSearchResultDone(w, res, controls)
+}
 
+func writeSearchResultDone(w ldap.ResponseWriter, res message.SearchResultDone, controls message.Controls) {
+	if len(controls) > 0 {
 		w.WriteControls(res, &controls)
 	} else {
 		w.Write(res)
 	}
 }
 
-func responseEntry(s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, searchEntry *SearchEntry) {
+// resolveSortKeys resolves the requested sort keys using the schema.
+// It returns the result code and the attribute type for the sort response control
+// when the server can't sort by the requested key.
+func resolveSortKeys(ctx context.Context, s *Server, m *ldap.Message, baseDN *DN, sortControl *SortRequestControl) ([]*SortKey, int, string) {
+	keys := make([]*SortKey, len(sortControl.Keys))
+
+	for i, k := range sortControl.Keys {
+		at, ok := s.schemaMap.AttributeType(k.AttributeType)
+		if !ok {
+			return nil, ldap.LDAPResultNoSuchAttribute, k.AttributeType
+		}
+
+		if s.AccessLevel(ctx, m, baseDN, nil, at.Name) < AccessRead {
+			return nil, ldap.LDAPResultInsufficientAccessRights, k.AttributeType
+		}
+
+		// Association attributes aren't stored in attrs_norm
+		if at.IsAssociationAttribute() || at.IsReverseAssociationAttribute() {
+			return nil, ldap.LDAPResultInappropriateMatching, k.AttributeType
+		}
+
+		ordering := sortOrderingRule(at)
+		if ordering == "" {
+			return nil, ldap.LDAPResultInappropriateMatching, k.AttributeType
+		}
+		if k.OrderingRule != "" && !strings.EqualFold(k.OrderingRule, ordering) {
+			return nil, ldap.LDAPResultInappropriateMatching, k.AttributeType
+		}
+
+		keys[i] = &SortKey{
+			AttributeType: at,
+			ReverseOrder:  k.ReverseOrder,
+		}
+	}
+
+	return keys, ldap.LDAPResultSuccess, ""
+}
+
+// sortOrderingRule returns the ORDERING rule to sort by the attribute, empty if it can't be sorted.
+// The string attribute without ORDERING rule (e.g. cn and sn inherit name) is sorted by the value
+// normalized by the EQUALITY rule like OpenLDAP.
+func sortOrderingRule(at *AttributeType) string {
+	if at.Ordering != "" {
+		return at.Ordering
+	}
+	switch at.Equality {
+	case "caseIgnoreMatch":
+		return "caseIgnoreOrderingMatch"
+	case "caseExactMatch":
+		return "caseExactOrderingMatch"
+	default:
+		return ""
+	}
+}
+
+func appendSortResponseControl(controls message.Controls, sortResult int, attr string) message.Controls {
+	sc, err := newSortResponseControl(sortResult, attr)
+	if err != nil {
+		log.Printf("error: Failed to create sort response control. err: %+v", err)
+		return controls
+	}
+	return append(controls, sc)
+}
+
+// defaultVLVMaxWindowSize is the max number of the entries in the window of the virtual list view if it isn't configured.
+const defaultVLVMaxWindowSize = 500
+
+// resolveVLVOption resolves the requested virtual list view with the sort keys.
+// It returns the result code for the vlv response control when the server can't process it.
+func resolveVLVOption(s *Server, vlvControl *VLVRequestControl, sortKeys []*SortKey, pageControl *message.SimplePagedResultsControl) (*VLVOption, int) {
+	// The server must not process the virtual list view without sort
+	if len(sortKeys) == 0 {
+		return nil, 60 // sortControlMissing
+	}
+	// The virtual list view and simple paged results can't be used together
+	if pageControl != nil {
+		return nil, ldap.LDAPResultUnwillingToPerform
+	}
+
+	vlv := &VLVOption{
+		BeforeCount:  vlvControl.BeforeCount,
+		AfterCount:   vlvControl.AfterCount,
+		Offset:       vlvControl.Offset,
+		ContentCount: vlvControl.ContentCount,
+	}
+
+	if vlvControl.GreaterThanOrEqual != nil {
+		sv, err := NewSchemaValue(s.schemaMap, sortKeys[0].AttributeType.Name, []string{*vlvControl.GreaterThanOrEqual})
+		if err != nil {
+			log.Printf("info: Invalid vlv assertion value. err: %v", err)
+			return nil, ldap.LDAPResultInvalidAttributeSyntax
+		}
+		vlv.AssertionValue = sv
+	} else if vlvControl.Offset < 1 {
+		return nil, 61 // offsetRangeError
+	}
+
+	maxWindowSize := s.config.VLVMaxWindowSize
+	if maxWindowSize <= 0 {
+		maxWindowSize = defaultVLVMaxWindowSize
+	}
+	if vlv.BeforeCount < 0 || vlv.AfterCount < 0 || vlv.BeforeCount+vlv.AfterCount+1 > int32(maxWindowSize) {
+		return nil, ldap.LDAPResultAdminLimitExceeded
+	}
+
+	return vlv, ldap.LDAPResultSuccess
+}
+
+func appendVLVResponseControl(controls message.Controls, targetPosition, contentCount int32, vlvResult int, contextID string) message.Controls {
+	vc, err := newVLVResponseControl(targetPosition, contentCount, vlvResult, contextID)
+	if err != nil {
+		log.Printf("error: Failed to create vlv response control. err: %+v", err)
+		return controls
+	}
+	return append(controls, vc)
+}
+
+func responseEntry(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, searchEntry *SearchEntry) {
+	responseEntryWithDN(ctx, s, w, m, r, resolveSuffix(s, searchEntry.DNOrig()), searchEntry)
+}
+
+// responseEntryWithDN returns the entry with the DN as is. It's used for the entries outside of the suffix.
+// It returns false if the entry isn't sent because of the access control.
+func responseEntryWithDN(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, dnOrig string, searchEntry *SearchEntry) bool {
 	log.Printf("Response Entry: %+v", searchEntry)
 
-	session := getAuthSession(m)
+	if !canSearchByFilter(ctx, s, m, r.Filter(), dnOrig, searchEntry) {
+		return false
+	}
+
+	e, ok := newSearchResultEntry(ctx, s, m, r.Attributes(), dnOrig, searchEntry)
+	if !ok {
+		return false
+	}
+
+	w.Write(e)
 
-	dnOrig := searchEntry.DNOrig()
-	e := ldap.NewSearchResultEntry(resolveSuffix(s, dnOrig))
+	log.Printf("Response an entry. dn: %s", dnOrig)
+
+	return true
+}
+
+// canSearchByFilter checks the requester has search access to all attributes in the filter of the entry.
+// The entry matched by the attribute without search access isn't returned not to disclose the value.
+func canSearchByFilter(ctx context.Context, s *Server, m *ldap.Message, filter message.Filter, dnOrig string, searchEntry *SearchEntry) bool {
+	dn, err := s.NormalizeDN(dnOrig)
+	if err != nil {
+		log.Printf("warn: Invalid DN of the search result, ignore. dn: %s, err: %v", dnOrig, err)
+		return false
+	}
+	return s.RequiredAttrsAuthz(ctx, m, dn, searchEntry, filterAttributes(filter), AccessSearch)
+}
+
+// newSearchResultEntry returns the entry which has the selected attributes readable by the requester.
+// It returns false if the entry itself isn't readable.
+func newSearchResultEntry(ctx context.Context, s *Server, m *ldap.Message, attrs message.AttributeSelection, dnOrig string, searchEntry *SearchEntry) (message.SearchResultEntry, bool) {
+	e := ldap.NewSearchResultEntry(dnOrig)
+
+	dn, err := s.NormalizeDN(dnOrig)
+	if err != nil {
+		log.Printf("warn: Invalid DN of the search result, ignore. dn: %s, err: %v", dnOrig, err)
+		return e, false
+	}
+
+	canRead := func(attr string) bool {
+		return s.AccessLevel(ctx, m, dn, searchEntry, attr) >= AccessRead
+	}
+
+	if !canRead(ACLAttrEntry) {
+		log.Printf("info: Not readable entry, ignore. dn: %s", dn.DNNormStr())
+		return e, false
+	}
 
 	sentAttrs := map[string]struct{}{}
 
-	if isAllAttributesRequested(r) {
+	if isAllAttributesSelected(attrs) {
 		for k, v := range searchEntry.GetAttrsOrigWithoutOperationalAttrs() {
-			if !s.simpleACL.CanVisible(session, k) {
+			if !canRead(k) {
 				log.Printf("- Ignore Attribute %s", k)
 				continue
 			}
@@ -189,14 +493,9 @@ func responseEntry(s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.
 		}
 	}
 
-	for _, attr := range r.Attributes() {
+	for _, attr := range attrs {
 		a := string(attr)
 
-		if !s.simpleACL.CanVisible(session, a) {
-			log.Printf("- Ignore Attribute %s", a)
-			continue
-		}
-
 		log.Printf("Requested attr: %s", a)
 
 		if a != "+" {
@@ -206,6 +505,11 @@ func responseEntry(s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.
 				continue
 			}
 
+			if !canRead(k) {
+				log.Printf("- Ignore Attribute %s", k)
+				continue
+			}
+
 			if _, ok := sentAttrs[k]; ok {
 				log.Printf("Already sent, ignore. attr: %s", a)
 				continue
@@ -221,9 +525,9 @@ func responseEntry(s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.
 		}
 	}
 
-	if isOperationalAttributesRequested(r) {
+	if isOperationalAttributesSelected(attrs) {
 		for k, v := range searchEntry.GetOperationalAttrsOrig() {
-			if !s.simpleACL.CanVisible(session, k) {
+			if !canRead(k) {
 				log.Printf("- Ignore Attribute %s", k)
 				continue
 			}
@@ -238,25 +542,33 @@ func responseEntry(s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.
 		}
 	}
 
-	w.Write(e)
-
-	log.Printf("Response an entry. dn: %s", dnOrig)
+	return e, true
 }
 
 func responseSearchError(w ldap.ResponseWriter, err error) {
+	responseSearchErrorWithControls(w, err, nil)
+}
+
+func responseSearchErrorWithControls(w ldap.ResponseWriter, err error, controls message.Controls) {
 	var ldapErr *LDAPError
 	if ok := xerrors.As(err, &ldapErr); ok {
 		if ldapErr.Code != ldap.LDAPResultSuccess && !ldapErr.IsNoSuchObjectError() {
 			log.Printf("warn: Search LDAP error. err: %+v", err)
 		}
-
-		res := ldap.NewSearchResultDoneResponse(ldapErr.Code)
-		w.Write(res)
 	} else {
 		log.Printf("error: Search error. err: %+v", err)
+	}
 
-		// TODO
-		res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultProtocolError)
-		w.Write(res)
+	res := ldap.NewSearchResultDoneResponse(searchErrorCode(err))
+	writeSearchResultDone(w, res, controls)
+}
+
+// searchErrorCode returns the result code of the search error.
+func searchErrorCode(err error) int {
+	var ldapErr *LDAPError
+	if ok := xerrors.As(err, &ldapErr); ok {
+		return ldapErr.Code
 	}
+	// TODO
+	return ldap.LDAPResultProtocolError
 }
@@ -0,0 +1,131 @@
+package ldap_pg
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/openstandia/goldap/message"
+	"golang.org/x/xerrors"
+	ber "gopkg.in/asn1-ber.v1"
+)
+
+// IdentityMapping maps the authentication identity (e.g. SASL authcid, certificate subject)
+// to the entry DN by searching the directory.
+type IdentityMapping struct {
+	BaseDN *DN
+	// Filter contains "%s" which is replaced with the escaped identity. (e.g. (uid=%s))
+	Filter string
+}
+
+func NewIdentityMapping(server *Server, baseDN, filter string) (*IdentityMapping, error) {
+	if !strings.Contains(filter, "%s") {
+		return nil, xerrors.Errorf(`Invalid filter. Need "%%s" placeholder: %s`, filter)
+	}
+	if _, err := compileFilter(fmt.Sprintf(filter, "test")); err != nil {
+		return nil, xerrors.Errorf("Invalid filter: %s, err: %w", filter, err)
+	}
+
+	dn := server.Suffix
+	if baseDN != "" {
+		var err error
+		dn, err = server.NormalizeDN(baseDN)
+		if err != nil {
+			return nil, xerrors.Errorf("Invalid base DN: %s, err: %w", baseDN, err)
+		}
+	}
+
+	return &IdentityMapping{
+		BaseDN: dn,
+		Filter: filter,
+	}, nil
+}
+
+// Resolve returns the DN and the groups of the entry which is mapped from the identity.
+// It returns invalid credentials error if no entry or multiple entries are found.
+func (i *IdentityMapping) Resolve(ctx context.Context, s *Server, identity string) (*DN, []*DN, error) {
+	filter, err := compileFilter(fmt.Sprintf(i.Filter, goldap.EscapeFilter(identity)))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("Failed to compile the identity mapping filter. err: %w", err)
+	}
+
+	option := &SearchOption{
+		Scope:               2,
+		Filter:              filter,
+		PageSize:            2,
+		RequestedAssocation: []string{},
+		IsMemberOfRequested: true,
+	}
+
+	var found *SearchEntry
+	_, count, err := s.Repo().Search(ctx, i.BaseDN, option, func(entry *SearchEntry) error {
+		found = entry
+		return nil
+	})
+	if err != nil {
+		return nil, nil, xerrors.Errorf("Failed to search the identity. err: %w", err)
+	}
+	if count != 1 {
+		log.Printf("info: Can't map the identity to one entry. identity: %s, count: %d", identity, count)
+		return nil, nil, NewInvalidCredentials()
+	}
+
+	return toAuthIdentity(s, found)
+}
+
+// resolveIdentityByDN returns the DN and the groups of the entry specified by the DN.
+func resolveIdentityByDN(ctx context.Context, s *Server, dn *DN) (*DN, []*DN, error) {
+	entry, err := s.Repo().FindByDN(ctx, dn, &SearchOption{
+		RequestedAssocation: []string{},
+		IsMemberOfRequested: true,
+	})
+	if err != nil {
+		var lerr *LDAPError
+		if ok := xerrors.As(err, &lerr); ok && lerr.IsNoSuchObjectError() {
+			log.Printf("info: Not found the identity entry. dn: %s", dn.DNNormStr())
+			return nil, nil, NewInvalidCredentials()
+		}
+		return nil, nil, err
+	}
+
+	return toAuthIdentity(s, entry)
+}
+
+func toAuthIdentity(s *Server, entry *SearchEntry) (*DN, []*DN, error) {
+	dn, err := s.NormalizeDN(resolveSuffix(s, entry.DNOrig()))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("Unexpected DN of the identity entry. dn: %s, err: %w", entry.DNOrig(), err)
+	}
+
+	var groups []*DN
+	if _, memberOf, ok := entry.GetAttrOrig("memberOf"); ok {
+		for _, v := range memberOf {
+			g, err := s.NormalizeDN(v)
+			if err != nil {
+				log.Printf("warn: Ignore invalid memberOf. dn: %s, memberOf: %s, err: %v", dn.DNNormStr(), v, err)
+				continue
+			}
+			groups = append(groups, g)
+		}
+	}
+
+	return dn, groups, nil
+}
+
+// compileFilter converts the string filter to goldap's filter.
+// goldap doesn't provide the parser of the string filter,
+// so we compile it by go-ldap then read it back using goldap.
+func compileFilter(filter string) (message.Filter, error) {
+	packet, err := goldap.CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ber.DecodePacketErr(packet.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return readDummyFilter(f)
+}
@@ -324,6 +324,350 @@ func TestBind(t *testing.T) {
 	runTestCases(t, tcs)
 }
 
+func TestPPolicySubentry(t *testing.T) {
+	type A []string
+	type M map[string][]string
+
+	// The ppolicy entry which has the escaped comma in the RDN
+	policy := M{
+		"objectClass":   A{"organizationalRole", "pwdPolicy"},
+		"cn":            A{"Smith, Policy"},
+		"pwdAttribute":  A{"userPassword"},
+		"pwdLockout":    A{"TRUE"},
+		"pwdMaxFailure": A{"1"},
+	}
+
+	defaultPPolicyDN, err := testServer.NormalizeDN("cn=default,ou=Policies," + testServer.GetSuffix())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	orig := testServer.defaultPPolicyDN
+	testServer.defaultPPolicyDN = defaultPPolicyDN
+	defer func() {
+		testServer.defaultPPolicyDN = orig
+	}()
+
+	tcs := []Command{
+		Conn{},
+		Bind{"cn=Manager", "secret", &AssertResponse{}},
+		AddDC("example", "dc=com"),
+		AddOU("Users"),
+		AddOU("Policies"),
+		Add{
+			"cn=Smith\\, Policy", "ou=Policies",
+			policy,
+			&AssertResponse{},
+		},
+		Add{
+			"cn=default", "ou=Policies",
+			M{
+				"objectClass":  A{"organizationalRole", "pwdPolicy"},
+				"cn":           A{"default"},
+				"pwdAttribute": A{"userPassword"},
+			},
+			&AssertResponse{},
+		},
+		// Per-entry ppolicy
+		Add{
+			"uid=user1", "ou=Users",
+			M{
+				"objectClass":       A{"inetOrgPerson"},
+				"cn":                A{"user1"},
+				"sn":                A{"user1"},
+				"userPassword":      A{"password1"},
+				"pwdPolicySubentry": A{"cn=Smith\\, Policy,ou=Policies," + testServer.GetSuffix()},
+			},
+			&AssertResponse{},
+		},
+		// The default ppolicy which doesn't lock out
+		Add{
+			"uid=user2", "ou=Users",
+			M{
+				"objectClass":  A{"inetOrgPerson"},
+				"cn":           A{"user2"},
+				"sn":           A{"user2"},
+				"userPassword": A{"password2"},
+			},
+			&AssertResponse{},
+		},
+		// Missing ppolicy entry
+		Add{
+			"uid=user3", "ou=Users",
+			M{
+				"objectClass":       A{"inetOrgPerson"},
+				"cn":                A{"user3"},
+				"sn":                A{"user3"},
+				"userPassword":      A{"password3"},
+				"pwdPolicySubentry": A{"cn=missing,ou=Policies," + testServer.GetSuffix()},
+			},
+			&AssertLDAPError{19},
+		},
+		ModifyReplace{
+			"uid=user2", "ou=Users",
+			M{
+				"pwdPolicySubentry": A{"cn=missing,ou=Policies," + testServer.GetSuffix()},
+			},
+			&AssertLDAPError{19},
+		},
+		Bind{
+			"uid=user1,ou=Users",
+			"invalid",
+			&AssertResponse{49},
+		},
+		// Locked by the per-entry ppolicy
+		Bind{
+			"uid=user1,ou=Users",
+			"password1",
+			&AssertResponse{49},
+		},
+		Conn{},
+		Bind{
+			"uid=user2,ou=Users",
+			"invalid",
+			&AssertResponse{49},
+		},
+		Bind{
+			"uid=user2,ou=Users",
+			"password2",
+			&AssertResponse{},
+		},
+	}
+
+	runTestCases(t, tcs)
+}
+
+func TestProxiedAuthz(t *testing.T) {
+	type A []string
+	type M map[string][]string
+
+	proxiedAuthz := func(authzID string) []ldap.Control {
+		return []ldap.Control{ldap.NewControlString(ProxiedAuthzControlOID, true, authzID)}
+	}
+
+	tcs := []Command{
+		Conn{},
+		Bind{"cn=Manager", "secret", &AssertResponse{}},
+		SetACL{`
+access to dn.subtree="ou=Users,dc=example,dc=com" attrs=proxy
+    by dn="uid=proxy,ou=Users,dc=example,dc=com" proxy
+    by * none
+access to *
+    by * read
+`},
+		AddDC("example", "dc=com"),
+		AddOU("Users"),
+		AddOU("Admins"),
+		Add{
+			"uid=proxy", "ou=Users",
+			M{
+				"objectClass":  A{"inetOrgPerson"},
+				"cn":           A{"proxy"},
+				"sn":           A{"proxy"},
+				"userPassword": A{SSHA("password")},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"uid=user1", "ou=Users",
+			M{
+				"objectClass":  A{"inetOrgPerson"},
+				"cn":           A{"user1"},
+				"sn":           A{"user1"},
+				"userPassword": A{SSHA("password1")},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"uid=admin", "ou=Admins",
+			M{
+				"objectClass": A{"inetOrgPerson"},
+				"cn":          A{"admin"},
+				"sn":          A{"admin"},
+			},
+			&AssertEntry{},
+		},
+		// Anonymous
+		Conn{},
+		WhoAmI{nil, "", nil},
+		// Bound
+		Bind{"uid=proxy,ou=Users", "password", &AssertResponse{}},
+		WhoAmI{nil, "dn:uid=proxy,ou=users,dc=example,dc=com", nil},
+		// Proxied by DN and user name
+		WhoAmI{proxiedAuthz("dn:uid=user1,ou=Users,dc=example,dc=com"), "dn:uid=user1,ou=users,dc=example,dc=com", nil},
+		WhoAmI{proxiedAuthz("u:user1"), "dn:uid=user1,ou=users,dc=example,dc=com", nil},
+		WhoAmI{proxiedAuthz("dn:"), "", nil},
+		// The proxied identity is released after the operation
+		WhoAmI{nil, "dn:uid=proxy,ou=users,dc=example,dc=com", nil},
+		// Non-critical control
+		WhoAmI{[]ldap.Control{ldap.NewControlString(ProxiedAuthzControlOID, false, "u:user1")}, "", &AssertResponse{2}},
+		// No proxy privilege for the identity, or the identity doesn't exist
+		WhoAmI{proxiedAuthz("dn:uid=admin,ou=Admins,dc=example,dc=com"), "", &AssertResponse{123}},
+		WhoAmI{proxiedAuthz("dn:uid=notfound,ou=Users,dc=example,dc=com"), "", &AssertResponse{123}},
+		WhoAmI{proxiedAuthz("u:notfound"), "", &AssertResponse{123}},
+		WhoAmI{proxiedAuthz("invalid"), "", &AssertResponse{123}},
+		WhoAmI{nil, "dn:uid=proxy,ou=users,dc=example,dc=com", nil},
+		// The requester without proxy privilege
+		Bind{"uid=user1,ou=Users", "password1", &AssertResponse{}},
+		WhoAmI{proxiedAuthz("dn:uid=proxy,ou=Users,dc=example,dc=com"), "", &AssertResponse{123}},
+		WhoAmI{nil, "dn:uid=user1,ou=users,dc=example,dc=com", nil},
+	}
+
+	runTestCases(t, tcs)
+}
+
+func TestTreeDelete(t *testing.T) {
+	type A []string
+	type M map[string][]string
+
+	tcs := []Command{
+		Conn{},
+		Bind{"cn=Manager", "secret", &AssertResponse{}},
+		AddDC("example", "dc=com"),
+		AddOU("Users"),
+		AddOU("Groups"),
+		AddOU("Tree"),
+		AddOU("Sub", "ou=Tree"),
+		Add{
+			"uid=admin", "ou=Users",
+			M{
+				"objectClass":  A{"inetOrgPerson"},
+				"cn":           A{"admin"},
+				"sn":           A{"admin"},
+				"userPassword": A{SSHA("password")},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"uid=user1", "ou=Sub,ou=Tree",
+			M{
+				"objectClass": A{"inetOrgPerson"},
+				"cn":          A{"user1"},
+				"sn":          A{"user1"},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"uid=user2", "ou=Tree",
+			M{
+				"objectClass": A{"inetOrgPerson"},
+				"cn":          A{"user2"},
+				"sn":          A{"user2"},
+				"description": A{"protected"},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"cn=group1", "ou=Groups",
+			M{
+				"objectClass": A{"groupOfNames"},
+				"member": A{
+					"uid=admin,ou=Users," + testServer.GetSuffix(),
+					"uid=user1,ou=Sub,ou=Tree," + testServer.GetSuffix(),
+				},
+			},
+			&AssertEntry{},
+		},
+		// The entry which has children can't be deleted without the tree delete control
+		Delete{"ou=Tree", "", &AssertLDAPError{66}},
+		// The entry in the middle of the subtree isn't deletable, nothing is deleted
+		SetACL{`
+access to dn.subtree="ou=Tree,dc=example,dc=com" filter=(description=protected)
+    by * read
+access to *
+    by dn="uid=admin,ou=Users,dc=example,dc=com" write
+    by * read
+`},
+		Bind{"uid=admin,ou=Users", "password", &AssertResponse{}},
+		DeleteTree{"ou=Tree", "", &AssertLDAPError{50}},
+		Search{
+			"ou=Tree," + testServer.GetSuffix(),
+			"objectClass=*",
+			ldap.ScopeWholeSubtree,
+			nil,
+			&AssertEntries{
+				ExpectEntry{"ou=Tree", "", nil},
+				ExpectEntry{"ou=Sub", "ou=Tree", nil},
+				ExpectEntry{"uid=user1", "ou=Sub,ou=Tree", nil},
+				ExpectEntry{"uid=user2", "ou=Tree", nil},
+			},
+		},
+		// The subtree is deleted and the group loses the member
+		Bind{"cn=Manager", "secret", &AssertResponse{}},
+		DeleteTree{"ou=Tree", "", &AssertNoEntry{}},
+		Search{
+			testServer.GetSuffix(),
+			"|(ou=Sub)(uid=user1)(uid=user2)",
+			ldap.ScopeWholeSubtree,
+			nil,
+			&AssertEntries{},
+		},
+		Search{
+			"cn=group1,ou=Groups," + testServer.GetSuffix(),
+			"objectClass=*",
+			ldap.ScopeBaseObject,
+			A{"member"},
+			&AssertEntries{
+				ExpectEntry{"cn=group1", "ou=Groups", M{
+					"member": A{"uid=admin,ou=Users," + testServer.GetSuffix()},
+				}},
+			},
+		},
+		AssertChangeRecord{"ou=Tree", "", "delete", ""},
+		AssertChangeRecord{"ou=Sub", "ou=Tree", "delete", ""},
+		AssertChangeRecord{"uid=user1", "ou=Sub,ou=Tree", "delete", ""},
+		AssertChangeRecord{"uid=user2", "ou=Tree", "delete", ""},
+		AssertChangeRecord{"cn=group1", "ou=Groups", "modify", "delete: member\nmember: uid=user1,ou=Sub,ou=Tree," + testServer.GetSuffix()},
+	}
+
+	runTestCases(t, tcs)
+}
+
+func TestSortedSearch(t *testing.T) {
+	type A []string
+	type M map[string][]string
+
+	tcs := []Command{
+		Conn{},
+		Bind{"cn=Manager", "secret", &AssertResponse{}},
+		AddDC("example", "dc=com"),
+		AddOU("Users"),
+		Add{
+			"uid=user1", "ou=Users",
+			M{
+				"objectClass": A{"inetOrgPerson"},
+				"cn":          A{"Charlie"},
+				"sn":          A{"user1"},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"uid=user2", "ou=Users",
+			M{
+				"objectClass": A{"inetOrgPerson"},
+				"cn":          A{"alice"},
+				"sn":          A{"user2"},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"uid=user3", "ou=Users",
+			M{
+				"objectClass": A{"inetOrgPerson"},
+				"cn":          A{"Bob"},
+				"sn":          A{"user3"},
+			},
+			&AssertEntry{},
+		},
+		// cn doesn't have ORDERING rule, it's sorted by the normalized value of caseIgnoreMatch
+		SortedSearch{"ou=Users", "uid=*", "cn", false, []string{"uid=user2,ou=Users", "uid=user3,ou=Users", "uid=user1,ou=Users"}, nil},
+		SortedSearch{"ou=Users", "uid=*", "cn", true, []string{"uid=user1,ou=Users", "uid=user3,ou=Users", "uid=user2,ou=Users"}, nil},
+		// userPassword can't be sorted, the critical sort control fails
+		SortedSearch{"ou=Users", "uid=*", "userPassword", false, nil, &AssertResponse{12}},
+	}
+
+	runTestCases(t, tcs)
+}
+
 func TestSearchSpecialCharacters(t *testing.T) {
 	type A []string
 	type M map[string][]string
@@ -862,6 +1206,102 @@ func TestBasicCRUD(t *testing.T) {
 	runTestCases(t, tcs)
 }
 
+func TestCompare(t *testing.T) {
+	type A []string
+	type M map[string][]string
+
+	tcs := []Command{
+		Conn{},
+		Bind{"cn=Manager", "secret", &AssertResponse{}},
+		AddDC("example", "dc=com"),
+		AddOU("Users"),
+		AddOU("Groups"),
+		Add{
+			"uid=user1", "ou=Users",
+			M{
+				"objectClass":  A{"inetOrgPerson"},
+				"cn":           A{"user1"},
+				"sn":           A{"User1"},
+				"userPassword": A{SSHA("password1")},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"cn=A1", "ou=Groups",
+			M{
+				"objectClass": A{"groupOfNames"},
+				"member": A{
+					"uid=user1,ou=Users," + testServer.GetSuffix(),
+				},
+			},
+			&AssertEntry{},
+		},
+		// caseIgnoreMatch
+		Compare{"uid=user1", "ou=Users", "sn", "user1", true, nil},
+		Compare{"uid=user1", "ou=Users", "sn", "  USER1 ", true, nil},
+		Compare{"uid=user1", "ou=Users", "sn", "user2", false, nil},
+		// objectClass
+		Compare{"uid=user1", "ou=Users", "objectClass", "InetOrgPerson", true, nil},
+		Compare{"uid=user1", "ou=Users", "objectClass", "groupOfNames", false, nil},
+		// association
+		Compare{"cn=A1", "ou=Groups", "member", "UID=user1,ou=users," + testServer.GetSuffix(), true, nil},
+		Compare{"cn=A1", "ou=Groups", "member", "uid=user2,ou=Users," + testServer.GetSuffix(), false, nil},
+		Compare{"uid=user1", "ou=Users", "memberOf", "cn=a1,ou=Groups," + testServer.GetSuffix(), true, nil},
+		// errors
+		Compare{"uid=user1", "ou=Users", "givenName", "foo", false, &AssertResponse{16}},
+		Compare{"uid=user1", "ou=Users", "foo", "bar", false, &AssertResponse{17}},
+		Compare{"uid=notfound", "ou=Users", "sn", "user1", false, &AssertResponse{32}},
+	}
+
+	runTestCases(t, tcs)
+}
+
+func TestPasswordModify(t *testing.T) {
+	type A []string
+	type M map[string][]string
+
+	tcs := []Command{
+		Conn{},
+		Bind{"cn=Manager", "secret", &AssertResponse{}},
+		AddDC("example", "dc=com"),
+		AddOU("Users"),
+		Add{
+			"uid=user1", "ou=Users",
+			M{
+				"objectClass":  A{"inetOrgPerson"},
+				"cn":           A{"user1"},
+				"sn":           A{"user1"},
+				"userPassword": A{SSHA("password1")},
+			},
+			&AssertEntry{},
+		},
+		Add{
+			"uid=user2", "ou=Users",
+			M{
+				"objectClass":  A{"inetOrgPerson"},
+				"cn":           A{"user2"},
+				"sn":           A{"user2"},
+				"userPassword": A{SSHA("password2")},
+			},
+			&AssertEntry{},
+		},
+		// By the manager
+		PasswordModify{"uid=user1,ou=Users", "", "newpassword1", nil},
+		Bind{"uid=user1,ou=Users", "newpassword1", &AssertResponse{}},
+		// By the user self
+		PasswordModify{"", "invalid", "newpassword2", &AssertResponse{53}},
+		PasswordModify{"", "newpassword1", "newpassword2", nil},
+		Bind{"uid=user1,ou=Users", "newpassword1", &AssertResponse{49}},
+		Bind{"uid=user1,ou=Users", "newpassword2", &AssertResponse{}},
+		// Generate password
+		PasswordModify{"", "newpassword2", "", nil},
+		// No write rights for other users
+		PasswordModify{"uid=user2,ou=Users", "", "newpassword3", &AssertResponse{50}},
+	}
+
+	runTestCases(t, tcs)
+}
+
 func TestModRDN(t *testing.T) {
 	type A []string
 	type M map[string][]string
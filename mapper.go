@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/openstandia/goldap/message"
+	"golang.org/x/xerrors"
 )
 
 type Mapper struct {
@@ -31,6 +32,14 @@ func (m *Mapper) LDAPMessageToAddEntry(dn *DN, ldapAttrs message.AttributeList)
 			arr[i] = string(v)
 		}
 
+		if isPasswordAttribute(m.server, attrName) {
+			hashed, err := hashClearTextPasswords(m.server.config, arr)
+			if err != nil {
+				return nil, xerrors.Errorf("Failed to hash the password. err: %w", err)
+			}
+			arr = hashed
+		}
+
 		err := entry.Add(attrName, arr)
 		if err != nil {
 			log.Printf("warn: Invalid attribute. attrName: %s, err: %s", k, err)
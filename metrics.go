@@ -0,0 +1,259 @@
+package ldap_pg
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "ldap_pg"
+
+var (
+	metricOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_total",
+		Help:      "The number of LDAP operations by the operation and the result code.",
+	}, []string{"operation", "result"})
+
+	metricOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "The latency of LDAP operations by the operation and the result code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+
+	metricBinds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "binds_total",
+		Help:      "The number of bind operations by the result (success or failure).",
+	}, []string{"result"})
+
+	metricAccountLockouts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "account_lockouts_total",
+		Help:      "The number of accounts locked by the password policy.",
+	})
+
+	metricSearchEntries = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "search_entries",
+		Help:      "The number of entries returned by a search operation.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	metricDBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "The latency of DB queries by the caller method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	metricRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "retries_total",
+		Help:      "The number of retries of LDAP operations caused by the consistency error.",
+	}, []string{"operation"})
+
+	metricConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "connections",
+		Help:      "The number of current client connections.",
+	})
+
+	metricConnectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "connections_total",
+		Help:      "The number of accepted client connections.",
+	})
+)
+
+// startMetricsServer serves /metrics on the pprof server and the metrics server if configured.
+func (s *Server) startMetricsServer() {
+	http.Handle("/metrics", promhttp.Handler())
+
+	if s.config.MetricsServer == "" || s.config.MetricsServer == s.config.PProfServer {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("info: Starting metrics server on %s", s.config.MetricsServer)
+
+	go func() {
+		log.Println(http.ListenAndServe(s.config.MetricsServer, mux))
+	}()
+}
+
+// registerDBStatsMetrics exposes the statistics of the DB connection pool.
+func registerDBStatsMetrics(db *sql.DB, dbName string) {
+	if err := prometheus.Register(collectors.NewDBStatsCollector(db, dbName)); err != nil {
+		log.Printf("warn: Failed to register DB stats metrics. err: %v", err)
+	}
+}
+
+// observeDBQuery records the latency of the DB query with the caller method of the DB utility.
+func observeDBQuery(start time.Time) {
+	method := "unknown"
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		method = shortFuncName(runtime.FuncForPC(pc).Name())
+	}
+	metricDBQueryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// shortFuncName trims the package path from the function name,
+// e.g. github.com/Mistat/ldap-pg.(*HybridRepository).Search => (*HybridRepository).Search
+func shortFuncName(name string) string {
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i != -1 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func observeRetry(operation string) {
+	metricRetries.WithLabelValues(operation).Inc()
+}
+
+// operationName returns the name of the LDAP operation for the metrics label.
+func operationName(m *ldap.Message) string {
+	switch m.ProtocolOpType() {
+	case ldap.ApplicationBindRequest:
+		return "bind"
+	case ldap.ApplicationSearchRequest:
+		return "search"
+	case ldap.ApplicationModifyRequest:
+		return "modify"
+	case ldap.ApplicationAddRequest:
+		return "add"
+	case ldap.ApplicationDelRequest:
+		return "delete"
+	case ldap.ApplicationModifyDNRequest:
+		return "modrdn"
+	case ldap.ApplicationCompareRequest:
+		return "compare"
+	case ldap.ApplicationExtendedRequest:
+		return "extended"
+	default:
+		return "unknown"
+	}
+}
+
+// resultCode returns the result code of the LDAP response.
+// The ok is false if the response doesn't have it, e.g. SearchResultEntry.
+func resultCode(po message.ProtocolOp) (int, bool) {
+	v := reflect.Indirect(reflect.ValueOf(po))
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+	// goldap doesn't expose the result code, read the unexported field of LDAPResult
+	f := v.FieldByName("resultCode")
+	if !f.IsValid() || f.Kind() != reflect.Int32 {
+		return 0, false
+	}
+	return int(f.Int()), true
+}
+
+// recordingResponseWriter captures the result code and the number of the search result entries
+// for the metrics and the access log.
+type recordingResponseWriter struct {
+	ldap.ResponseWriter
+	resultCode int
+	hasResult  bool
+	entries    int
+}
+
+func (w *recordingResponseWriter) Write(po message.ProtocolOp) {
+	w.capture(po)
+	w.ResponseWriter.Write(po)
+}
+
+func (w *recordingResponseWriter) WriteControls(po message.ProtocolOp, c *message.Controls) {
+	w.capture(po)
+	w.ResponseWriter.WriteControls(po, c)
+}
+
+func (w *recordingResponseWriter) capture(po message.ProtocolOp) {
+	if _, ok := po.(message.SearchResultEntry); ok {
+		w.entries++
+		return
+	}
+	if code, ok := resultCode(po); ok {
+		w.resultCode = code
+		w.hasResult = true
+	}
+}
+
+// observeOperation records the metrics of the LDAP operation.
+func observeOperation(m *ldap.Message, w *recordingResponseWriter, elapsed time.Duration) {
+	operation := operationName(m)
+	result := "unknown"
+	if w.hasResult {
+		result = strconv.Itoa(w.resultCode)
+	}
+
+	metricOperations.WithLabelValues(operation, result).Inc()
+	metricOperationDuration.WithLabelValues(operation, result).Observe(elapsed.Seconds())
+
+	switch operation {
+	case "bind":
+		// SASL bind in progress isn't the final result
+		if !w.hasResult || w.resultCode == ldap.LDAPResultSaslBindInProgress {
+			return
+		}
+		if w.resultCode == ldap.LDAPResultSuccess {
+			metricBinds.WithLabelValues("success").Inc()
+		} else {
+			metricBinds.WithLabelValues("failure").Inc()
+		}
+	case "search":
+		metricSearchEntries.Observe(float64(w.entries))
+	}
+}
+
+// metricsListener counts the client connections.
+type metricsListener struct {
+	net.Listener
+}
+
+func newMetricsListener(l net.Listener) net.Listener {
+	return &metricsListener{l}
+}
+
+func (l *metricsListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+	metricConnections.Inc()
+	metricConnectionsTotal.Inc()
+	return &metricsConn{Conn: c}, nil
+}
+
+type metricsConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *metricsConn) Close() error {
+	c.once.Do(func() {
+		metricConnections.Dec()
+	})
+	return c.Conn.Close()
+}
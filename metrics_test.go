@@ -0,0 +1,72 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"testing"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+)
+
+type nopResponseWriter struct{}
+
+func (w nopResponseWriter) Write(po message.ProtocolOp) {}
+
+func (w nopResponseWriter) WriteControls(po message.ProtocolOp, c *message.Controls) {}
+
+func TestResultCode(t *testing.T) {
+	testcases := []struct {
+		Response message.ProtocolOp
+		Code     int
+		OK       bool
+	}{
+		{ldap.NewBindResponse(ldap.LDAPResultInvalidCredentials), ldap.LDAPResultInvalidCredentials, true},
+		{ldap.NewAddResponse(ldap.LDAPResultSuccess), ldap.LDAPResultSuccess, true},
+		{ldap.NewModifyResponse(ldap.LDAPResultInsufficientAccessRights), ldap.LDAPResultInsufficientAccessRights, true},
+		{ldap.NewDeleteResponse(ldap.LDAPResultNoSuchObject), ldap.LDAPResultNoSuchObject, true},
+		{ldap.NewCompareResponse(ldap.LDAPResultCompareTrue), ldap.LDAPResultCompareTrue, true},
+		{ldap.NewExtendedResponse(ldap.LDAPResultUnwillingToPerform), ldap.LDAPResultUnwillingToPerform, true},
+		{ldap.NewSearchResultDoneResponse(ldap.LDAPResultSizeLimitExceeded), ldap.LDAPResultSizeLimitExceeded, true},
+		{ldap.NewSearchResultEntry("dc=example,dc=com"), 0, false},
+	}
+
+	for i, tc := range testcases {
+		code, ok := resultCode(tc.Response)
+		if ok != tc.OK || code != tc.Code {
+			t.Errorf("Unexpected result code on %d: expected %d (%v), got %d (%v)", i, tc.Code, tc.OK, code, ok)
+		}
+	}
+}
+
+func TestRecordingResponseWriter(t *testing.T) {
+	w := &recordingResponseWriter{ResponseWriter: nopResponseWriter{}}
+
+	w.Write(ldap.NewSearchResultEntry("ou=Users,dc=example,dc=com"))
+	w.Write(ldap.NewSearchResultEntry("uid=user1,ou=Users,dc=example,dc=com"))
+	w.WriteControls(ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess), &message.Controls{})
+
+	if w.entries != 2 {
+		t.Errorf("Unexpected entries: expected 2, got %d", w.entries)
+	}
+	if !w.hasResult || w.resultCode != ldap.LDAPResultSuccess {
+		t.Errorf("Unexpected result code: %d (%v)", w.resultCode, w.hasResult)
+	}
+}
+
+func TestShortFuncName(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Expected string
+	}{
+		{"github.com/Mistat/ldap-pg.(*HybridRepository).Search", "(*HybridRepository).Search"},
+		{"github.com/Mistat/ldap-pg.(*HybridRepository).Search.func1", "(*HybridRepository).Search.func1"},
+		{"main.main", "main"},
+	}
+
+	for i, tc := range testcases {
+		if name := shortFuncName(tc.Name); name != tc.Expected {
+			t.Errorf("Unexpected name on %d: expected %s, got %s", i, tc.Expected, name)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package ldap_pg
 
 import (
 	"log"
+
+	"golang.org/x/xerrors"
 )
 
 type ModifyEntry struct {
@@ -13,6 +15,16 @@ type ModifyEntry struct {
 	hasSub     bool
 	path       string
 	old        map[string]*SchemaValue
+	changes    []*ModifyChange
+}
+
+// ModifyChange is the applied modification, it's used for the audit log.
+// The values of the password attributes are masked not to record them.
+type ModifyChange struct {
+	// Op is add, replace or delete
+	Op     string
+	Attr   string
+	Values []string
 }
 
 func NewModifyEntry(schemaMap *SchemaMap, dn *DN, attrsOrig map[string][]string) (*ModifyEntry, error) {
@@ -95,13 +107,21 @@ func (j *ModifyEntry) ApplyCurrent(attrName string, attrValue []string) error {
 		return err
 	}
 
-	// Don't Record changelog
+	// Don't record the change for the audit log
 
 	return nil
 }
 
 // Append to current value(s).
 func (j *ModifyEntry) Add(attrName string, attrValue []string) error {
+	if isPasswordAttribute(j.schemaMap.server, attrName) {
+		hashed, err := hashClearTextPasswords(j.schemaMap.server.config, attrValue)
+		if err != nil {
+			return xerrors.Errorf("Failed to hash the password. err: %w", err)
+		}
+		attrValue = hashed
+	}
+
 	sv, err := NewSchemaValue(j.schemaMap, attrName, attrValue)
 	if err != nil {
 		return err
@@ -130,6 +150,7 @@ func (j *ModifyEntry) Add(attrName string, attrValue []string) error {
 	if err := j.addsv(sv); err != nil {
 		return err
 	}
+	j.changes = append(j.changes, &ModifyChange{Op: "add", Attr: attrName, Values: maskValues(attrName, sv.Orig())})
 
 	return nil
 }
@@ -148,6 +169,14 @@ func (j *ModifyEntry) addsv(value *SchemaValue) error {
 
 // Replace with the value(s).
 func (j *ModifyEntry) Replace(attrName string, attrValue []string) error {
+	if isPasswordAttribute(j.schemaMap.server, attrName) {
+		hashed, err := hashClearTextPasswords(j.schemaMap.server.config, attrValue)
+		if err != nil {
+			return xerrors.Errorf("Failed to hash the password. err: %w", err)
+		}
+		attrValue = hashed
+	}
+
 	sv, err := NewSchemaValue(j.schemaMap, attrName, attrValue)
 	if err != nil {
 		return err
@@ -202,6 +231,7 @@ func (j *ModifyEntry) Replace(attrName string, attrValue []string) error {
 	if err := j.replacesv(sv); err != nil {
 		return err
 	}
+	j.changes = append(j.changes, &ModifyChange{Op: "replace", Attr: attrName, Values: maskValues(attrName, sv.Orig())})
 
 	return nil
 }
@@ -273,6 +303,7 @@ func (j *ModifyEntry) Delete(attrName string, attrValue []string) error {
 	if err := j.deletesv(sv); err != nil {
 		return err
 	}
+	j.changes = append(j.changes, &ModifyChange{Op: "delete", Attr: attrName, Values: maskValues(attrName, sv.Orig())})
 
 	return nil
 }
@@ -0,0 +1,203 @@
+package ldap_pg
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/jsimonetti/pwscheme/md5crypt"
+)
+
+// The implementation of SHA-crypt (crypt(3) $5$ and $6$).
+// See https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	cryptItoa64           = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shaCryptSaltMaxLength = 16
+	shaCryptRoundsDefault = 5000
+	shaCryptRoundsMin     = 1000
+	shaCryptRoundsMax     = 999999999
+)
+
+type shaCryptAlgorithm struct {
+	newHash func() hash.Hash
+	// The byte order of the digest for encoding, 3 bytes are encoded into 4 characters
+	order [][]int
+}
+
+var shaCrypt256 = &shaCryptAlgorithm{
+	newHash: sha256.New,
+	order: [][]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+		{-1, 31, 30},
+	},
+}
+
+var shaCrypt512 = &shaCryptAlgorithm{
+	newHash: sha512.New,
+	order: [][]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41}, {-1, -1, 63},
+	},
+}
+
+// validateCrypt validates the password with the crypt(3) hash of {CRYPT} scheme.
+// MD5-crypt ($1$), SHA256-crypt ($5$) and SHA512-crypt ($6$) are supported.
+func validateCrypt(password, encodedHash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encodedHash, "$1$"):
+		ok, err := md5crypt.Validate(password, "{MD5-CRYPT}"+encodedHash)
+		if err == md5crypt.ErrNotMatching {
+			return false, nil
+		}
+		return ok, err
+	case strings.HasPrefix(encodedHash, "$5$"):
+		return shaCrypt256.validate(password, encodedHash)
+	case strings.HasPrefix(encodedHash, "$6$"):
+		return shaCrypt512.validate(password, encodedHash)
+	default:
+		return false, ErrInvalidHash
+	}
+}
+
+func (a *shaCryptAlgorithm) validate(password, encodedHash string) (bool, error) {
+	// e.g. $6$rounds=10000$salt$hash
+	vals := strings.Split(encodedHash, "$")
+	if len(vals) != 4 && len(vals) != 5 {
+		return false, ErrInvalidHash
+	}
+
+	prefix := "$" + vals[1] + "$"
+	rounds := shaCryptRoundsDefault
+	customRounds := false
+	if len(vals) == 5 {
+		if !strings.HasPrefix(vals[2], "rounds=") {
+			return false, ErrInvalidHash
+		}
+		r, err := strconv.Atoi(strings.TrimPrefix(vals[2], "rounds="))
+		if err != nil {
+			return false, ErrInvalidHash
+		}
+		rounds = r
+		customRounds = true
+	}
+	salt := vals[len(vals)-2]
+
+	other := a.crypt(prefix, []byte(password), []byte(salt), rounds, customRounds)
+
+	return subtle.ConstantTimeCompare([]byte(encodedHash), []byte(other)) == 1, nil
+}
+
+func (a *shaCryptAlgorithm) crypt(prefix string, password, salt []byte, rounds int, customRounds bool) string {
+	if len(salt) > shaCryptSaltMaxLength {
+		salt = salt[:shaCryptSaltMaxLength]
+	}
+	if rounds < shaCryptRoundsMin {
+		rounds = shaCryptRoundsMin
+	} else if rounds > shaCryptRoundsMax {
+		rounds = shaCryptRoundsMax
+	}
+
+	// Digest B
+	h := a.newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	// Digest A
+	h = a.newHash()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(repeatBytes(b, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	digest := h.Sum(nil)
+
+	// Byte sequence P
+	h = a.newHash()
+	for range password {
+		h.Write(password)
+	}
+	p := repeatBytes(h.Sum(nil), len(password))
+
+	// Byte sequence S
+	h = a.newHash()
+	for i := 0; i < 16+int(digest[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeatBytes(h.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		h = a.newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(digest)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(digest)
+		} else {
+			h.Write(p)
+		}
+		digest = h.Sum(nil)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	if customRounds {
+		sb.WriteString("rounds=")
+		sb.WriteString(strconv.Itoa(rounds))
+		sb.WriteString("$")
+	}
+	sb.Write(salt)
+	sb.WriteString("$")
+
+	for _, o := range a.order {
+		var w uint
+		n := 0
+		for _, idx := range o {
+			w <<= 8
+			if idx >= 0 {
+				w |= uint(digest[idx])
+				n++
+			}
+		}
+		for j := 0; j < n+1; j++ {
+			sb.WriteByte(cryptItoa64[w&0x3f])
+			w >>= 6
+		}
+	}
+
+	return sb.String()
+}
+
+func repeatBytes(src []byte, length int) []byte {
+	dst := make([]byte, 0, length)
+	for len(dst) < length {
+		n := length - len(dst)
+		if n > len(src) {
+			n = len(src)
+		}
+		dst = append(dst, src[:n]...)
+	}
+	return dst
+}
@@ -1,12 +1,20 @@
 package ldap_pg
 
 import (
+	"crypto/rand"
+	"crypto/sha512"
 	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"golang.org/x/crypto/argon2"
+	"strconv"
 	"strings"
+
+	"github.com/jsimonetti/pwscheme/ssha512"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/xerrors"
 )
 
 var (
@@ -76,4 +84,257 @@ func decodeHash(encodedHash string) (p *params, salt, hash []byte, err error) {
 	p.keyLength = uint32(len(hash))
 
 	return p, salt, hash, nil
-}
\ No newline at end of file
+}
+
+const (
+	argon2Memory      = 64 * 1024
+	argon2Iterations  = 3
+	argon2Parallelism = 2
+	argon2SaltLength  = 16
+	argon2KeyLength   = 32
+)
+
+func generateArgon2Hash(password string, memory, iterations uint32) (string, error) {
+	if memory == 0 {
+		memory = argon2Memory
+	}
+	if iterations == 0 {
+		iterations = argon2Iterations
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, iterations, memory, argon2Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("{ARGON2}$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, memory, iterations, argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+const (
+	pbkdf2SHA512Prefix     = "{PBKDF2-SHA512}"
+	pbkdf2SHA512Iterations = 10000
+	pbkdf2SaltLength       = 16
+)
+
+// pbkdf2Encoding is the adapted base64 encoding used by OpenLDAP pw-pbkdf2 module ('.' instead of '+', no padding).
+var pbkdf2Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// generatePBKDF2SHA512Hash returns the hash which is compatible with OpenLDAP pw-pbkdf2 module.
+//
+//	{PBKDF2-SHA512}<Iterations>$<Salt>$<DK>
+func generatePBKDF2SHA512Hash(password string) (string, error) {
+	salt := make([]byte, pbkdf2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	dk := pbkdf2.Key([]byte(password), salt, pbkdf2SHA512Iterations, sha512.Size, sha512.New)
+
+	return fmt.Sprintf("%s%d$%s$%s", pbkdf2SHA512Prefix, pbkdf2SHA512Iterations,
+		pbkdf2Encoding.EncodeToString(salt), pbkdf2Encoding.EncodeToString(dk)), nil
+}
+
+func validatePBKDF2SHA512(password, encodedHash string) (bool, error) {
+	vals := strings.Split(strings.TrimPrefix(encodedHash, pbkdf2SHA512Prefix), "$")
+	if len(vals) != 3 {
+		return false, ErrInvalidHash
+	}
+
+	iterations, err := strconv.Atoi(vals[0])
+	if err != nil || iterations <= 0 {
+		return false, ErrInvalidHash
+	}
+	salt, err := pbkdf2Encoding.DecodeString(vals[1])
+	if err != nil {
+		return false, err
+	}
+	dk, err := pbkdf2Encoding.DecodeString(vals[2])
+	if err != nil {
+		return false, err
+	}
+
+	otherDK := pbkdf2.Key([]byte(password), salt, iterations, len(dk), sha512.New)
+
+	return subtle.ConstantTimeCompare(dk, otherDK) == 1, nil
+}
+
+func generateBcryptHash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return "{BCRYPT}" + string(hash), nil
+}
+
+func validateBcrypt(password, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(strings.TrimPrefix(encodedHash, "{BCRYPT}")), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// validatePasswordHashScheme checks the scheme is supported for hashing the password.
+func validatePasswordHashScheme(scheme string) error {
+	switch strings.ToUpper(scheme) {
+	case "", "SSHA512", "ARGON2", "BCRYPT", "PBKDF2", "PBKDF2-SHA512", "SCRAM-SHA-256":
+		return nil
+	default:
+		return xerrors.Errorf("unsupported password hash scheme. Need SSHA512, ARGON2, BCRYPT, PBKDF2 or SCRAM-SHA-256: %s", scheme)
+	}
+}
+
+// hashPassword hashes the password with the configured scheme for storing it in userPassword.
+// SSHA512 is used when the scheme isn't configured.
+func hashPassword(config *ServerConfig, password string) (string, error) {
+	if err := validatePasswordHashScheme(config.PasswordHash); err != nil {
+		return "", err
+	}
+
+	switch strings.ToUpper(config.PasswordHash) {
+	case "ARGON2":
+		return generateArgon2Hash(password, config.PasswordHashArgon2Memory, config.PasswordHashArgon2Iterations)
+	case "BCRYPT":
+		return generateBcryptHash(password)
+	case "PBKDF2", "PBKDF2-SHA512":
+		return generatePBKDF2SHA512Hash(password)
+	case "SCRAM-SHA-256":
+		return generateSCRAMSHA256(password, scramSHA256DefaultIterations)
+	default:
+		return ssha512.Generate(password, 20)
+	}
+}
+
+// preferredPasswordScheme returns the scheme of the password hashed by the config.
+func preferredPasswordScheme(config *ServerConfig) string {
+	switch strings.ToUpper(config.PasswordHash) {
+	case "ARGON2":
+		return "ARGON2"
+	case "BCRYPT":
+		return "BCRYPT"
+	case "PBKDF2", "PBKDF2-SHA512":
+		return "PBKDF2-SHA512"
+	case "SCRAM-SHA-256":
+		return "SCRAM-SHA-256"
+	default:
+		return "SSHA512"
+	}
+}
+
+// needsPasswordRehash checks the stored password should be re-hashed on bind because it uses
+// a weaker scheme or weaker parameters than configured.
+// It's enabled only if the server-side hashing is configured.
+func needsPasswordRehash(config *ServerConfig, cred string) bool {
+	if config.PasswordHash == "" {
+		return false
+	}
+	scheme, value, ok := splitPasswordScheme(cred)
+	if !ok {
+		// Clear-text
+		return true
+	}
+	preferred := preferredPasswordScheme(config)
+	switch {
+	case scheme == "SASL":
+		// Pass-through authentication can't be replaced
+		return false
+	case scheme == "SCRAM-SHA-256" && preferred != scheme:
+		// SCRAM credential is kept for SASL SCRAM-SHA-256
+		return false
+	}
+	if scheme != preferred {
+		return true
+	}
+	return hasWeakerPasswordHashParams(config, scheme, value)
+}
+
+// hasWeakerPasswordHashParams checks the parameters of the hashed value are weaker than configured.
+func hasWeakerPasswordHashParams(config *ServerConfig, scheme, value string) bool {
+	switch scheme {
+	case "ARGON2":
+		p, _, _, err := decodeHash("{ARGON2}" + value)
+		if err != nil {
+			return false
+		}
+		memory := config.PasswordHashArgon2Memory
+		if memory == 0 {
+			memory = argon2Memory
+		}
+		iterations := config.PasswordHashArgon2Iterations
+		if iterations == 0 {
+			iterations = argon2Iterations
+		}
+		return p.memory < memory || p.iterations < iterations
+	case "BCRYPT":
+		cost, err := bcrypt.Cost([]byte(value))
+		if err != nil {
+			return false
+		}
+		return cost < bcrypt.DefaultCost
+	case "PBKDF2-SHA512":
+		vals := strings.SplitN(value, "$", 2)
+		iterations, err := strconv.Atoi(vals[0])
+		if err != nil {
+			return false
+		}
+		return iterations < pbkdf2SHA512Iterations
+	case "SCRAM-SHA-256":
+		v, err := parseSCRAMSHA256Verifier(SCRAMSHA256Prefix + value)
+		if err != nil {
+			return false
+		}
+		return v.iterations < scramSHA256DefaultIterations
+	default:
+		return false
+	}
+}
+
+// validatePrehashedPasswords rejects the pre-hashed passwords if it's configured.
+func validatePrehashedPasswords(s *Server, session *AuthSession, values []string) error {
+	if !s.config.RejectPrehashedPassword || session.IsRoot {
+		return nil
+	}
+	for _, v := range values {
+		if isHashedPassword(v) {
+			return NewPrehashedPasswordNotAllowed()
+		}
+	}
+	return nil
+}
+
+// hashClearTextPasswords hashes the clear-text passwords if the server-side hashing is enabled.
+// The values which are hashed already are stored as they are.
+func hashClearTextPasswords(config *ServerConfig, values []string) ([]string, error) {
+	if config.PasswordHash == "" {
+		return values, nil
+	}
+
+	hashed := make([]string, len(values))
+	for i, v := range values {
+		if isHashedPassword(v) {
+			hashed[i] = v
+			continue
+		}
+		h, err := hashPassword(config, v)
+		if err != nil {
+			return nil, err
+		}
+		hashed[i] = h
+	}
+	return hashed, nil
+}
+
+// generatePassword returns the random password.
+func generatePassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,106 @@
+package ldap_pg
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"github.com/jsimonetti/pwscheme/ssha"
+	"github.com/jsimonetti/pwscheme/ssha256"
+	"github.com/jsimonetti/pwscheme/ssha512"
+)
+
+// passwordVerifier verifies the input password with the hashed value whose {SCHEME} prefix is removed.
+type passwordVerifier func(s *Server, input, value string) (bool, error)
+
+// passwordVerifiers is the registry of the password verifiers keyed by the scheme in upper case.
+var passwordVerifiers = map[string]passwordVerifier{}
+
+func init() {
+	registerPasswordVerifier("SSHA", withSchemePrefix("{SSHA}", ssha.Validate))
+	registerPasswordVerifier("SSHA256", withSchemePrefix("{SSHA256}", ssha256.Validate))
+	registerPasswordVerifier("SSHA512", withSchemePrefix("{SSHA512}", ssha512.Validate))
+	registerPasswordVerifier("ARGON2", withSchemePrefix("{ARGON2}", comparePasswordAndHash))
+	registerPasswordVerifier("BCRYPT", withSchemePrefix("{BCRYPT}", validateBcrypt))
+	registerPasswordVerifier("PBKDF2-SHA512", withSchemePrefix(pbkdf2SHA512Prefix, validatePBKDF2SHA512))
+	registerPasswordVerifier("SCRAM-SHA-256", withSchemePrefix(SCRAMSHA256Prefix, validateSCRAMSHA256))
+	registerPasswordVerifier("CRYPT", withoutServer(validateCrypt))
+	registerPasswordVerifier("SHA", withoutServer(validateSHA))
+	registerPasswordVerifier("MD5", withoutServer(validateMD5))
+	registerPasswordVerifier("SMD5", withoutServer(validateSMD5))
+	registerPasswordVerifier("SASL", doPassThrough)
+}
+
+// registerPasswordVerifier registers the verifier for the scheme.
+func registerPasswordVerifier(scheme string, verifier passwordVerifier) {
+	passwordVerifiers[strings.ToUpper(scheme)] = verifier
+}
+
+// withSchemePrefix adapts the validator which requires the hashed value with the {SCHEME} prefix.
+func withSchemePrefix(prefix string, validate func(password, encodedHash string) (bool, error)) passwordVerifier {
+	return func(s *Server, input, value string) (bool, error) {
+		return validate(input, prefix+value)
+	}
+}
+
+func withoutServer(validate func(password, encodedHash string) (bool, error)) passwordVerifier {
+	return func(s *Server, input, value string) (bool, error) {
+		return validate(input, value)
+	}
+}
+
+// splitPasswordScheme splits the stored password into the scheme in upper case and the hashed value.
+// The ok is false if the password doesn't have {SCHEME} prefix.
+func splitPasswordScheme(cred string) (scheme, value string, ok bool) {
+	if !isHashedPassword(cred) {
+		return "", "", false
+	}
+	i := strings.Index(cred, "}")
+	return strings.ToUpper(cred[1:i]), cred[i+1:], true
+}
+
+// findPasswordVerifier returns the verifier for the stored password.
+// The ok is false if the password is clear-text or the scheme isn't supported.
+func findPasswordVerifier(cred string) (verifier passwordVerifier, value string, ok bool) {
+	scheme, value, ok := splitPasswordScheme(cred)
+	if !ok {
+		return nil, "", false
+	}
+	verifier, ok = passwordVerifiers[scheme]
+	return verifier, value, ok
+}
+
+func validateSHA(password, encodedHash string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	return validateDigest(sum[:], encodedHash)
+}
+
+func validateMD5(password, encodedHash string) (bool, error) {
+	sum := md5.Sum([]byte(password))
+	return validateDigest(sum[:], encodedHash)
+}
+
+func validateSMD5(password, encodedHash string) (bool, error) {
+	data, err := base64.StdEncoding.DecodeString(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	if len(data) <= md5.Size {
+		return false, ErrInvalidHash
+	}
+
+	salt := data[md5.Size:]
+	sum := md5.Sum(append([]byte(password), salt...))
+
+	return subtle.ConstantTimeCompare(data[:md5.Size], sum[:]) == 1, nil
+}
+
+func validateDigest(sum []byte, encodedHash string) (bool, error) {
+	data, err := base64.StdEncoding.DecodeString(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(data, sum) == 1, nil
+}
@@ -0,0 +1,86 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"testing"
+)
+
+func TestValidateCredSchemes(t *testing.T) {
+	testcases := []struct {
+		Input    string
+		Cred     string
+		Expected bool
+	}{
+		{"secret", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", true},
+		{"invalid", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", false},
+		{"secret", "{MD5}Xr4ilOzQ4PCOq3aQ0qbuaQ==", true},
+		{"secret", "{SMD5}mc0uWpXVVe5747A4pKhGJXNhbHQ=", true},
+		{"invalid", "{SMD5}mc0uWpXVVe5747A4pKhGJXNhbHQ=", false},
+		{"secret", "{CRYPT}$1$saltsalt$9xy1btjgzLYfb7hivXtC//", true},
+		{"invalid", "{CRYPT}$1$saltsalt$9xy1btjgzLYfb7hivXtC//", false},
+		{"Hello world!", "{CRYPT}$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", true},
+		{"Hello world!", "{CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", true},
+		{"secret", "{crypt}$6$rounds=10000$abcdefgh$dtkgtX8ow6kub/Iulo6m6YRiWBlfmJEeDmTXbQPwlPu6qBjkZV2Ix8CeH0sE3NMp3Sq63bHshmKLBUGe7mWYy/", true},
+		{"invalid", "{CRYPT}$6$rounds=10000$abcdefgh$dtkgtX8ow6kub/Iulo6m6YRiWBlfmJEeDmTXbQPwlPu6qBjkZV2Ix8CeH0sE3NMp3Sq63bHshmKLBUGe7mWYy/", false},
+		{"secret", "secret", true},
+		{"secret", "{UNKNOWN}secret", false},
+		{"{UNKNOWN}secret", "{UNKNOWN}secret", true},
+	}
+
+	for i, tc := range testcases {
+		if ok := validateCred(nil, tc.Input, tc.Cred); ok != tc.Expected {
+			t.Errorf("Unexpected result on %d: expected %v, got %v", i, tc.Expected, ok)
+		}
+	}
+}
+
+func TestIsHashedPassword(t *testing.T) {
+	testcases := []struct {
+		Password string
+		Expected bool
+	}{
+		{"{SSHA}prehashed", true},
+		{"{ssha512}prehashed", true},
+		{"{SASL}user@example.com", true},
+		{"{x}secret", false},
+		{"{UNKNOWN}secret", false},
+		{"secret", false},
+	}
+
+	for i, tc := range testcases {
+		if ok := isHashedPassword(tc.Password); ok != tc.Expected {
+			t.Errorf("Unexpected result on %d: expected %v, got %v", i, tc.Expected, ok)
+		}
+	}
+}
+
+func TestNeedsPasswordRehash(t *testing.T) {
+	testcases := []struct {
+		Scheme   string
+		Cred     string
+		Expected bool
+	}{
+		{"", "{SSHA}xxx", false},
+		{"ARGON2", "{SSHA}xxx", true},
+		{"ARGON2", "{ARGON2}$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$aGFzaA", false},
+		{"ARGON2", "{ARGON2}$argon2id$v=19$m=65536,t=1,p=2$c2FsdA$aGFzaA", true},
+		{"ARGON2", "{ARGON2}$argon2id$v=19$m=1024,t=3,p=2$c2FsdA$aGFzaA", true},
+		{"BCRYPT", "{BCRYPT}$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", false},
+		{"BCRYPT", "{BCRYPT}$2a$04$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", true},
+		{"PBKDF2", "{PBKDF2-SHA512}10000$xxx$yyy", false},
+		{"PBKDF2", "{PBKDF2-SHA512}1000$xxx$yyy", true},
+		{"SSHA512", "clear-text", true},
+		{"SSHA512", "{SASL}foo@example.com", false},
+		{"SSHA512", "{SCRAM-SHA-256}4096:xxx$yyy:zzz", false},
+		{"SCRAM-SHA-256", "{SSHA512}xxx", true},
+		{"SCRAM-SHA-256", "{SCRAM-SHA-256}4096:c2FsdA==$c3RvcmVk:c2VydmVy", false},
+		{"SCRAM-SHA-256", "{SCRAM-SHA-256}1024:c2FsdA==$c3RvcmVk:c2VydmVy", true},
+	}
+
+	for i, tc := range testcases {
+		if ok := needsPasswordRehash(&ServerConfig{PasswordHash: tc.Scheme}, tc.Cred); ok != tc.Expected {
+			t.Errorf("Unexpected result on %d: expected %v, got %v", i, tc.Expected, ok)
+		}
+	}
+}
@@ -1,14 +1,33 @@
 package ldap_pg
 
 import (
+	"context"
+	"log"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
 )
 
+// PPolicy is the password policy.
+// https://tools.ietf.org/html/draft-behera-ldap-password-policy-10
 type PPolicy struct {
-	PwdAttribute       []string `json:"pwdAttribute"`
-	PwdLockout         []string `json:"pwdLockout"`
-	PwdLockoutDuration []string `json:"pwdLockoutDuration"`
-	PwdMaxFailure      []string `json:"pwdMaxFailure"`
+	PwdAttribute            []string `json:"pwdAttribute"`
+	PwdMinAge               []string `json:"pwdMinAge"`
+	PwdMaxAge               []string `json:"pwdMaxAge"`
+	PwdInHistory            []string `json:"pwdInHistory"`
+	PwdCheckQuality         []string `json:"pwdCheckQuality"`
+	PwdMinLength            []string `json:"pwdMinLength"`
+	PwdExpireWarning        []string `json:"pwdExpireWarning"`
+	PwdGraceAuthNLimit      []string `json:"pwdGraceAuthNLimit"`
+	PwdLockout              []string `json:"pwdLockout"`
+	PwdLockoutDuration      []string `json:"pwdLockoutDuration"`
+	PwdMaxFailure           []string `json:"pwdMaxFailure"`
+	PwdFailureCountInterval []string `json:"pwdFailureCountInterval"`
+	PwdMustChange           []string `json:"pwdMustChange"`
 }
 
 func (p *PPolicy) IsLockoutEnabled() bool {
@@ -20,8 +39,317 @@ func (p *PPolicy) ShouldLockout(current int) bool {
 }
 
 func (p *PPolicy) LockoutDuration() int64 {
-	if len(p.PwdLockoutDuration) > 0 {
-		i, err := strconv.ParseInt(p.PwdLockoutDuration[0], 10, 64)
+	return parseInt64Attr(p.PwdLockoutDuration)
+}
+
+func (p *PPolicy) MaxFailure() int {
+	return parseIntAttr(p.PwdMaxFailure)
+}
+
+// FailureCountInterval returns the seconds after which the failures are purged from the failure counter.
+func (p *PPolicy) FailureCountInterval() int64 {
+	return parseInt64Attr(p.PwdFailureCountInterval)
+}
+
+func (p *PPolicy) MinAge() int64 {
+	return parseInt64Attr(p.PwdMinAge)
+}
+
+func (p *PPolicy) MaxAge() int64 {
+	return parseInt64Attr(p.PwdMaxAge)
+}
+
+func (p *PPolicy) InHistory() int {
+	return parseIntAttr(p.PwdInHistory)
+}
+
+// CheckQuality returns 0 (no checking), 1 (check if possible) or 2 (always check).
+func (p *PPolicy) CheckQuality() int {
+	return parseIntAttr(p.PwdCheckQuality)
+}
+
+func (p *PPolicy) MinLength() int {
+	return parseIntAttr(p.PwdMinLength)
+}
+
+func (p *PPolicy) ExpireWarning() int64 {
+	return parseInt64Attr(p.PwdExpireWarning)
+}
+
+func (p *PPolicy) GraceAuthNLimit() int {
+	return parseIntAttr(p.PwdGraceAuthNLimit)
+}
+
+func (p *PPolicy) MustChange() bool {
+	return len(p.PwdMustChange) > 0 && p.PwdMustChange[0] == "TRUE"
+}
+
+// IsPasswordExpired returns true if the password changed at pwdChangedTime is older than pwdMaxAge.
+// The password which doesn't have pwdChangedTime never expires.
+func (p *PPolicy) IsPasswordExpired(pwdChangedTime *time.Time, now time.Time) bool {
+	if p.MaxAge() <= 0 || pwdChangedTime == nil {
+		return false
+	}
+	return !now.Before(p.expirationTime(pwdChangedTime))
+}
+
+// TimeBeforeExpiration returns the seconds before the password expires,
+// or 0 if the password doesn't expire or the expiration warning is disabled.
+func (p *PPolicy) TimeBeforeExpiration(pwdChangedTime *time.Time, now time.Time) int64 {
+	if p.MaxAge() <= 0 || p.ExpireWarning() <= 0 || pwdChangedTime == nil {
+		return 0
+	}
+	remaining := int64(p.expirationTime(pwdChangedTime).Sub(now) / time.Second)
+	if remaining <= 0 || remaining > p.ExpireWarning() {
+		return 0
+	}
+	return remaining
+}
+
+func (p *PPolicy) expirationTime(pwdChangedTime *time.Time) time.Time {
+	return pwdChangedTime.Add(time.Duration(p.MaxAge()) * time.Second)
+}
+
+// IsPasswordTooYoung returns true if the password can't be changed yet because of pwdMinAge.
+func (p *PPolicy) IsPasswordTooYoung(pwdChangedTime *time.Time, now time.Time) bool {
+	if p.MinAge() <= 0 || pwdChangedTime == nil {
+		return false
+	}
+	return now.Before(pwdChangedTime.Add(time.Duration(p.MinAge()) * time.Second))
+}
+
+// CheckPasswordQuality validates the new password with pwdCheckQuality and pwdMinLength.
+// The length of hashed password can't be checked, so it's rejected only if pwdCheckQuality is 2.
+func (p *PPolicy) CheckPasswordQuality(password string) error {
+	switch p.CheckQuality() {
+	case 0:
+		return nil
+	case 1:
+		if isHashedPassword(password) {
+			return nil
+		}
+	default:
+		if isHashedPassword(password) {
+			return NewInsufficientPasswordQuality()
+		}
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength() {
+		return NewPasswordTooShort()
+	}
+	return nil
+}
+
+// FailureTimesInInterval returns the failure times which are not purged by pwdFailureCountInterval.
+func (p *PPolicy) FailureTimesInInterval(failureTimes []*time.Time, now time.Time) []*time.Time {
+	interval := p.FailureCountInterval()
+	if interval <= 0 {
+		return failureTimes
+	}
+
+	since := now.Add(-time.Duration(interval) * time.Second)
+	var filtered []*time.Time
+	for _, v := range failureTimes {
+		if v.After(since) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+// findPPolicy returns the password policy applied to the entry, or nil if no policy is configured.
+// The policy is resolved by pwdPolicySubentry of the entry, or the default password policy.
+func findPPolicy(ctx context.Context, s *Server, attrs map[string]*SchemaValue) (*PPolicy, error) {
+	if sv, ok := attrs["pwdPolicySubentry"]; ok {
+		dn, err := s.NormalizeDN(sv.Orig()[0])
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to normalize pwdPolicySubentry. value: %s, err: %w", sv.Orig()[0], err)
+		}
+		ppolicy, err := s.Repo().FindPPolicyByDN(ctx, dn)
+		if err != nil {
+			return nil, err
+		}
+		if ppolicy != nil {
+			return ppolicy, nil
+		}
+		log.Printf("warn: Not found the ppolicy of pwdPolicySubentry, use the default ppolicy. dn_norm: %s", dn.DNNormStr())
+	}
+
+	if s.defaultPPolicyDN.IsAnonymous() {
+		return nil, nil
+	}
+	return s.Repo().FindPPolicyByDN(ctx, s.defaultPPolicyDN)
+}
+
+// validatePPolicySubentry checks the password policy entry referenced by pwdPolicySubentry exists.
+func validatePPolicySubentry(ctx context.Context, s *Server, attrs map[string]*SchemaValue) error {
+	sv, ok := attrs["pwdPolicySubentry"]
+	if !ok {
+		return nil
+	}
+
+	dn, err := s.NormalizeDN(sv.Orig()[0])
+	if err != nil {
+		return NewInvalidPerSyntax("pwdPolicySubentry", 0)
+	}
+
+	ppolicy, err := s.Repo().FindPPolicyByDN(ctx, dn)
+	if err != nil {
+		return err
+	}
+	// pwdAttribute is required by pwdPolicy objectClass
+	if ppolicy == nil || len(ppolicy.PwdAttribute) == 0 {
+		log.Printf("warn: Invalid pwdPolicySubentry. dn_norm: %s", dn.DNNormStr())
+		return NewNoSuchPPolicy(sv.Orig()[0])
+	}
+	return nil
+}
+
+// isPasswordAttribute returns true if the attribute name points userPassword.
+func isPasswordAttribute(s *Server, attrName string) bool {
+	at, ok := s.schemaMap.AttributeType(attrName)
+	return ok && at.Name == "userPassword"
+}
+
+// applyPasswordPolicy validates the password change with the password policy,
+// then records the password policy state (pwdChangedTime, pwdHistory and pwdReset) into the attributes.
+// The attrs are the attributes after the change, the oldPasswords are userPassword before the change
+// and the newPasswords are the passwords set by the change before hashing if possible.
+func applyPasswordPolicy(s *Server, ppolicy *PPolicy, attrs map[string]*SchemaValue, oldPasswords, newPasswords []string, isSelfChange bool, now time.Time) error {
+	// The password state is meaningless without the password
+	if _, ok := attrs["userPassword"]; !ok {
+		delete(attrs, "pwdChangedTime")
+		delete(attrs, "pwdReset")
+		delete(attrs, "pwdGraceUseTime")
+		return nil
+	}
+
+	if isSelfChange {
+		var pwdChangedTime *time.Time
+		if sv, ok := attrs["pwdChangedTime"]; ok {
+			if t, err := time.Parse(TIMESTAMP_FORMAT, sv.Orig()[0]); err == nil {
+				pwdChangedTime = &t
+			}
+		}
+		if ppolicy.IsPasswordTooYoung(pwdChangedTime, now) {
+			return NewPasswordTooYoung()
+		}
+	}
+
+	for _, v := range newPasswords {
+		if err := ppolicy.CheckPasswordQuality(v); err != nil {
+			return err
+		}
+	}
+
+	if inHistory := ppolicy.InHistory(); inHistory > 0 {
+		var history []string
+		if sv, ok := attrs["pwdHistory"]; ok {
+			history = append(history, sv.Orig()...)
+		}
+
+		used := make([]string, 0, len(oldPasswords)+len(history))
+		used = append(used, oldPasswords...)
+		for _, v := range history {
+			if p, ok := parsePwdHistoryValue(v); ok {
+				used = append(used, p)
+			}
+		}
+
+		for _, v := range newPasswords {
+			if isPasswordUsed(s, v, used) {
+				return NewPasswordInHistory()
+			}
+		}
+
+		// Keep the latest pwdInHistory passwords
+		for _, v := range oldPasswords {
+			history = append(history, pwdHistoryValue(now, v))
+		}
+		if over := len(history) - inHistory; over > 0 {
+			history = history[over:]
+		}
+		if len(history) > 0 {
+			if err := putPasswordPolicyState(s, attrs, "pwdHistory", history); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := putPasswordPolicyState(s, attrs, "pwdChangedTime", []string{now.In(time.UTC).Format(TIMESTAMP_FORMAT)}); err != nil {
+		return err
+	}
+
+	// The password changed by the administrator must be changed by the user at the next bind
+	if !isSelfChange && ppolicy.MustChange() {
+		if err := putPasswordPolicyState(s, attrs, "pwdReset", []string{"TRUE"}); err != nil {
+			return err
+		}
+	} else {
+		delete(attrs, "pwdReset")
+	}
+	delete(attrs, "pwdGraceUseTime")
+
+	return nil
+}
+
+// putPasswordPolicyState sets the operational attribute without NO-USER-MODIFICATION checking.
+func putPasswordPolicyState(s *Server, attrs map[string]*SchemaValue, attrName string, value []string) error {
+	sv, err := NewSchemaValue(s.schemaMap, attrName, value)
+	if err != nil {
+		return err
+	}
+	attrs[sv.Name()] = sv
+	return nil
+}
+
+// isPasswordUsed returns true if the new password matches one of the used passwords.
+func isPasswordUsed(s *Server, password string, used []string) bool {
+	for _, v := range used {
+		if password == v {
+			return true
+		}
+		// Don't call the pass-through authentication for the history
+		if isHashedPassword(v) && !strings.HasPrefix(v, "{SASL}") && validateCred(s, password, v) {
+			return true
+		}
+	}
+	return false
+}
+
+var hashedPasswordRegexp = regexp.MustCompile(`^\{[A-Za-z0-9._-]+\}`)
+
+// isHashedPassword returns true if the password has {SCHEME} prefix of the registered password verifier.
+// The password with unknown prefix (e.g. "{x}secret") is treated as clear-text.
+func isHashedPassword(password string) bool {
+	prefix := hashedPasswordRegexp.FindString(password)
+	if prefix == "" {
+		return false
+	}
+	_, ok := passwordVerifiers[strings.ToUpper(prefix[1:len(prefix)-1])]
+	return ok
+}
+
+// pwdHistoryValue formats the old password for pwdHistory.
+//
+//	pwdHistory = time "#" syntaxOID "#" length "#" data
+func pwdHistoryValue(t time.Time, password string) string {
+	return t.In(time.UTC).Format(TIMESTAMP_FORMAT) + "#1.3.6.1.4.1.1466.115.121.1.40#" +
+		strconv.Itoa(len(password)) + "#" + password
+}
+
+// parsePwdHistoryValue returns the old password stored in pwdHistory.
+func parsePwdHistoryValue(value string) (string, bool) {
+	parts := strings.SplitN(value, "#", 4)
+	if len(parts) != 4 {
+		return "", false
+	}
+	return parts[3], true
+}
+
+func parseIntAttr(value []string) int {
+	if len(value) > 0 {
+		i, err := strconv.Atoi(value[0])
 		if err != nil {
 			return 0
 		}
@@ -30,9 +358,9 @@ func (p *PPolicy) LockoutDuration() int64 {
 	return 0
 }
 
-func (p *PPolicy) MaxFailure() int {
-	if len(p.PwdMaxFailure) > 0 {
-		i, err := strconv.Atoi(p.PwdMaxFailure[0])
+func parseInt64Attr(value []string) int64 {
+	if len(value) > 0 {
+		i, err := strconv.ParseInt(value[0], 10, 64)
 		if err != nil {
 			return 0
 		}
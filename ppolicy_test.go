@@ -0,0 +1,118 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPPolicyExpiration(t *testing.T) {
+	ppolicy := &PPolicy{
+		PwdMaxAge:        []string{"3600"},
+		PwdExpireWarning: []string{"600"},
+		PwdMinAge:        []string{"60"},
+	}
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		ChangedBefore        time.Duration
+		Expired              bool
+		TimeBeforeExpiration int64
+		TooYoung             bool
+	}{
+		{30 * time.Second, false, 0, true},
+		{10 * time.Minute, false, 0, false},
+		{55 * time.Minute, false, 300, false},
+		{60 * time.Minute, true, 0, false},
+		{2 * time.Hour, true, 0, false},
+	}
+
+	for i, tc := range testcases {
+		changed := now.Add(-tc.ChangedBefore)
+
+		if expired := ppolicy.IsPasswordExpired(&changed, now); expired != tc.Expired {
+			t.Errorf("Unexpected expired on %d: expected %v, got %v", i, tc.Expired, expired)
+		}
+		if remaining := ppolicy.TimeBeforeExpiration(&changed, now); remaining != tc.TimeBeforeExpiration {
+			t.Errorf("Unexpected time before expiration on %d: expected %d, got %d", i, tc.TimeBeforeExpiration, remaining)
+		}
+		if tooYoung := ppolicy.IsPasswordTooYoung(&changed, now); tooYoung != tc.TooYoung {
+			t.Errorf("Unexpected too young on %d: expected %v, got %v", i, tc.TooYoung, tooYoung)
+		}
+	}
+
+	// The password without pwdChangedTime never expires
+	if ppolicy.IsPasswordExpired(nil, now) {
+		t.Errorf("Unexpected expired without pwdChangedTime")
+	}
+}
+
+func TestPPolicyCheckPasswordQuality(t *testing.T) {
+	testcases := []struct {
+		CheckQuality string
+		Password     string
+		Subtype      string
+	}{
+		{"0", "short", ""},
+		{"1", "short", "Password too short"},
+		{"1", "longenough", ""},
+		{"1", "{SSHA}hashed", ""},
+		{"2", "{SSHA}hashed", "Insufficient password quality"},
+		{"2", "longenough", ""},
+	}
+
+	for i, tc := range testcases {
+		ppolicy := &PPolicy{
+			PwdCheckQuality: []string{tc.CheckQuality},
+			PwdMinLength:    []string{"8"},
+		}
+
+		err := ppolicy.CheckPasswordQuality(tc.Password)
+		if tc.Subtype == "" {
+			if err != nil {
+				t.Errorf("Unexpected error on %d: %v", i, err)
+			}
+			continue
+		}
+		lerr, ok := err.(*LDAPError)
+		if !ok || lerr.Subtype != tc.Subtype {
+			t.Errorf("Unexpected error on %d: expected %s, got %v", i, tc.Subtype, err)
+		}
+	}
+}
+
+func TestPPolicyFailureTimesInInterval(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-10 * time.Minute)
+	recent := now.Add(-1 * time.Minute)
+
+	ppolicy := &PPolicy{
+		PwdFailureCountInterval: []string{"300"},
+	}
+
+	filtered := ppolicy.FailureTimesInInterval([]*time.Time{&old, &recent}, now)
+	if len(filtered) != 1 || filtered[0] != &recent {
+		t.Errorf("Unexpected failure times: %v", filtered)
+	}
+
+	// No interval keeps all failures
+	if all := (&PPolicy{}).FailureTimesInInterval([]*time.Time{&old, &recent}, now); len(all) != 2 {
+		t.Errorf("Unexpected failure times: %v", all)
+	}
+}
+
+func TestPwdHistoryValue(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	v := pwdHistoryValue(now, "pass#word")
+	if v != "20200101120000Z#1.3.6.1.4.1.1466.115.121.1.40#9#pass#word" {
+		t.Errorf("Unexpected pwdHistory: %s", v)
+	}
+
+	p, ok := parsePwdHistoryValue(v)
+	if !ok || p != "pass#word" {
+		t.Errorf("Unexpected password: %s", p)
+	}
+}
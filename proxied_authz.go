@@ -0,0 +1,137 @@
+package ldap_pg
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
+)
+
+// applyProxiedAuthz swaps the effective session for the request if it has the proxied authorization control.
+// It writes the error response and returns false if the control is invalid or the requester isn't allowed to proxy.
+// https://tools.ietf.org/html/rfc4370
+func applyProxiedAuthz(s *Server, w ldap.ResponseWriter, m *ldap.Message) bool {
+	con, ok := findControl(m.Controls(), ProxiedAuthzControlOID)
+	if !ok {
+		return true
+	}
+	if !isProxiableOperation(m) {
+		log.Printf("info: Ignore the proxied authorization control. op: %s", operationName(m))
+		return true
+	}
+	if !bool(con.Criticality()) {
+		responseOperationError(w, m, NewProtocolError("the proxied authorization control must be critical"))
+		return false
+	}
+
+	var authzID string
+	if v := con.ControlValue(); v != nil {
+		authzID = v.String()
+	}
+
+	ctx := SetSessionContext(context.Background(), m)
+	session, err := resolveProxiedAuthSession(ctx, s, m, authzID)
+	if err != nil {
+		responseOperationError(w, m, err)
+		return false
+	}
+
+	log.Printf("info: Proxied authorization. authorizedDN: %s, authzId: %s", newACLRequester(m), authzID)
+
+	proxiedAuthSessions.Store(m, session)
+	return true
+}
+
+// releaseProxiedAuthz restores the effective session to the bound identity after processing the request.
+func releaseProxiedAuthz(m *ldap.Message) {
+	proxiedAuthSessions.Delete(m)
+}
+
+// proxiedAuthSession returns the proxied identity while processing the request with the proxied authorization control.
+func proxiedAuthSession(m *ldap.Message) (*AuthSession, bool) {
+	proxied, ok := proxiedAuthSessions.Load(m)
+	if !ok {
+		return nil, false
+	}
+	return proxied.(*AuthSession), true
+}
+
+// resolveProxiedAuthSession returns the session of the authzId, "dn:<DN>", "u:<user name>" or empty for anonymous.
+// The requester needs the proxy privilege for the identity entry, or it's denied without telling whether the entry exists.
+func resolveProxiedAuthSession(ctx context.Context, s *Server, m *ldap.Message, authzID string) (*AuthSession, error) {
+	if authzID == "" || authzID == "dn:" {
+		return &AuthSession{}, nil
+	}
+
+	var dn *DN
+	var groups []*DN
+	var err error
+
+	switch {
+	case strings.HasPrefix(authzID, "dn:"):
+		dn, err = s.NormalizeDN(strings.TrimPrefix(authzID, "dn:"))
+		if err != nil {
+			log.Printf("info: Invalid authzId of the proxied authorization. authzId: %s, err: %v", authzID, err)
+			return nil, NewAuthorizationDenied("")
+		}
+		dn, groups, err = resolveIdentityByDN(ctx, s, dn)
+	case strings.HasPrefix(authzID, "u:"):
+		dn, groups, err = s.saslIdentityMapping.Resolve(ctx, s, strings.TrimPrefix(authzID, "u:"))
+	default:
+		log.Printf("info: Invalid authzId of the proxied authorization. authzId: %s", authzID)
+		return nil, NewAuthorizationDenied("")
+	}
+	if err != nil {
+		var lerr *LDAPError
+		if ok := xerrors.As(err, &lerr); ok {
+			log.Printf("info: Can't resolve authzId of the proxied authorization. authzId: %s, err: %v", authzID, err)
+			return nil, NewAuthorizationDenied("")
+		}
+		return nil, err
+	}
+
+	if !s.CanProxy(ctx, m, dn) {
+		log.Printf("info: Not allowed proxied authorization. authorizedDN: %s, authzId: %s", newACLRequester(m), authzID)
+		return nil, NewAuthorizationDenied("")
+	}
+
+	return &AuthSession{
+		DN:     dn,
+		Groups: groups,
+	}, nil
+}
+
+// isProxiableOperation returns false for bind and StartTLS which the proxied authorization doesn't apply to.
+func isProxiableOperation(m *ldap.Message) bool {
+	switch m.ProtocolOpType() {
+	case ldap.ApplicationBindRequest:
+		return false
+	case ldap.ApplicationExtendedRequest:
+		r := m.GetExtendedRequest()
+		return r.RequestName() != ldap.NoticeOfStartTLS
+	default:
+		return true
+	}
+}
+
+// responseOperationError writes the error response of the operation of the request.
+func responseOperationError(w ldap.ResponseWriter, m *ldap.Message, err error) {
+	switch m.ProtocolOpType() {
+	case ldap.ApplicationSearchRequest:
+		responseSearchError(w, err)
+	case ldap.ApplicationAddRequest:
+		responseAddError(w, err)
+	case ldap.ApplicationDelRequest:
+		responseDeleteError(w, err)
+	case ldap.ApplicationModifyRequest:
+		responseModifyError(w, err)
+	case ldap.ApplicationModifyDNRequest:
+		responseModifyDNError(w, err)
+	case ldap.ApplicationCompareRequest:
+		responseCompareError(w, err)
+	default:
+		responseExtendedError(w, err)
+	}
+}
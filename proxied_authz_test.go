@@ -0,0 +1,56 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"context"
+	"testing"
+
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
+)
+
+func TestResolveProxiedAuthSession(t *testing.T) {
+	server := newACLTestServer(t)
+
+	// Anonymous
+	for _, authzID := range []string{"", "dn:"} {
+		session, err := resolveProxiedAuthSession(context.Background(), server, nil, authzID)
+		if err != nil {
+			t.Fatalf("Unexpected error of %q: %+v", authzID, err)
+		}
+		if session.DN != nil || session.IsRoot {
+			t.Errorf("Expected anonymous session of %q, got %v", authzID, session)
+		}
+	}
+
+	// Invalid authzId is denied
+	for _, authzID := range []string{"invalid", "dn:invalid", "x:user1"} {
+		_, err := resolveProxiedAuthSession(context.Background(), server, nil, authzID)
+		var lerr *LDAPError
+		if ok := xerrors.As(err, &lerr); !ok || lerr.Code != LDAPResultAuthorizationDenied {
+			t.Errorf("Expected authorizationDenied of %q, got %v", authzID, err)
+		}
+	}
+}
+
+func TestReleaseProxiedAuthz(t *testing.T) {
+	m := &ldap.Message{}
+	proxied := &AuthSession{}
+
+	proxiedAuthSessions.Store(m, proxied)
+	if session, ok := proxiedAuthSession(m); !ok || session != proxied {
+		t.Fatalf("Expected the proxied session, got %v", session)
+	}
+	if session := getAuthSession(m); session != proxied {
+		t.Errorf("Expected the proxied session as the effective session, got %v", session)
+	}
+
+	releaseProxiedAuthz(m)
+	if session, ok := proxiedAuthSession(m); ok {
+		t.Errorf("Unexpected proxied session after the release: %v", session)
+	}
+
+	// Releasing the request without the control doesn't panic
+	releaseProxiedAuthz(&ldap.Message{})
+}
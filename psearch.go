@@ -0,0 +1,337 @@
+package ldap_pg
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
+)
+
+// changeNotifyChannel is the channel of LISTEN/NOTIFY for the persistent search.
+const changeNotifyChannel = "ldap_pg_change"
+
+// psearchQueueSize is the max number of the pending changes per persistent search.
+// The search is terminated if the client can't keep up with the changes.
+const psearchQueueSize = 1000
+
+// maxNotifyPayloadSize is the max size of the payload of NOTIFY, it must be shorter than 8000 bytes.
+const maxNotifyPayloadSize = 7999
+
+// psearchBroker delivers the committed changes to the persistent searches.
+// The changes of this instance are published after the commit,
+// and the changes of the other instances are received via LISTEN/NOTIFY.
+type psearchBroker struct {
+	instanceID string
+	// fetchDeletedAttrs fetches the deleted entry which was dropped from the notification
+	fetchDeletedAttrs func(changeNumber int64) (map[string][]string, error)
+	mu                sync.Mutex
+	subscribers       map[chan *ChangeRecord]struct{}
+}
+
+// changeNotification is the payload of NOTIFY.
+type changeNotification struct {
+	Instance     string `json:"instance"`
+	ChangeType   string `json:"changeType"`
+	DN           string `json:"dn"`
+	EntryUUID    string `json:"entryUUID,omitempty"`
+	ChangeNumber int64  `json:"changeNumber,omitempty"`
+	// Attrs is the deleted entry, it's dropped if the payload is too large.
+	// Then the receiver fetches it from the changelog by the change number.
+	Attrs       map[string][]string `json:"attrs,omitempty"`
+	NewRDN      string              `json:"newRDN,omitempty"`
+	NewSuperior string              `json:"newSuperior,omitempty"`
+}
+
+func newPSearchBroker(instanceID string) *psearchBroker {
+	return &psearchBroker{
+		instanceID:  instanceID,
+		subscribers: map[chan *ChangeRecord]struct{}{},
+	}
+}
+
+// initPersistentSearch starts listening the changes if the persistent search is enabled.
+func (s *Server) initPersistentSearch() error {
+	if !s.config.PersistentSearch {
+		return nil
+	}
+
+	b := newPSearchBroker(s.instanceID)
+	b.fetchDeletedAttrs = s.fetchDeletedAttrs
+	if err := s.Repo().ListenChanges(b.receive); err != nil {
+		return err
+	}
+	s.psearch = b
+
+	log.Printf("info: Listening the changes for the persistent search. instance: %s", b.instanceID)
+
+	return nil
+}
+
+func (b *psearchBroker) subscribe() chan *ChangeRecord {
+	ch := make(chan *ChangeRecord, psearchQueueSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *psearchBroker) unsubscribe(ch chan *ChangeRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish sends the change to all persistent searches without blocking.
+// The queue of the search which is full is closed.
+func (b *psearchBroker) publish(change *ChangeRecord) {
+	if b == nil || change == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- change:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// fetchDeletedAttrs returns the deleted entry recorded in the changelog, nil if it isn't recorded.
+func (s *Server) fetchDeletedAttrs(changeNumber int64) (map[string][]string, error) {
+	var attrs map[string][]string
+	err := s.Repo().SearchChangelog(context.Background(), &ChangelogSearchOption{
+		FirstChangeNumber: changeNumber,
+		LastChangeNumber:  changeNumber,
+	}, func(entry *ChangelogEntry) error {
+		return entry.DeletedAttrs.Unmarshal(&attrs)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to fetch the deleted entry from the changelog. change_number: %d, err: %w", changeNumber, err)
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	return attrs, nil
+}
+
+// encodeChangeNotification returns the payload of NOTIFY for the change.
+func encodeChangeNotification(instanceID string, change *ChangeRecord) (string, error) {
+	n := changeNotification{
+		Instance:     instanceID,
+		ChangeType:   change.ChangeType,
+		DN:           change.DN,
+		EntryUUID:    change.EntryUUID,
+		ChangeNumber: change.ChangeNumber,
+		NewRDN:       change.NewRDN,
+		NewSuperior:  change.NewSuperior,
+	}
+	if change.ChangeType == "delete" {
+		n.Attrs = change.Attrs
+	}
+
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return "", xerrors.Errorf("Failed to encode the change notification. dn_norm: %s, err: %w", change.DNNorm, err)
+	}
+
+	if len(payload) > maxNotifyPayloadSize && n.Attrs != nil {
+		log.Printf("info: The deleted entry is too large for the change notification, drop the attributes. dn_norm: %s", change.DNNorm)
+
+		n.Attrs = nil
+		if payload, err = json.Marshal(n); err != nil {
+			return "", xerrors.Errorf("Failed to encode the change notification. dn_norm: %s, err: %w", change.DNNorm, err)
+		}
+	}
+
+	return string(payload), nil
+}
+
+// receive publishes the change notified by the other instances.
+func (b *psearchBroker) receive(payload string) {
+	var n changeNotification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Printf("warn: Invalid change notification, ignore. payload: %s, err: %v", payload, err)
+		return
+	}
+
+	// The changes of this instance were already published after the commit
+	if n.Instance == b.instanceID {
+		return
+	}
+
+	if n.ChangeType == "delete" && n.Attrs == nil && n.ChangeNumber > 0 && b.fetchDeletedAttrs != nil {
+		attrs, err := b.fetchDeletedAttrs(n.ChangeNumber)
+		if err != nil {
+			log.Printf("warn: Failed to fetch the deleted entry of the change notification. dn: %s, err: %+v", n.DN, err)
+		}
+		n.Attrs = attrs
+	}
+
+	b.publish(&ChangeRecord{
+		ChangeType:   n.ChangeType,
+		DN:           n.DN,
+		EntryUUID:    n.EntryUUID,
+		ChangeNumber: n.ChangeNumber,
+		Attrs:        n.Attrs,
+		NewRDN:       n.NewRDN,
+		NewSuperior:  n.NewSuperior,
+	})
+}
+
+// handlePersistentSearch returns the entries in the scope unless changesOnly is requested,
+// then keeps sending the changed entries until the search is abandoned.
+// https://tools.ietf.org/html/draft-ietf-ldapext-psearch-03
+func handlePersistentSearch(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, baseDN *DN, psearchControl *PersistentSearchControl) {
+	// Subscribe before the initial search not to miss the changes during it
+	changes := s.psearch.subscribe()
+	defer s.psearch.unsubscribe(changes)
+
+	if !psearchControl.ChangesOnly {
+		option := &SearchOption{
+			Scope:                      int(r.Scope()),
+			Filter:                     r.Filter(),
+			PageSize:                   math.MaxInt32,
+			RequestedAssocation:        getRequestedMemberAttrs(r),
+			IsMemberOfRequested:        isMemberOfRequested(r),
+			IsHasSubordinatesRequested: isHasSubOrdinatesRequested(r),
+		}
+		_, _, err := s.Repo().Search(ctx, baseDN, option, func(entry *SearchEntry) error {
+			responseEntry(ctx, s, w, m, r, entry)
+			return nil
+		})
+		if err != nil {
+			responseSearchError(w, err)
+			return
+		}
+	}
+
+	log.Printf("info: Start the persistent search. conn: %d, msgid: %d", m.Client.Numero, int(m.MessageID()))
+
+	for {
+		select {
+		case <-m.Done:
+			log.Printf("info: Stop the persistent search. conn: %d, msgid: %d", m.Client.Numero, int(m.MessageID()))
+			return
+		case change, ok := <-changes:
+			if !ok {
+				log.Printf("warn: Too many pending changes, terminate the persistent search. conn: %d, msgid: %d", m.Client.Numero, int(m.MessageID()))
+
+				res := ldap.NewSearchResultDoneResponse(ldap.LDAPResultAdminLimitExceeded)
+				res.SetDiagnosticMessage("too many pending changes")
+				w.Write(res)
+				return
+			}
+			if err := responsePSearchChange(ctx, s, w, m, r, baseDN, psearchControl, change); err != nil {
+				responseSearchError(w, err)
+				return
+			}
+		}
+	}
+}
+
+// responsePSearchChange sends the changed entry if it's in the scope and matches the filter.
+// The deleted entry is sent with the attributes before the deletion,
+// it isn't sent if the attributes are unavailable since it can't be evaluated by the filter.
+func responsePSearchChange(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, baseDN *DN, psearchControl *PersistentSearchControl, change *ChangeRecord) error {
+	changeType := psearchChangeType(change.ChangeType)
+	if psearchControl.ChangeTypes&changeType == 0 {
+		return nil
+	}
+
+	dn, err := s.NormalizeDN(change.DN)
+	if err != nil {
+		log.Printf("warn: Invalid DN of the change, ignore for persistent search. dn: %s, err: %v", change.DN, err)
+		return nil
+	}
+	var previousDN string
+	if changeType == PSearchChangeModDN {
+		previousDN = change.DN
+		if dn, err = renamedDN(s, change.DN, change.NewRDN, change.NewSuperior); err != nil {
+			log.Printf("warn: Invalid new DN of the change, ignore for persistent search. dn: %s, err: %v", change.DN, err)
+			return nil
+		}
+	}
+
+	if !inSearchScope(baseDN, dn, int(r.Scope())) {
+		return nil
+	}
+
+	if psearchControl.ReturnECs {
+		control, err := newEntryChangeNotificationControl(changeType, previousDN)
+		if err != nil {
+			return err
+		}
+		w = &controlsResponseWriter{
+			ResponseWriter: w,
+			controls:       message.Controls{control},
+		}
+	}
+
+	if changeType == PSearchChangeDelete {
+		if change.Attrs == nil {
+			log.Printf("warn: The deleted entry is unavailable, ignore for persistent search. dn: %s", change.DN)
+			return nil
+		}
+		// The password attributes are masked in the change, so they aren't returned nor evaluated
+		entry := NewSearchEntry(s.schemaMap, change.DN, withoutMaskedAttrs(change.Attrs))
+		if !matchFilter(s.schemaMap, r.Filter(), entry) {
+			return nil
+		}
+		responseEntryWithDN(ctx, s, w, m, r, change.DN, entry)
+		return nil
+	}
+
+	// Fetch the current entry, it may be changed again after the notification
+	option := &SearchOption{
+		Scope:                      message.SearchRequestScopeBaseObject,
+		Filter:                     r.Filter(),
+		PageSize:                   1,
+		RequestedAssocation:        getRequestedMemberAttrs(r),
+		IsMemberOfRequested:        isMemberOfRequested(r),
+		IsHasSubordinatesRequested: isHasSubOrdinatesRequested(r),
+	}
+	_, _, err = s.Repo().Search(ctx, dn, option, func(entry *SearchEntry) error {
+		responseEntry(ctx, s, w, m, r, entry)
+		return nil
+	})
+	if err != nil {
+		var ldapErr *LDAPError
+		if ok := xerrors.As(err, &ldapErr); ok && ldapErr.IsNoSuchObjectError() {
+			// Deleted after the change
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func psearchChangeType(changeType string) int {
+	switch changeType {
+	case "add":
+		return PSearchChangeAdd
+	case "delete":
+		return PSearchChangeDelete
+	case "modify":
+		return PSearchChangeModify
+	case "modrdn":
+		return PSearchChangeModDN
+	default:
+		return 0
+	}
+}
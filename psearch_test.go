@@ -0,0 +1,146 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestPSearchBrokerEncode(t *testing.T) {
+	b := newPSearchBroker("instance1")
+
+	change := &ChangeRecord{
+		ChangeType: "delete",
+		DN:         "uid=user1,ou=Users,dc=example,dc=com",
+		DNNorm:     "uid=user1,ou=users,dc=example,dc=com",
+		EntryUUID:  "0b05df74-1219-495d-9d95-dc0c05e00aa9",
+		Attrs: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"user1"},
+		},
+	}
+	payload, err := encodeChangeNotification(b.instanceID, change)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	// The notification from the other instance is published
+	other := newPSearchBroker("instance2")
+	ch := other.subscribe()
+	defer other.unsubscribe(ch)
+
+	other.receive(payload)
+
+	select {
+	case received := <-ch:
+		if received.ChangeType != change.ChangeType || received.DN != change.DN || received.EntryUUID != change.EntryUUID ||
+			!reflect.DeepEqual(received.Attrs, change.Attrs) {
+			t.Errorf("Unexpected change: expected %v, got %v", change, received)
+		}
+	default:
+		t.Errorf("Expected the change from the other instance")
+	}
+
+	// The notification from this instance is ignored
+	ch2 := b.subscribe()
+	defer b.unsubscribe(ch2)
+
+	b.receive(payload)
+
+	select {
+	case received := <-ch2:
+		t.Errorf("Unexpected change from this instance: %v", received)
+	default:
+	}
+
+	// The attributes of the large deleted entry are dropped
+	change.Attrs["description"] = []string{strings.Repeat("a", maxNotifyPayloadSize)}
+	change.ChangeNumber = 10
+	payload, err = encodeChangeNotification(b.instanceID, change)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(payload) > maxNotifyPayloadSize || strings.Contains(payload, "attrs") {
+		t.Errorf("Unexpected payload: %s", payload)
+	}
+
+	// The dropped attributes are fetched from the changelog by the change number
+	var fetched int64
+	other.fetchDeletedAttrs = func(changeNumber int64) (map[string][]string, error) {
+		fetched = changeNumber
+		return change.Attrs, nil
+	}
+	other.receive(payload)
+
+	select {
+	case received := <-ch:
+		if fetched != change.ChangeNumber || received.ChangeNumber != change.ChangeNumber ||
+			!reflect.DeepEqual(received.Attrs, change.Attrs) {
+			t.Errorf("Unexpected change: expected %v, got %v", change, received)
+		}
+	default:
+		t.Errorf("Expected the change from the other instance")
+	}
+
+	// The attributes are unavailable if they aren't recorded in the changelog
+	other.fetchDeletedAttrs = func(changeNumber int64) (map[string][]string, error) {
+		return nil, xerrors.Errorf("not found")
+	}
+	other.receive(payload)
+
+	select {
+	case received := <-ch:
+		if received.Attrs != nil {
+			t.Errorf("Unexpected attributes: %v", received.Attrs)
+		}
+	default:
+		t.Errorf("Expected the change from the other instance")
+	}
+}
+
+func TestPSearchBrokerPublish(t *testing.T) {
+	b := newPSearchBroker("instance1")
+	ch := b.subscribe()
+
+	for i := 0; i < psearchQueueSize; i++ {
+		b.publish(&ChangeRecord{ChangeType: "modify"})
+	}
+	if len(ch) != psearchQueueSize {
+		t.Fatalf("Unexpected queue size: %d", len(ch))
+	}
+
+	// The full queue is closed
+	b.publish(&ChangeRecord{ChangeType: "modify"})
+	for i := 0; i < psearchQueueSize; i++ {
+		<-ch
+	}
+	if _, ok := <-ch; ok {
+		t.Errorf("Expected the closed queue")
+	}
+
+	// Unsubscribing the closed queue doesn't panic
+	b.unsubscribe(ch)
+
+	// nil broker is disabled
+	var disabled *psearchBroker
+	disabled.publish(&ChangeRecord{ChangeType: "modify"})
+}
+
+func TestPSearchChangeType(t *testing.T) {
+	testcases := map[string]int{
+		"add":     PSearchChangeAdd,
+		"delete":  PSearchChangeDelete,
+		"modify":  PSearchChangeModify,
+		"modrdn":  PSearchChangeModDN,
+		"unknown": 0,
+	}
+	for changeType, expected := range testcases {
+		if got := psearchChangeType(changeType); got != expected {
+			t.Errorf("Unexpected change type of %s: expected %d, got %d", changeType, expected, got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package ldap_pg
+
+import (
+	"context"
+	"log"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+)
+
+// ReadEntryControls is the pre-read and post-read controls of the request.
+// The repository captures the entries before and after the change in the transaction of the change.
+// https://tools.ietf.org/html/rfc4527
+type ReadEntryControls struct {
+	PreRead  *ReadEntryControl
+	PostRead *ReadEntryControl
+}
+
+type ReadEntryControl struct {
+	Attrs message.AttributeSelection
+	// Entry is captured by the repository, nil until the change is done
+	Entry *SearchEntry
+}
+
+// SetReadEntryContext returns the context which has the pre-read and post-read controls if the request has them.
+// It returns the protocol error if the controls are invalid.
+func SetReadEntryContext(parents context.Context, m *ldap.Message) (context.Context, *ReadEntryControls, error) {
+	var controls *ReadEntryControls
+
+	for _, controlType := range []string{PreReadControlOID, PostReadControlOID} {
+		con, ok := findControl(m.Controls(), controlType)
+		if !ok {
+			continue
+		}
+
+		attrs, err := parseReadEntryControl(con)
+		if err != nil {
+			log.Printf("warn: Invalid read entry control. err: %v", err)
+			return nil, nil, NewProtocolError("invalid read entry control")
+		}
+
+		if controls == nil {
+			controls = &ReadEntryControls{}
+		}
+		if controlType == PreReadControlOID {
+			controls.PreRead = &ReadEntryControl{Attrs: attrs}
+		} else {
+			controls.PostRead = &ReadEntryControl{Attrs: attrs}
+		}
+	}
+
+	if controls == nil {
+		return parents, nil, nil
+	}
+	return context.WithValue(parents, readEntryContextKey, controls), controls, nil
+}
+
+// readEntryContext returns the pre-read and post-read controls, nil if the request doesn't have them.
+func readEntryContext(ctx context.Context) *ReadEntryControls {
+	controls, _ := ctx.Value(readEntryContextKey).(*ReadEntryControls)
+	return controls
+}
+
+func (c *ReadEntryControls) preRead() *ReadEntryControl {
+	if c == nil {
+		return nil
+	}
+	return c.PreRead
+}
+
+func (c *ReadEntryControls) postRead() *ReadEntryControl {
+	if c == nil {
+		return nil
+	}
+	return c.PostRead
+}
+
+// withReadEntryControls returns the writer which attaches the captured entries as the response controls.
+// The entries have only the selected attributes readable by the requester.
+func withReadEntryControls(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, controls *ReadEntryControls) ldap.ResponseWriter {
+	if controls == nil {
+		return w
+	}
+
+	var resControls message.Controls
+	for _, c := range []struct {
+		controlType string
+		control     *ReadEntryControl
+	}{
+		{PreReadControlOID, controls.PreRead},
+		{PostReadControlOID, controls.PostRead},
+	} {
+		if c.control == nil || c.control.Entry == nil {
+			continue
+		}
+
+		dnOrig := c.control.Entry.DNOrig()
+		e, ok := newSearchResultEntry(ctx, s, m, c.control.Attrs, dnOrig, c.control.Entry)
+		if !ok {
+			// Don't disclose anything of the entry which isn't readable
+			e = ldap.NewSearchResultEntry(dnOrig)
+		}
+
+		con, err := newReadEntryControl(c.controlType, e)
+		if err != nil {
+			log.Printf("warn: Failed to create read entry control, ignore. dn: %s, err: %+v", dnOrig, err)
+			continue
+		}
+		resControls = append(resControls, con)
+	}
+
+	if len(resControls) == 0 {
+		return w
+	}
+	return &controlsResponseWriter{
+		ResponseWriter: w,
+		controls:       resControls,
+	}
+}
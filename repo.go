@@ -2,12 +2,14 @@ package ldap_pg
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
 	"github.com/openstandia/goldap/message"
 )
 
@@ -33,6 +35,8 @@ func (m *StmtCache) Put(key string, value *sqlx.NamedStmt) {
 type DBRepository struct {
 	server *Server
 	db     *sqlx.DB
+	// url is used for the dedicated connection of LISTEN
+	url string
 }
 
 func NewRepository(server *Server) (Repository, error) {
@@ -52,6 +56,7 @@ func NewRepository(server *Server) (Repository, error) {
 	log.Printf("info: %s", url)
 	db.SetMaxOpenConns(server.config.DBMaxOpenConns)
 	db.SetMaxIdleConns(server.config.DBMaxIdleConns)
+	registerDBStatsMetrics(db.DB, server.config.DBName)
 	// db.SetConnMaxLifetime(time.Hour)
 
 	// TODO: Enable to switch another implementation
@@ -59,6 +64,7 @@ func NewRepository(server *Server) (Repository, error) {
 		DBRepository: &DBRepository{
 			server: server,
 			db:     db,
+			url:    url,
 		},
 		translator: &HybridDBFilterTranslator{},
 	}
@@ -88,6 +94,11 @@ type Repository interface {
 	// This is used for SEARCH operation.
 	Search(ctx context.Context, baseDN *DN, option *SearchOption, handler func(entry *SearchEntry) error) (int32, int32, error)
 
+	// FindByDN returns the entry by specified DN.
+	// The option is used for fetching association attributes and so on.
+	// This is used for COMPARE operation.
+	FindByDN(ctx context.Context, dn *DN, option *SearchOption) (*SearchEntry, error)
+
 	// Update modifies the entry by specified change data.
 	// This is used for MOD operation.
 	Update(ctx context.Context, dn *DN, callback func(current *ModifyEntry) error) error
@@ -101,6 +112,26 @@ type Repository interface {
 
 	// DeleteByDN deletes the entry by specified DN.
 	DeleteByDN(ctx context.Context, dn *DN) error
+
+	// DeleteTreeByDN deletes the entry and all its descendants by specified DN.
+	// The callback is called with the DNs and the entries to be deleted before the deletion.
+	// This is used for DEL operation with the tree delete control.
+	DeleteTreeByDN(ctx context.Context, dn *DN, callback func(dns []*DN, entries []*SearchEntry) error) error
+
+	// SearchChangelog fetches the changelog entries in the range of the change number by ascending order.
+	// This is used for SEARCH operation under cn=changelog.
+	SearchChangelog(ctx context.Context, option *ChangelogSearchOption, handler func(entry *ChangelogEntry) error) error
+
+	// ChangeNumberRange returns the first and the last change number of the changelog, they are 0 if it's empty.
+	ChangeNumberRange(ctx context.Context) (int64, int64, error)
+
+	// PurgeChangelog deletes the changelog entries older than the specified time.
+	PurgeChangelog(ctx context.Context, before time.Time) (int64, error)
+
+	// ListenChanges starts receiving the changes notified by all instances including this one.
+	// The handler is called with the payload of the notification.
+	// This is used for the persistent search.
+	ListenChanges(handler func(payload string)) error
 }
 
 type SearchOption struct {
@@ -111,6 +142,50 @@ type SearchOption struct {
 	RequestedAssocation        []string
 	IsMemberOfRequested        bool
 	IsHasSubordinatesRequested bool
+	SortKeys                   []*SortKey
+	VLV                        *VLVOption
+}
+
+// VLVOption is the requested window for virtual list view control.
+// The target is specified by Offset/ContentCount or AssertionValue.
+// The repository sets the resolved target position and content count in ResultTargetPosition and ResultContentCount.
+type VLVOption struct {
+	BeforeCount    int32
+	AfterCount     int32
+	Offset         int32
+	ContentCount   int32
+	AssertionValue *SchemaValue
+
+	ResultTargetPosition int32
+	ResultContentCount   int32
+}
+
+// ChangelogSearchOption is the range of the change number, both are inclusive.
+// 0 means no limit.
+type ChangelogSearchOption struct {
+	FirstChangeNumber int64
+	LastChangeNumber  int64
+}
+
+// ChangelogEntry is the record of ldap_changelog table.
+type ChangelogEntry struct {
+	ChangeNumber int64          `db:"change_number"`
+	ChangeTime   time.Time      `db:"change_time"`
+	TargetDN     string         `db:"target_dn"`
+	EntryUUID    string         `db:"entry_uuid"`
+	ChangeType   string         `db:"change_type"`
+	Changes      sql.NullString `db:"changes"`
+	NewRDN       sql.NullString `db:"new_rdn"`
+	DeleteOldRDN sql.NullBool   `db:"delete_old_rdn"`
+	NewSuperior  sql.NullString `db:"new_superior"`
+	// DeletedAttrs is the deleted entry for delete, the values of the password attributes are masked
+	DeletedAttrs types.JSONText `db:"deleted_attrs"`
+}
+
+// SortKey is the resolved sort key for server side sort control.
+type SortKey struct {
+	AttributeType *AttributeType
+	ReverseOrder  bool
 }
 
 type FetchedDNOrig struct {
@@ -129,4 +204,9 @@ type FetchedCredential struct {
 	PwdAccountLockedTime *time.Time
 	LastPwdFailureTime   *time.Time
 	PwdFailureCount      int
+	PwdChangedTime       *time.Time
+	PwdGraceUseCount     int
+	PwdReset             bool
+	// RehashedCredential is set by the bind logic to store the credential re-hashed with the preferred scheme
+	RehashedCredential []string
 }
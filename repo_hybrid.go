@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -16,6 +17,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
 	"github.com/openstandia/goldap/message"
 	"golang.org/x/xerrors"
 )
@@ -49,14 +51,36 @@ var (
 	deleteAllAssociationByIDStmt *sqlx.NamedStmt
 	hasSubStmt                   *sqlx.NamedStmt
 
+	// repo_delete for tree delete
+	findSubtreeByIDWithUpdateLock *sqlx.NamedStmt
+	findGroupsByMemberIDsStmt     *sqlx.NamedStmt
+	deleteByIDsStmt               *sqlx.NamedStmt
+	deleteContainerByIDsStmt      *sqlx.NamedStmt
+	deleteAssociationByIDsStmt    *sqlx.NamedStmt
+
 	// repo_read for bind
 	findCredByDN *sqlx.NamedStmt
 	// repo_update for bind
 	updateAfterBindSuccessByDN *sqlx.NamedStmt
 	updateAfterBindFailureByDN *sqlx.NamedStmt
+	updateGraceUseTimeByDN     *sqlx.NamedStmt
+	updateCredentialByDN       *sqlx.NamedStmt
 
 	// repo_read for ppolicy
 	findPPolicyByDN *sqlx.NamedStmt
+
+	// repo_insert for audit
+	insertAuditStmt *sqlx.NamedStmt
+
+	// repo for changelog
+	insertChangelogStmt       *sqlx.NamedStmt
+	findChangelogStmt         *sqlx.NamedStmt
+	findChangeNumberRangeStmt *sqlx.NamedStmt
+	purgeChangelogStmt        *sqlx.NamedStmt
+
+	// repo for change notification
+	findAttrsOrigByIDStmt *sqlx.NamedStmt
+	notifyChangeStmt      *sqlx.NamedStmt
 )
 
 func (r *HybridRepository) Init() error {
@@ -113,6 +137,10 @@ func (r *HybridRepository) Init() error {
 		e.attrs_orig->'userPassword' AS credential,
 		e.attrs_orig->'pwdAccountLockedTime' AS locked_time,
 		e.attrs_orig->'pwdFailureTime' AS failure_time,
+		e.attrs_orig->'pwdChangedTime' AS changed_time,
+		e.attrs_orig->'pwdGraceUseTime' AS grace_use_time,
+		e.attrs_orig->'pwdReset' AS reset,
+		e.attrs_orig->'pwdPolicySubentry' AS ppolicy_subentry,
 		memberOf.memberOf AS memberof,
 		dpp.attrs_orig AS default_ppolicy
 	FROM
@@ -153,6 +181,22 @@ func (r *HybridRepository) Init() error {
 		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
 	}
 
+	updateGraceUseTimeByDN, err = db.PrepareNamed(`UPDATE ldap_entry SET
+	attrs_norm = attrs_norm || jsonb_build_object('pwdGraceUseTime', :grace_use_time_norm ::::jsonb),
+	attrs_orig = attrs_orig || jsonb_build_object('pwdGraceUseTime', :grace_use_time_orig ::::jsonb)
+	WHERE id = :id`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	updateCredentialByDN, err = db.PrepareNamed(`UPDATE ldap_entry SET
+	attrs_norm = attrs_norm || jsonb_build_object('userPassword', :credential_norm ::::jsonb),
+	attrs_orig = attrs_orig || jsonb_build_object('userPassword', :credential_orig ::::jsonb)
+	WHERE id = :id`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
 	findPPolicyByDN, err = db.PrepareNamed(`SELECT
 		e.id,
 		e.attrs_orig AS ppolicy
@@ -309,6 +353,106 @@ func (r *HybridRepository) Init() error {
 		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
 	}
 
+	// The descendants are ordered from the deepest
+	findSubtreeByIDWithUpdateLock, err = db.PrepareNamed(`WITH RECURSIVE subtree AS (
+		SELECT id, 0 AS depth FROM ldap_entry WHERE id = :id
+		UNION ALL
+		SELECT ce.id, st.depth + 1 FROM ldap_entry ce INNER JOIN subtree st ON ce.parent_id = st.id
+	)
+	SELECT
+		e.id,
+		e.rdn_orig || ',' || dnc.dn_orig AS dn_orig,
+		e.attrs_orig,
+		memberOf.memberOf AS memberof
+	FROM
+		subtree st
+		INNER JOIN ldap_entry e ON e.id = st.id
+		LEFT JOIN ldap_container dnc ON e.parent_id = dnc.id
+		LEFT JOIN LATERAL (
+			SELECT jsonb_agg(ae.rdn_orig || ',' || ac.dn_orig) AS memberOf
+			FROM ldap_association a, ldap_entry ae, ldap_container ac
+			WHERE e.id = a.member_id AND ae.id = a.id AND ac.id = ae.parent_id
+		) AS memberOf ON true
+	ORDER BY st.depth DESC, e.id
+	FOR UPDATE OF e
+	`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	findGroupsByMemberIDsStmt, err = db.PrepareNamed(`SELECT
+		a.name,
+		a.member_id,
+		e.rdn_orig || ',' || dnc.dn_orig AS dn_orig,
+		COALESCE(e.attrs_orig->'entryUUID'->>0, '') AS entry_uuid
+	FROM
+		ldap_association a
+		INNER JOIN ldap_entry e ON e.id = a.id
+		LEFT JOIN ldap_container dnc ON e.parent_id = dnc.id
+	WHERE
+		a.member_id = ANY(:ids) AND a.id <> ALL(:ids)
+	ORDER BY a.id, a.name, a.member_id
+	`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	deleteByIDsStmt, err = db.PrepareNamed(`DELETE FROM ldap_entry WHERE id = ANY(:ids)`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	deleteContainerByIDsStmt, err = db.PrepareNamed(`DELETE FROM ldap_container WHERE id = ANY(:ids)`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	deleteAssociationByIDsStmt, err = db.PrepareNamed(`DELETE FROM ldap_association WHERE id = ANY(:ids) OR member_id = ANY(:ids)`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	findAttrsOrigByIDStmt, err = db.PrepareNamed(`SELECT attrs_orig FROM ldap_entry WHERE id = :id`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	notifyChangeStmt, err = db.PrepareNamed(`SELECT pg_notify(:channel, :payload)`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	if r.server.config.AuditDB {
+		_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS ldap_audit (
+			id BIGSERIAL PRIMARY KEY,
+			created TIMESTAMP WITH TIME ZONE NOT NULL,
+			actor VARCHAR(512) NOT NULL,
+			addr VARCHAR(64) NOT NULL,
+			dn_norm VARCHAR(512) NOT NULL,
+			changetype VARCHAR(16) NOT NULL,
+			ldif TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_ldap_audit_created ON ldap_audit (created);
+		CREATE INDEX IF NOT EXISTS idx_ldap_audit_dn_norm ON ldap_audit (dn_norm);
+		`)
+		if err != nil {
+			return xerrors.Errorf("Failed to initialize ldap_audit table: %w", err)
+		}
+
+		insertAuditStmt, err = db.PrepareNamed(`INSERT INTO ldap_audit (created, actor, addr, dn_norm, changetype, ldif)
+		VALUES (:created, :actor, :addr, :dn_norm, :changetype, :ldif)`)
+		if err != nil {
+			return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+		}
+	}
+
+	if r.server.config.Changelog {
+		if err := r.initChangelog(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -320,6 +464,7 @@ type HybridDBEntry struct {
 	AttrsNorm types.JSONText `db:"attrs_norm"`
 	AttrsOrig types.JSONText `db:"attrs_orig"`
 	ParentDN  *DN
+	EntryUUID string
 }
 
 //////////////////////////////////////////
@@ -370,6 +515,22 @@ func (r *HybridRepository) Insert(ctx context.Context, entry *AddEntry) (int64,
 		return 0, err
 	}
 
+	if err := r.captureReadEntry(tx, readEntryContext(ctx).postRead(), entry.DN()); err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	change := newChangeRecord(ctx, r.server, "add", entry.DN())
+	if change != nil {
+		_, attrsOrig := entry.Attrs()
+		change.Attrs = maskAttrs(attrsOrig)
+		change.EntryUUID = dbEntry.EntryUUID
+	}
+	if err := r.recordChange(tx, change); err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
 	if err := commit(tx); err != nil {
 		log.Printf("error: Failed to commit insert. dn_norm: %s, newID: %d, err: %v", entry.DN().DNNormStr(), newID, err)
 		return 0, err
@@ -377,6 +538,9 @@ func (r *HybridRepository) Insert(ctx context.Context, entry *AddEntry) (int64,
 
 	log.Printf("info: Added. id: %d, dn_norm: %s", newID, entry.DN().DNNormStr())
 
+	r.server.auditLog.write(change)
+	r.server.psearch.publish(change)
+
 	return newID, nil
 }
 
@@ -524,6 +688,16 @@ func (r *HybridRepository) Update(ctx context.Context, dn *DN, callback func(cur
 		return err
 	}
 
+	if err := r.checkAssertion(ctx, tx, oID, dn); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	if err := r.captureReadEntry(tx, readEntryContext(ctx).preRead(), dn); err != nil {
+		rollback(tx)
+		return err
+	}
+
 	newEntry, err := NewModifyEntry(r.server.schemaMap, dn, oJSONMap)
 	if err != nil {
 		rollback(tx)
@@ -629,6 +803,21 @@ func (r *HybridRepository) Update(ctx context.Context, dn *DN, callback func(cur
 		}
 	}
 
+	if err := r.captureReadEntry(tx, readEntryContext(ctx).postRead(), dn); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	change := newChangeRecord(ctx, r.server, "modify", dn)
+	if change != nil {
+		change.Changes = newEntry.changes
+		change.EntryUUID = entryUUIDOf(oJSONMap)
+	}
+	if err := r.recordChange(tx, change); err != nil {
+		rollback(tx)
+		return err
+	}
+
 	if err := commit(tx); err != nil {
 		log.Printf("error: Failed to commit update. dn_norm: %s, err: %v", dn.DNNormStr(), err)
 		return err
@@ -636,6 +825,9 @@ func (r *HybridRepository) Update(ctx context.Context, dn *DN, callback func(cur
 
 	log.Printf("info: Updated. id: %d, dn_norm: %s", oID, dn.DNNormStr())
 
+	r.server.auditLog.write(change)
+	r.server.psearch.publish(change)
+
 	return nil
 }
 
@@ -711,6 +903,75 @@ func (r *HybridRepository) findByDNForUpdate(tx *sqlx.Tx, dn *DN, fetchAssociati
 	return dest.ID, dest.ParentID, dest.RDNOrig, jsonMap, dest.HasSub, nil
 }
 
+// checkAssertion evaluates the filter of the assertion control against the locked entry.
+// It returns assertionFailed error if the filter doesn't match.
+func (r *HybridRepository) checkAssertion(ctx context.Context, tx *sqlx.Tx, id int64, dn *DN) error {
+	filter := assertionContext(ctx)
+	if filter == nil {
+		return nil
+	}
+
+	var jsb, wsb strings.Builder
+	params := map[string]interface{}{
+		"id": id,
+	}
+	result := &HybridDBFilterTranslatorResult{
+		join:   &jsb,
+		where:  &wsb,
+		params: params,
+	}
+
+	if err := r.translator.translate(r.server.schemaMap, filter, result, false); err != nil {
+		return xerrors.Errorf("Failed to translate the assertion filter. dn_norm: %s, err: %w", dn.DNNormStr(), err)
+	}
+	if wsb.Len() == 0 {
+		wsb.WriteString(`TRUE`)
+	}
+
+	q := fmt.Sprintf(`SELECT EXISTS (
+		SELECT 1 FROM ldap_entry e
+		%s
+		WHERE e.id = :id AND (%s)
+	) AS matched`, jsb.String(), wsb.String())
+
+	rows, err := r.namedQuery(tx, q, params)
+	if err != nil {
+		return xerrors.Errorf("Failed to evaluate the assertion filter. dn_norm: %s, err: %w", dn.DNNormStr(), err)
+	}
+	defer rows.Close()
+
+	var matched bool
+	if rows.Next() {
+		if err := rows.Scan(&matched); err != nil {
+			return xerrors.Errorf("Unexpected scan error of the assertion filter. dn_norm: %s, err: %w", dn.DNNormStr(), err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return xerrors.Errorf("Failed to evaluate the assertion filter. dn_norm: %s, err: %w", dn.DNNormStr(), err)
+	}
+
+	if !matched {
+		log.Printf("info: The assertion filter doesn't match. dn_norm: %s", dn.DNNormStr())
+		return NewAssertionFailed()
+	}
+	return nil
+}
+
+// captureReadEntry fetches the entry in the transaction if the pre-read or post-read control is requested.
+func (r *HybridRepository) captureReadEntry(tx *sqlx.Tx, control *ReadEntryControl, dn *DN) error {
+	if control == nil {
+		return nil
+	}
+
+	_, _, _, attrsOrig, _, err := r.findByDNForUpdate(tx, dn, true)
+	if err != nil {
+		return err
+	}
+	control.Entry = NewSearchEntry(r.server.schemaMap, dn.DNOrigStr(), attrsOrig)
+
+	return nil
+}
+
 // oldRDN: set when keeping current entry
 func (r *HybridRepository) UpdateDN(ctx context.Context, oldDN, newDN *DN, oldRDN *RelativeDN) error {
 	tx, err := r.begin(ctx)
@@ -725,6 +986,16 @@ func (r *HybridRepository) UpdateDN(ctx context.Context, oldDN, newDN *DN, oldRD
 		return err
 	}
 
+	if err := r.checkAssertion(ctx, tx, oID, oldDN); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	if err := r.captureReadEntry(tx, readEntryContext(ctx).preRead(), oldDN); err != nil {
+		rollback(tx)
+		return err
+	}
+
 	entry, err := NewModifyEntry(r.server.schemaMap, oldDN, attrsOrig)
 	if err != nil {
 		rollback(tx)
@@ -747,6 +1018,25 @@ func (r *HybridRepository) UpdateDN(ctx context.Context, oldDN, newDN *DN, oldRD
 		return err
 	}
 
+	if err := r.captureReadEntry(tx, readEntryContext(ctx).postRead(), newDN); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	change := newChangeRecord(ctx, r.server, "modrdn", oldDN)
+	if change != nil {
+		change.NewRDN = newDN.RDNOrigEncodedStr()
+		change.DeleteOldRDN = oldRDN == nil
+		change.EntryUUID = entryUUIDOf(attrsOrig)
+		if !oldDN.ParentDN().Equal(newDN.ParentDN()) {
+			change.NewSuperior = newDN.ParentDN().DNOrigStr()
+		}
+	}
+	if err := r.recordChange(tx, change); err != nil {
+		rollback(tx)
+		return err
+	}
+
 	if err := commit(tx); err != nil {
 		log.Printf("error: Failed to commit update. id: %d, old_dn_norm: %s, new_dn_norm: %s, err: %v", oID, oldDN.DNNormStr(), newDN.DNNormStr(), err)
 		return err
@@ -754,6 +1044,9 @@ func (r *HybridRepository) UpdateDN(ctx context.Context, oldDN, newDN *DN, oldRD
 
 	log.Printf("info: Updated DN. id: %d, old_dn_norm: %s, new_dn_norm: %s", oID, oldDN.DNNormStr(), newDN.DNNormStr())
 
+	r.server.auditLog.write(change)
+	r.server.psearch.publish(change)
+
 	return nil
 }
 
@@ -962,12 +1255,42 @@ func (r HybridRepository) DeleteByDN(ctx context.Context, dn *DN) error {
 		return xerrors.Errorf("Unexpected query error. dn_norm: %v, err: %w", dn.DNNormStr(), err)
 	}
 
+	if err := r.checkAssertion(ctx, tx, fetchedEntry.ID, dn); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	if err := r.captureReadEntry(tx, readEntryContext(ctx).preRead(), dn); err != nil {
+		rollback(tx)
+		return err
+	}
+
 	// Not allowed error if the entry has children yet
 	if fetchedEntry.HasSub {
 		rollback(tx)
 		return NewNotAllowedOnNonLeaf()
 	}
 
+	change := newChangeRecord(ctx, r.server, "delete", dn)
+	if change != nil {
+		// The deleted entry is used for entryUUID of the changelog and the persistent search
+		attrsOrig, err := r.findAttrsOrigByID(tx, fetchedEntry.ID)
+		if err != nil {
+			rollback(tx)
+			return err
+		}
+		change.Attrs = maskAttrs(attrsOrig)
+		change.EntryUUID = entryUUIDOf(attrsOrig)
+	}
+
+	groupChanges, err := r.memberRemovalChanges(ctx, tx, map[int64]string{
+		fetchedEntry.ID: dn.DNOrigStr(),
+	})
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+
 	// Step 2: Remove all association
 	err = r.removeAssociationById(tx, fetchedEntry.ID)
 	if err != nil {
@@ -999,6 +1322,14 @@ func (r HybridRepository) DeleteByDN(ctx context.Context, dn *DN) error {
 		}
 	}
 
+	changes := append([]*ChangeRecord{change}, groupChanges...)
+	for _, change := range changes {
+		if err := r.recordChange(tx, change); err != nil {
+			rollback(tx)
+			return err
+		}
+	}
+
 	if err := commit(tx); err != nil {
 		log.Printf("error: Failed to commit deletion. dn_norm: %s, err: %v", dn.DNNormStr(), err)
 		return err
@@ -1006,9 +1337,244 @@ func (r HybridRepository) DeleteByDN(ctx context.Context, dn *DN) error {
 
 	log.Printf("info: Deleted. id: %d, dn_norm: %s", fetchedEntry.ID, dn.DNNormStr())
 
+	for _, change := range changes {
+		r.server.auditLog.write(change)
+		r.server.psearch.publish(change)
+	}
+
 	return nil
 }
 
+// DeleteTreeByDN deletes the entry and all its descendants in one transaction.
+// The callback is called with the DNs and the entries to be deleted, they're ordered from the deepest.
+// The changes are recorded as the deletion of each entry, and the modification of the groups outside of the subtree
+// which lose the members.
+func (r *HybridRepository) DeleteTreeByDN(ctx context.Context, dn *DN, callback func(dns []*DN, entries []*SearchEntry) error) error {
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Step 1: fetch the target entry and parent container with lock for share
+	fetchedEntry := struct {
+		ID       int64 `db:"id"`
+		ParentID int64 `db:"parent_id"`
+		HasSub   bool  `db:"has_sub"`
+	}{}
+
+	err = r.get(tx, findEntryIDByDNWithShareLock, &fetchedEntry, map[string]interface{}{
+		"rdn_norm":       dn.RDNNormStr(),
+		"parent_dn_norm": dn.ParentDN().DNNormStrWithoutSuffix(r.server.Suffix),
+	})
+	if err != nil {
+		rollback(tx)
+
+		if isNoResult(err) {
+			return NewNoSuchObject()
+		}
+		return xerrors.Errorf("Unexpected query error. dn_norm: %v, err: %w", dn.DNNormStr(), err)
+	}
+
+	if err := r.checkAssertion(ctx, tx, fetchedEntry.ID, dn); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	if err := r.captureReadEntry(tx, readEntryContext(ctx).preRead(), dn); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	// Step 2: fetch the subtree with update lock
+	var subtree []struct {
+		ID           int64          `db:"id"`
+		DNOrig       string         `db:"dn_orig"`
+		RawAttrsOrig types.JSONText `db:"attrs_orig"`
+		RawMemberOf  types.JSONText `db:"memberof"`
+	}
+	err = r.selectAll(tx, findSubtreeByIDWithUpdateLock, &subtree, map[string]interface{}{
+		"id": fetchedEntry.ID,
+	})
+	if err != nil {
+		rollback(tx)
+
+		if isDeadlockError(err) {
+			log.Printf("warn: Detected deadlock for tree delete. dn_norm: %s, err: %v", dn.DNNormStr(), err)
+			return NewRetryError(err)
+		}
+		return xerrors.Errorf("Failed to fetch the subtree. dn_norm: %s, err: %w", dn.DNNormStr(), err)
+	}
+
+	ids := make([]int64, len(subtree))
+	dns := make([]*DN, len(subtree))
+	entries := make([]*SearchEntry, len(subtree))
+	dnOrigs := make(map[int64]string, len(subtree))
+	changes := make([]*ChangeRecord, len(subtree))
+
+	for i, e := range subtree {
+		ids[i] = e.ID
+		dnOrig := resolveSuffix(r.server, e.DNOrig)
+		dns[i], err = r.server.NormalizeDN(dnOrig)
+		if err != nil {
+			rollback(tx)
+			return xerrors.Errorf("Invalid DN of the subtree entry. id: %d, dn_orig: %s, err: %w", e.ID, e.DNOrig, err)
+		}
+		dnOrigs[e.ID] = dnOrig
+
+		attrsOrig := map[string][]string{}
+		if err := e.RawAttrsOrig.Unmarshal(&attrsOrig); err != nil {
+			rollback(tx)
+			return xerrors.Errorf("Unexpected unmarshal error. id: %d, err: %w", e.ID, err)
+		}
+
+		changes[i] = newChangeRecord(ctx, r.server, "delete", dns[i])
+		if changes[i] != nil {
+			// The deleted entry is used for entryUUID of the changelog and the persistent search
+			changes[i].Attrs = maskAttrs(attrsOrig)
+			changes[i].EntryUUID = entryUUIDOf(attrsOrig)
+		}
+
+		// The entry with memberOf is used for the filter of the access rules
+		if len(e.RawMemberOf) > 0 {
+			memberOf := []string{}
+			if err := e.RawMemberOf.Unmarshal(&memberOf); err != nil {
+				rollback(tx)
+				return xerrors.Errorf("Unexpected unmarshal error. id: %d, err: %w", e.ID, err)
+			}
+			attrsOrig["memberOf"] = memberOf
+			r.resolveDNSuffix(attrsOrig, "memberOf")
+		}
+		entries[i] = NewSearchEntry(r.server.schemaMap, dnOrig, attrsOrig)
+	}
+
+	// Check the access to all entries of the subtree.
+	// It must not fetch the entries by another connection while they're locked.
+	if err := callback(dns, entries); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	groupChanges, err := r.memberRemovalChanges(ctx, tx, dnOrigs)
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	changes = append(changes, groupChanges...)
+
+	params := map[string]interface{}{
+		"ids": pq.Array(ids),
+	}
+
+	// Step 3: Remove all association of the subtree
+	if _, err := r.exec(tx, deleteAssociationByIDsStmt, params); err != nil {
+		rollback(tx)
+		return xerrors.Errorf("Failed to delete association of the subtree. dn_norm: %s, err: %w", dn.DNNormStr(), err)
+	}
+
+	// Step 4: Delete entries, then the containers of them
+	if _, err := r.exec(tx, deleteByIDsStmt, params); err != nil {
+		rollback(tx)
+		return xerrors.Errorf("Failed to delete the subtree. dn_norm: %s, err: %w", dn.DNNormStr(), err)
+	}
+	if _, err := r.exec(tx, deleteContainerByIDsStmt, params); err != nil {
+		rollback(tx)
+		return xerrors.Errorf("Failed to delete containers of the subtree. dn_norm: %s, err: %w", dn.DNNormStr(), err)
+	}
+
+	// Step 5: Delete container if the parent doesn't have children
+	hasSub, err := r.hasSub(tx, fetchedEntry.ParentID)
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+
+	if !hasSub {
+		if err := r.deleteContainerByID(tx, fetchedEntry.ParentID); err != nil {
+			if !isNoResult(err) {
+				rollback(tx)
+				return err
+			}
+			// Other threads inserted sub. Ignore the error.
+		}
+	}
+
+	for _, change := range changes {
+		if err := r.recordChange(tx, change); err != nil {
+			rollback(tx)
+			return err
+		}
+	}
+
+	if err := commit(tx); err != nil {
+		log.Printf("error: Failed to commit tree deletion. dn_norm: %s, err: %v", dn.DNNormStr(), err)
+		return err
+	}
+
+	log.Printf("info: Deleted tree. id: %d, dn_norm: %s, num: %d", fetchedEntry.ID, dn.DNNormStr(), len(ids))
+
+	for _, change := range changes {
+		r.server.auditLog.write(change)
+		r.server.psearch.publish(change)
+	}
+
+	return nil
+}
+
+// memberRemovalChanges returns the change records of the groups which lose the members by deleting the entries.
+// The deleted entries are specified by the map of the ID and the original DN,
+// the groups in the deleted entries are excluded since they're recorded as the deletion.
+func (r *HybridRepository) memberRemovalChanges(ctx context.Context, tx *sqlx.Tx, dnOrigs map[int64]string) ([]*ChangeRecord, error) {
+	if !r.server.changeRecordEnabled() {
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, len(dnOrigs))
+	for id := range dnOrigs {
+		ids = append(ids, id)
+	}
+
+	var groups []struct {
+		Name      string `db:"name"`
+		MemberID  int64  `db:"member_id"`
+		DNOrig    string `db:"dn_orig"`
+		EntryUUID string `db:"entry_uuid"`
+	}
+	if err := r.selectAll(tx, findGroupsByMemberIDsStmt, &groups, map[string]interface{}{
+		"ids": pq.Array(ids),
+	}); err != nil {
+		return nil, xerrors.Errorf("Failed to fetch the groups of the deleted entries. ids: %v, err: %w", ids, err)
+	}
+
+	var changes []*ChangeRecord
+	var change *ChangeRecord
+	var modify *ModifyChange
+
+	// The groups are ordered by the ID and the association name
+	for _, g := range groups {
+		dnOrig := resolveSuffix(r.server, g.DNOrig)
+		if change == nil || change.DN != dnOrig {
+			dn, err := r.server.NormalizeDN(dnOrig)
+			if err != nil {
+				return nil, xerrors.Errorf("Invalid DN of the group. dn_orig: %s, err: %w", g.DNOrig, err)
+			}
+			change = newChangeRecord(ctx, r.server, "modify", dn)
+			change.EntryUUID = g.EntryUUID
+			changes = append(changes, change)
+			modify = nil
+		}
+		if modify == nil || modify.Attr != g.Name {
+			modify = &ModifyChange{
+				Op:   "delete",
+				Attr: g.Name,
+			}
+			change.Changes = append(change.Changes, modify)
+		}
+		modify.Values = append(modify.Values, dnOrigs[g.MemberID])
+	}
+
+	return changes, nil
+}
+
 func (r *HybridRepository) hasSub(tx *sqlx.Tx, id int64) (bool, error) {
 	var hasSub bool
 	if err := r.get(tx, hasSubStmt, &hasSub, map[string]interface{}{
@@ -1162,6 +1728,18 @@ func (r *HybridRepository) Search(ctx context.Context, baseDN *DN, option *Searc
 	r.collectScopeWhereSQL(baseDN, option, &scopeWhere, params)
 	r.collectFilterWhereSQL(baseDN, option, &filterJoin, &filterWhere, params)
 
+	// Sort
+	var orderBy strings.Builder
+	r.collectOrderBySQL(option, &orderBy, params)
+
+	// Virtual list view
+	if option.VLV != nil {
+		err = r.resolveVLVWindow(tx, option, strings.Join(filterJoin, ""), scopeWhere.String(), strings.Join(filterWhere, " AND "), params)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
 	// Projection(Association etc.)
 	var proj strings.Builder
 	var join strings.Builder
@@ -1188,7 +1766,7 @@ func (r *HybridRepository) Search(ctx context.Context, baseDN *DN, option *Searc
 			AND
 			-- ldap filter
 			(%s)
-		ORDER BY e.id
+		ORDER BY %s
 		LIMIT :pageSize OFFSET :offset
 	)
 SELECT
@@ -1201,7 +1779,7 @@ SELECT
 FROM
 	filtered_entry fe
 %s
-	`, strings.Join(filterJoin, ""), scopeWhere.String(), strings.Join(filterWhere, " AND "), proj.String(), join.String())
+	`, strings.Join(filterJoin, ""), scopeWhere.String(), strings.Join(filterWhere, " AND "), orderBy.String(), proj.String(), join.String())
 
 	start := time.Now()
 	rows, err := r.namedQuery(tx, q, params)
@@ -1244,6 +1822,31 @@ FROM
 	return maxCount, count, nil
 }
 
+func (r *HybridRepository) FindByDN(ctx context.Context, dn *DN, option *SearchOption) (*SearchEntry, error) {
+	// Fetch the entry as base scope search without filter
+	o := *option
+	o.Scope = 0
+	o.Filter = nil
+	o.PageSize = 1
+	o.Offset = 0
+
+	var entry *SearchEntry
+
+	_, _, err := r.Search(ctx, dn, &o, func(searchEntry *SearchEntry) error {
+		entry = searchEntry
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("Failed to find the entry. dn: %s, err: %w", dn.DNNormStr(), err)
+	}
+
+	if entry == nil {
+		return nil, NewNoSuchObject()
+	}
+
+	return entry, nil
+}
+
 func (r *HybridRepository) toSearchEntry(dbEntry *HybridFetchedDBEntry) *SearchEntry {
 	orig := dbEntry.AttrsOrig()
 
@@ -1261,6 +1864,13 @@ func (r *HybridRepository) toSearchEntry(dbEntry *HybridFetchedDBEntry) *SearchE
 	r.resolveDNSuffix(orig, "creatorsName")
 	r.resolveDNSuffix(orig, "modifiersName")
 
+	// The default ppolicy is in effect if the entry with the password doesn't have pwdPolicySubentry
+	if _, ok := orig["pwdPolicySubentry"]; !ok {
+		if _, ok := orig["userPassword"]; ok && !r.server.defaultPPolicyDN.IsAnonymous() {
+			orig["pwdPolicySubentry"] = []string{r.server.defaultPPolicyDN.DNOrigStr()}
+		}
+	}
+
 	readEntry := NewSearchEntry(r.server.schemaMap, dbEntry.DNOrig, orig)
 
 	return readEntry
@@ -1480,11 +2090,160 @@ func (r *HybridRepository) collectScopeWhereSQL(baseDN *DN, option *SearchOption
 			params["parent_dn_norm"] = baseDN.ParentDN().DNNormStrWithoutSuffix(r.server.Suffix)
 			params["dn_norm"] = baseDN.DNNormStrWithoutSuffix(r.server.Suffix)
 		} else {
-			where.WriteString(subWhere)
-			params["parent_dn_norm"] = baseDN.ParentDN().DNNormStrWithoutSuffix(r.server.Suffix)
-			params["dn_norm"] = baseDN.DNNormStrWithoutSuffix(r.server.Suffix)
+			where.WriteString(subWhere)
+			params["parent_dn_norm"] = baseDN.ParentDN().DNNormStrWithoutSuffix(r.server.Suffix)
+			params["dn_norm"] = baseDN.DNNormStrWithoutSuffix(r.server.Suffix)
+		}
+	}
+}
+
+// collectOrderBySQL writes ORDER BY expressions for the sort keys.
+// Entries which don't have the attribute are sorted last as larger than any value.
+func (r *HybridRepository) collectOrderBySQL(option *SearchOption, orderBy *strings.Builder, params map[string]interface{}) {
+	for _, k := range option.SortKeys {
+		r.writeSortKeySQL(k, orderBy, params)
+		if k.ReverseOrder {
+			orderBy.WriteString(` DESC, `)
+		} else {
+			orderBy.WriteString(` ASC, `)
+		}
+	}
+
+	// Keep stable order
+	orderBy.WriteString(`e.id`)
+}
+
+// writeSortKeySQL writes the expression to get the value for sorting.
+// The values in attrs_norm are already normalized by the EQUALITY rule, so we can sort them directly.
+// When the attribute has multiple values, the smallest value is used for ascending
+// and the largest value is used for descending order (RFC 2891 Section 2).
+func (r *HybridRepository) writeSortKeySQL(k *SortKey, sb *strings.Builder, params map[string]interface{}) {
+	key := "sort_" + strconv.Itoa(len(params))
+	params[key] = k.AttributeType.Name
+
+	agg := "min"
+	if k.ReverseOrder {
+		agg = "max"
+	}
+
+	// The values are ordered by the ORDERING rule
+	var value string
+	switch {
+	case k.AttributeType.Ordering == "UUIDOrderingMatch":
+		// The normalized UUID is stored as string
+		value = "v.value"
+	case k.AttributeType.IsNumberOrdering():
+		value = "v.value::numeric"
+	default:
+		value = "v.value"
+	}
+
+	sb.WriteString(`(SELECT `)
+	sb.WriteString(agg)
+	sb.WriteString(`(`)
+	sb.WriteString(value)
+	sb.WriteString(`) FROM jsonb_array_elements_text(e.attrs_norm->:`)
+	sb.WriteString(key)
+	sb.WriteString(`) AS v(value))`)
+}
+
+// resolveVLVWindow resolves the target position and the window of virtual list view.
+// The offset and the page size of the search are replaced with the window.
+// https://tools.ietf.org/html/draft-ietf-ldapext-ldapv3-vlv-09
+func (r *HybridRepository) resolveVLVWindow(tx *sqlx.Tx, option *SearchOption, filterJoin, scopeWhere, filterWhere string, params map[string]interface{}) error {
+	vlv := option.VLV
+
+	// Count the entries which are located before the assertion value
+	var before strings.Builder
+	if vlv.AssertionValue != nil && len(option.SortKeys) > 0 {
+		k := option.SortKeys[0]
+
+		params["vlv_assertion"] = vlv.AssertionValue.Norm()[0]
+
+		before.WriteString(`count(e.id) FILTER (WHERE `)
+		r.writeSortKeySQL(k, &before, params)
+		if k.ReverseOrder {
+			// Entries which don't have the attribute are located first in descending order
+			before.WriteString(` > :vlv_assertion OR `)
+			r.writeSortKeySQL(k, &before, params)
+			before.WriteString(` IS NULL)`)
+		} else {
+			before.WriteString(` < :vlv_assertion)`)
+		}
+	} else {
+		before.WriteString(`0`)
+	}
+
+	q := fmt.Sprintf(`SELECT
+		count(e.id) AS count,
+		%s AS before
+	FROM
+		ldap_entry e
+	-- DN join
+	LEFT JOIN ldap_container dnc ON e.parent_id = dnc.id
+	%s
+	WHERE
+		-- scope filter
+		%s
+		AND
+		-- ldap filter
+		(%s)
+	`, before.String(), filterJoin, scopeWhere, filterWhere)
+
+	rows, err := r.namedQuery(tx, q, params)
+	if err != nil {
+		return xerrors.Errorf("Unexpected vlv count query error. err: %w", err)
+	}
+	defer rows.Close()
+
+	var count, beforeCount int32
+	if rows.Next() {
+		if err := rows.Scan(&count, &beforeCount); err != nil {
+			return xerrors.Errorf("Unexpected vlv count scan error. err: %w", err)
+		}
+	}
+	rows.Close()
+
+	// Resolve the target position (1-origin)
+	var target int32
+	if vlv.AssertionValue != nil {
+		// The first entry whose value is greater than or equal to the assertion value.
+		// If no such entry, the target is next to the last entry.
+		target = beforeCount + 1
+	} else {
+		if vlv.ContentCount > 0 && vlv.ContentCount != count {
+			// Scale the offset with the client's estimate
+			if vlv.Offset >= vlv.ContentCount {
+				target = count
+			} else {
+				target = int32(int64(vlv.Offset) * int64(count) / int64(vlv.ContentCount))
+				if target < 1 {
+					target = 1
+				}
+			}
+		} else {
+			target = vlv.Offset
+		}
+		if target > count {
+			target = count
 		}
 	}
+
+	start := target - vlv.BeforeCount
+	if start < 1 {
+		start = 1
+	}
+	end := target + vlv.AfterCount
+
+	params["offset"] = start - 1
+	params["pageSize"] = end - start + 1
+
+	vlv.ResultTargetPosition = target
+	vlv.ResultContentCount = count
+
+	log.Printf("info: Resolved vlv window. target: %d, count: %d, offset: %d, pageSize: %d", target, count, start-1, end-start+1)
+
+	return nil
 }
 
 func (r *HybridRepository) collectFilterWhereSQL(baseDN *DN, option *SearchOption, join *[]string, where *[]string, params map[string]interface{}) error {
@@ -2132,6 +2891,8 @@ func (r *HybridRepository) AddEntryToDBEntry(ctx context.Context, tx *sqlx.Tx, e
 	// Remove attributes to reduce attrs_orig column size
 	r.dropAssociationAttrs(norm, orig)
 
+	r.normalizePPolicySubentry(norm)
+
 	// Creator, Modifiers
 	if session, err := AuthSessionContext(ctx); err == nil {
 		// If migration mode is enabled, we use the specified values
@@ -2190,11 +2951,22 @@ func (r *HybridRepository) AddEntryToDBEntry(ctx context.Context, tx *sqlx.Tx, e
 		AttrsNorm: types.JSONText(string(bNorm)),
 		AttrsOrig: types.JSONText(string(bOrig)),
 		ParentDN:  entry.ParentDN(),
+		EntryUUID: orig["entryUUID"][0],
 	}
 
 	return dbEntry, association, nil
 }
 
+// normalizePPolicySubentry converts pwdPolicySubentry to the normalized DN without suffix
+// to join the ppolicy entry in findCredByDN.
+func (r *HybridRepository) normalizePPolicySubentry(norm map[string][]interface{}) {
+	if v, ok := norm["pwdPolicySubentry"]; ok && len(v) > 0 {
+		if dn, ok := v[0].(*DN); ok {
+			norm["pwdPolicySubentry"] = []interface{}{dn.DNNormStrWithoutSuffix(r.server.Suffix)}
+		}
+	}
+}
+
 func (r *HybridRepository) dropAssociationAttrs(norm map[string][]interface{}, orig map[string][]string) {
 	delete(norm, "member")
 	delete(norm, "uniqueMember")
@@ -2377,6 +3149,8 @@ func (r *HybridRepository) modifyEntryToDBEntry(ctx context.Context, tx *sqlx.Tx
 	// Remove attributes to reduce attrs_orig column size
 	r.dropAssociationAttrs(norm, orig)
 
+	r.normalizePPolicySubentry(norm)
+
 	// Modifiers
 	if session, err := AuthSessionContext(ctx); err == nil {
 		if v, ok := orig["modifiersName"]; ok {
@@ -2424,12 +3198,16 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 	}
 
 	dest := struct {
-		ID                 int64          `db:"id"`
-		RawCredentialOrig  types.JSONText `db:"credential"`      // No real column in the table
-		RawLockedTimeOrig  types.JSONText `db:"locked_time"`     // No real column in the table
-		RawFailureTimeOrig types.JSONText `db:"failure_time"`    // No real column in the table
-		RawMemberOf        types.JSONText `db:"memberof"`        // No real column in the table
-		RawDefaultPPolicy  types.JSONText `db:"default_ppolicy"` // No real column in the table
+		ID                  int64          `db:"id"`
+		RawCredentialOrig   types.JSONText `db:"credential"`       // No real column in the table
+		RawLockedTimeOrig   types.JSONText `db:"locked_time"`      // No real column in the table
+		RawFailureTimeOrig  types.JSONText `db:"failure_time"`     // No real column in the table
+		RawChangedTimeOrig  types.JSONText `db:"changed_time"`     // No real column in the table
+		RawGraceUseTimeOrig types.JSONText `db:"grace_use_time"`   // No real column in the table
+		RawResetOrig        types.JSONText `db:"reset"`            // No real column in the table
+		RawPPolicySubentry  types.JSONText `db:"ppolicy_subentry"` // No real column in the table
+		RawMemberOf         types.JSONText `db:"memberof"`         // No real column in the table
+		RawDefaultPPolicy   types.JSONText `db:"default_ppolicy"`  // No real column in the table
 	}{}
 
 	var dppRDNNorm string
@@ -2458,6 +3236,9 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 		Credentials          []string `json:"credentials"`          // No real column in the table
 		PwdAccountLockedTime []string `json:"pwdAccountLockedTime"` // No real column in the table
 		PwdFailureTime       []string `json:"pwdFailureTime"`       // No real column in the table
+		PwdChangedTime       []string `json:"pwdChangedTime"`       // No real column in the table
+		PwdGraceUseTime      []string `json:"pwdGraceUseTime"`      // No real column in the table
+		PwdReset             []string `json:"pwdReset"`             // No real column in the table
 	}{}
 
 	if len(dest.RawCredentialOrig) > 0 {
@@ -2481,6 +3262,27 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 			return xerrors.Errorf("Failed to unmarshal failureTime. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
 		}
 	}
+	if len(dest.RawChangedTimeOrig) > 0 {
+		err = dest.RawChangedTimeOrig.Unmarshal(&attrsOrig.PwdChangedTime)
+		if err != nil {
+			rollback(tx)
+			return xerrors.Errorf("Failed to unmarshal changedTime. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
+		}
+	}
+	if len(dest.RawGraceUseTimeOrig) > 0 {
+		err = dest.RawGraceUseTimeOrig.Unmarshal(&attrsOrig.PwdGraceUseTime)
+		if err != nil {
+			rollback(tx)
+			return xerrors.Errorf("Failed to unmarshal graceUseTime. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
+		}
+	}
+	if len(dest.RawResetOrig) > 0 {
+		err = dest.RawResetOrig.Unmarshal(&attrsOrig.PwdReset)
+		if err != nil {
+			rollback(tx)
+			return xerrors.Errorf("Failed to unmarshal reset. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
+		}
+	}
 
 	var memberOfDN []*DN
 
@@ -2504,9 +3306,20 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 
 	var ppolicy PPolicy
 
-	// Currently, resolve default ppolicy only
-	// TODO implement use-specific ppolicy using pwdPolicySubentry
-	if len(dest.RawDefaultPPolicy) > 0 {
+	// Resolve the ppolicy by pwdPolicySubentry, fall back to the default ppolicy
+	rawPPolicy, err := r.findPPolicyBySubentry(tx, dest.RawPPolicySubentry)
+	if err != nil {
+		rollback(tx)
+		return xerrors.Errorf("Failed to find ppolicy. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
+	}
+
+	if len(rawPPolicy) > 0 {
+		err = rawPPolicy.Unmarshal(&ppolicy)
+		if err != nil {
+			rollback(tx)
+			return xerrors.Errorf("Failed to unmarshal ppolicy. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
+		}
+	} else if len(dest.RawDefaultPPolicy) > 0 {
 		err = dest.RawDefaultPPolicy.Unmarshal(&ppolicy)
 		if err != nil {
 			rollback(tx)
@@ -2524,6 +3337,30 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 		}
 	}
 
+	var pwdChangedTime *time.Time
+
+	if len(attrsOrig.PwdChangedTime) > 0 {
+		t, err := time.Parse(TIMESTAMP_FORMAT, attrsOrig.PwdChangedTime[0])
+		if err != nil {
+			rollback(tx)
+			return xerrors.Errorf("Failed to parse pwdChangedTime. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
+		}
+		pwdChangedTime = &t
+	}
+
+	var currentPwdGraceUseTime []*time.Time
+
+	for _, v := range attrsOrig.PwdGraceUseTime {
+		t, err := time.Parse(TIMESTAMP_NANO_FORMAT, v)
+		if err != nil {
+			rollback(tx)
+			return xerrors.Errorf("Failed to parse pwdGraceUseTime. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
+		}
+		currentPwdGraceUseTime = append(currentPwdGraceUseTime, &t)
+	}
+
+	now := time.Now()
+
 	var lastPwdFailureTime *time.Time
 	var currentPwdFailureTime []*time.Time
 
@@ -2534,15 +3371,17 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 				rollback(tx)
 				return xerrors.Errorf("Failed to parse pwdFailureTime. dn_orig: %s, err: %w", dn.DNOrigStr(), err)
 			}
-			if lastPwdFailureTime == nil {
-				lastPwdFailureTime = &t
-			} else {
-				if t.After(*lastPwdFailureTime) {
-					lastPwdFailureTime = &t
-				}
-			}
 			currentPwdFailureTime = append(currentPwdFailureTime, &t)
 		}
+
+		// Purge the failures older than pwdFailureCountInterval
+		currentPwdFailureTime = ppolicy.FailureTimesInInterval(currentPwdFailureTime, now)
+
+		for _, t := range currentPwdFailureTime {
+			if lastPwdFailureTime == nil || t.After(*lastPwdFailureTime) {
+				lastPwdFailureTime = t
+			}
+		}
 	}
 
 	fc := &FetchedCredential{
@@ -2552,7 +3391,10 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 		PPolicy:              &ppolicy,
 		PwdAccountLockedTime: &pwdAccountLockedTime,
 		LastPwdFailureTime:   lastPwdFailureTime,
-		PwdFailureCount:      len(attrsOrig.PwdFailureTime),
+		PwdFailureCount:      len(currentPwdFailureTime),
+		PwdChangedTime:       pwdChangedTime,
+		PwdGraceUseCount:     len(currentPwdGraceUseTime),
+		PwdReset:             len(attrsOrig.PwdReset) > 0 && attrsOrig.PwdReset[0] == "TRUE",
 	}
 
 	// Call the callback implemented bind logic
@@ -2564,7 +3406,7 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 		isLDAPError := xerrors.As(callbackErr, &lerr)
 		if !isLDAPError || !lerr.IsInvalidCredentials() {
 			rollback(tx)
-			return err
+			return callbackErr
 		}
 
 		if lerr.IsAccountLocked() {
@@ -2573,8 +3415,15 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 			return callbackErr
 		}
 
+		if lerr.IsPasswordExpired() {
+			// The password was valid, so don't count it as a failure
+			rollback(tx)
+			log.Printf("Password is expired, dn_norm: %s", dn.DNNormStr())
+			return callbackErr
+		}
+
 		if ppolicy.IsLockoutEnabled() {
-			ft := time.Now()
+			ft := now
 
 			var ltn, lto types.JSONText
 
@@ -2619,6 +3468,42 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 			rollback(tx)
 			return xerrors.Errorf("Failed to update entry after bind success. id: %d, err: %w", dest.ID, err)
 		}
+
+		// Record pwdGraceUseTime if the bind succeeded with the expired password
+		if ppolicy.IsPasswordExpired(pwdChangedTime, now) {
+			currentPwdGraceUseTime = append(currentPwdGraceUseTime, &now)
+			gtn, gto := timesToJSONAttrs(TIMESTAMP_NANO_FORMAT, currentPwdGraceUseTime)
+
+			if _, err := r.exec(tx, updateGraceUseTimeByDN, map[string]interface{}{
+				"id":                  dest.ID,
+				"grace_use_time_norm": gtn,
+				"grace_use_time_orig": gto,
+			}); err != nil {
+				rollback(tx)
+				return xerrors.Errorf("Failed to update pwdGraceUseTime after bind success. id: %d, err: %w", dest.ID, err)
+			}
+		}
+
+		// Store the re-hashed credential
+		if len(fc.RehashedCredential) > 0 {
+			sv, err := NewSchemaValue(r.server.schemaMap, "userPassword", fc.RehashedCredential)
+			if err != nil {
+				rollback(tx)
+				return xerrors.Errorf("Failed to normalize the rehashed credential. id: %d, err: %w", dest.ID, err)
+			}
+			cn, _ := json.Marshal(sv.Norm())
+			co, _ := json.Marshal(sv.Orig())
+
+			if _, err := r.exec(tx, updateCredentialByDN, map[string]interface{}{
+				"id":              dest.ID,
+				"credential_norm": types.JSONText(cn),
+				"credential_orig": types.JSONText(co),
+			}); err != nil {
+				rollback(tx)
+				return xerrors.Errorf("Failed to update the rehashed credential after bind success. id: %d, err: %w", dest.ID, err)
+			}
+			log.Printf("info: Rehashed the password with the configured scheme. id: %d", dest.ID)
+		}
 	}
 
 	if err := commit(tx); err != nil {
@@ -2633,6 +3518,47 @@ func (r *HybridRepository) Bind(ctx context.Context, dn *DN, callback func(curre
 // PPolicy
 //////////////////////////////////////////
 
+// findPPolicyBySubentry returns the attributes of the ppolicy entry specified by pwdPolicySubentry.
+// The DN is parsed by the DN normalizer because it may have the escaped characters.
+// It returns nil if the entry doesn't have pwdPolicySubentry or the ppolicy entry doesn't exist.
+func (r *HybridRepository) findPPolicyBySubentry(tx *sqlx.Tx, rawSubentry types.JSONText) (types.JSONText, error) {
+	if len(rawSubentry) == 0 {
+		return nil, nil
+	}
+
+	var subentry []string
+	if err := rawSubentry.Unmarshal(&subentry); err != nil {
+		return nil, xerrors.Errorf("Failed to unmarshal pwdPolicySubentry. err: %w", err)
+	}
+	if len(subentry) == 0 {
+		return nil, nil
+	}
+
+	ppolicyDN, err := r.server.NormalizeDN(subentry[0])
+	if err != nil {
+		log.Printf("warn: Invalid pwdPolicySubentry, ignore. pwdPolicySubentry: %s, err: %v", subentry[0], err)
+		return nil, nil
+	}
+
+	dest := struct {
+		ID         int64          `db:"id"`
+		RawPPolicy types.JSONText `db:"ppolicy"` // No real column in the table
+	}{}
+
+	if err := r.get(tx, findPPolicyByDN, &dest, map[string]interface{}{
+		"rdn_norm":       ppolicyDN.RDNNormStr(),
+		"parent_dn_norm": ppolicyDN.ParentDN().DNNormStrWithoutSuffix(r.server.Suffix),
+	}); err != nil {
+		if isNoResult(err) {
+			log.Printf("warn: The ppolicy entry doesn't exist. pwdPolicySubentry: %s", ppolicyDN.DNNormStr())
+			return nil, nil
+		}
+		return nil, xerrors.Errorf("Failed to find ppolicy by DN. dn_norm: %s, err: %w", ppolicyDN.DNNormStr(), err)
+	}
+
+	return dest.RawPPolicy, nil
+}
+
 func (r *HybridRepository) FindPPolicyByDN(ctx context.Context, dn *DN) (*PPolicy, error) {
 	tx, err := r.beginReadonly(ctx)
 	if err != nil {
@@ -2648,6 +3574,7 @@ func (r *HybridRepository) FindPPolicyByDN(ctx context.Context, dn *DN) (*PPolic
 		"rdn_norm":       dn.RDNNormStr(),
 		"parent_dn_norm": dn.ParentDN().DNNormStrWithoutSuffix(r.server.Suffix),
 	}); err != nil {
+		rollback(tx)
 		if isNoResult(err) {
 			// Don't return error
 			return nil, nil
@@ -2673,10 +3600,325 @@ func (r *HybridRepository) FindPPolicyByDN(ctx context.Context, dn *DN) (*PPolic
 	}
 }
 
+//////////////////////////////////////////
+// CHANGELOG
+//////////////////////////////////////////
+
+// changeListenerPingInterval is the interval to check the connection for LISTEN.
+const changeListenerPingInterval = 90 * time.Second
+
+// changelogLockKey is the key of the advisory lock for writing ldap_changelog table.
+const changelogLockKey = 0x6c6470675f636c // "ldpg_cl"
+
+func (r *HybridRepository) initChangelog() error {
+	db := r.db
+
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS ldap_changelog (
+		change_number BIGSERIAL PRIMARY KEY,
+		change_time TIMESTAMP WITH TIME ZONE NOT NULL,
+		target_dn VARCHAR(512) NOT NULL,
+		entry_uuid VARCHAR(36) NOT NULL,
+		change_type VARCHAR(16) NOT NULL,
+		changes TEXT,
+		new_rdn VARCHAR(256),
+		delete_old_rdn BOOLEAN,
+		new_superior VARCHAR(512),
+		deleted_attrs JSONB
+	);
+	CREATE INDEX IF NOT EXISTS idx_ldap_changelog_change_time ON ldap_changelog (change_time);
+	-- Migrate the table created before entry_uuid and deleted_attrs were added, the existing changes don't have them
+	ALTER TABLE ldap_changelog ADD COLUMN IF NOT EXISTS entry_uuid VARCHAR(36) NOT NULL DEFAULT '';
+	ALTER TABLE ldap_changelog ADD COLUMN IF NOT EXISTS deleted_attrs JSONB;
+	`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize ldap_changelog table: %w", err)
+	}
+
+	insertChangelogStmt, err = db.PrepareNamed(`INSERT INTO ldap_changelog
+		(change_time, target_dn, entry_uuid, change_type, changes, new_rdn, delete_old_rdn, new_superior, deleted_attrs)
+		VALUES (:change_time, :target_dn, :entry_uuid, :change_type, :changes, :new_rdn, :delete_old_rdn, :new_superior, :deleted_attrs)
+		RETURNING change_number`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	findChangelogStmt, err = db.PrepareNamed(`SELECT
+		change_number, change_time, target_dn, entry_uuid, change_type, changes, new_rdn, delete_old_rdn, new_superior, deleted_attrs
+	FROM ldap_changelog
+	WHERE change_number >= :first AND change_number <= :last
+	ORDER BY change_number`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	findChangeNumberRangeStmt, err = db.PrepareNamed(`SELECT
+		COALESCE(MIN(change_number), 0) AS first, COALESCE(MAX(change_number), 0) AS last
+	FROM ldap_changelog`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	purgeChangelogStmt, err = db.PrepareNamed(`DELETE FROM ldap_changelog WHERE change_time < :before`)
+	if err != nil {
+		return xerrors.Errorf("Failed to initialize prepared statement: %w", err)
+	}
+
+	return nil
+}
+
+// insertChangelog writes the change record to ldap_changelog table in the transaction if it's enabled.
+// The transaction-level advisory lock serializes the writers until the commit,
+// so the change number is ordered by the commit time. The sync cookie relies on it.
+func (r *HybridRepository) insertChangelog(tx *sqlx.Tx, change *ChangeRecord) error {
+	if change == nil || !r.server.config.Changelog {
+		return nil
+	}
+
+	if _, err := r.execQuery(tx, fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", changelogLockKey)); err != nil {
+		return xerrors.Errorf("Failed to lock changelog. dn_norm: %s, err: %w", change.DNNorm, err)
+	}
+
+	params := map[string]interface{}{
+		"change_time":    change.Time,
+		"target_dn":      change.DN,
+		"entry_uuid":     change.EntryUUID,
+		"change_type":    change.ChangeType,
+		"changes":        nil,
+		"new_rdn":        nil,
+		"delete_old_rdn": nil,
+		"new_superior":   nil,
+		"deleted_attrs":  nil,
+	}
+	if changes := change.ChangesLDIF(); changes != "" {
+		params["changes"] = changes
+	}
+	if change.ChangeType == "delete" && change.Attrs != nil {
+		// The persistent search fetches the deleted entry which is too large for the notification
+		deletedAttrs, err := json.Marshal(change.Attrs)
+		if err != nil {
+			return xerrors.Errorf("Failed to marshal the deleted entry. dn_norm: %s, err: %w", change.DNNorm, err)
+		}
+		params["deleted_attrs"] = types.JSONText(deletedAttrs)
+	}
+	if change.ChangeType == "modrdn" {
+		params["new_rdn"] = change.NewRDN
+		params["delete_old_rdn"] = change.DeleteOldRDN
+		if change.NewSuperior != "" {
+			params["new_superior"] = change.NewSuperior
+		}
+	}
+
+	if err := r.get(tx, insertChangelogStmt, &change.ChangeNumber, params); err != nil {
+		return xerrors.Errorf("Failed to insert changelog. dn_norm: %s, err: %w", change.DNNorm, err)
+	}
+	return nil
+}
+
+func (r *HybridRepository) SearchChangelog(ctx context.Context, option *ChangelogSearchOption, handler func(entry *ChangelogEntry) error) error {
+	if !r.server.config.Changelog {
+		return NewNoSuchObject()
+	}
+
+	tx, err := r.beginReadonly(ctx)
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	last := option.LastChangeNumber
+	if last <= 0 {
+		last = math.MaxInt64
+	}
+	params := map[string]interface{}{
+		"first": option.FirstChangeNumber,
+		"last":  last,
+	}
+
+	debugSQL(r.server.config.LogLevel, findChangelogStmt.QueryString, params)
+	start := time.Now()
+	rows, err := tx.NamedStmt(findChangelogStmt).Queryx(params)
+	observeDBQuery(start)
+	if err != nil {
+		errorSQL(err, findChangelogStmt.QueryString, params)
+		return xerrors.Errorf("Failed to search changelog. err: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry ChangelogEntry
+		if err := rows.StructScan(&entry); err != nil {
+			return xerrors.Errorf("Failed to scan changelog. err: %w", err)
+		}
+		if err := handler(&entry); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return xerrors.Errorf("Failed to fetch changelog. err: %w", err)
+	}
+
+	return nil
+}
+
+func (r *HybridRepository) ChangeNumberRange(ctx context.Context) (int64, int64, error) {
+	if !r.server.config.Changelog {
+		return 0, 0, nil
+	}
+
+	tx, err := r.beginReadonly(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rollback(tx)
+
+	dest := struct {
+		First int64 `db:"first"`
+		Last  int64 `db:"last"`
+	}{}
+	if err := r.get(tx, findChangeNumberRangeStmt, &dest, map[string]interface{}{}); err != nil {
+		return 0, 0, xerrors.Errorf("Failed to fetch the range of the change number. err: %w", err)
+	}
+	return dest.First, dest.Last, nil
+}
+
+func (r *HybridRepository) PurgeChangelog(ctx context.Context, before time.Time) (int64, error) {
+	if !r.server.config.Changelog {
+		return 0, nil
+	}
+
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.exec(tx, purgeChangelogStmt, map[string]interface{}{
+		"before": before,
+	})
+	if err != nil {
+		rollback(tx)
+		return 0, xerrors.Errorf("Failed to purge changelog. err: %w", err)
+	}
+
+	if err := commit(tx); err != nil {
+		return 0, err
+	}
+
+	num, _ := result.RowsAffected()
+	return num, nil
+}
+
+// ListenChanges listens the channel by the dedicated connection, it's reconnected automatically.
+// The changes committed while reconnecting are lost.
+func (r *HybridRepository) ListenChanges(handler func(payload string)) error {
+	listener := pq.NewListener(r.url, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Printf("warn: Disconnected the connection for LISTEN. err: %v", err)
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("warn: Failed to reconnect the connection for LISTEN. err: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Printf("warn: Reconnected the connection for LISTEN, the changes in the meantime were lost")
+		}
+	})
+	if err := listener.Listen(changeNotifyChannel); err != nil {
+		listener.Close()
+		return xerrors.Errorf("Failed to listen. channel: %s, err: %w", changeNotifyChannel, err)
+	}
+
+	go func() {
+		// Ping periodically to detect the broken connection
+		ticker := time.NewTicker(changeListenerPingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case n := <-listener.Notify:
+				// nil is sent after reconnecting
+				if n != nil {
+					handler(n.Extra)
+				}
+			case <-ticker.C:
+				if err := listener.Ping(); err != nil {
+					log.Printf("warn: Failed to ping the connection for LISTEN. err: %v", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (r *HybridRepository) findAttrsOrigByID(tx *sqlx.Tx, id int64) (map[string][]string, error) {
+	var attrsOrig types.JSONText
+	if err := r.get(tx, findAttrsOrigByIDStmt, &attrsOrig, map[string]interface{}{
+		"id": id,
+	}); err != nil {
+		return nil, xerrors.Errorf("Failed to fetch attrs_orig. id: %d, err: %w", id, err)
+	}
+	var attrs map[string][]string
+	if err := attrsOrig.Unmarshal(&attrs); err != nil {
+		return nil, xerrors.Errorf("Failed to unmarshal attrs_orig. id: %d, err: %w", id, err)
+	}
+	return attrs, nil
+}
+
 //////////////////////////////////////////
 // Utilities
 //////////////////////////////////////////
 
+// recordChange writes the change record to ldap_audit and ldap_changelog table in the transaction if they are enabled.
+// Also, it notifies the other instances of the change for the persistent search.
+func (r *HybridRepository) recordChange(tx *sqlx.Tx, change *ChangeRecord) error {
+	if err := r.insertAudit(tx, change); err != nil {
+		return err
+	}
+	if err := r.insertChangelog(tx, change); err != nil {
+		return err
+	}
+	return r.notifyChange(tx, change)
+}
+
+// notifyChange sends NOTIFY with the change in the transaction if the persistent search is enabled.
+// The instances whose persistent search is disabled don't send it, so the other instances can't receive their changes.
+// The notification is delivered to the listeners when the transaction is committed.
+func (r *HybridRepository) notifyChange(tx *sqlx.Tx, change *ChangeRecord) error {
+	if change == nil || !r.server.config.PersistentSearch {
+		return nil
+	}
+
+	payload, err := encodeChangeNotification(r.server.instanceID, change)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.exec(tx, notifyChangeStmt, map[string]interface{}{
+		"channel": changeNotifyChannel,
+		"payload": payload,
+	}); err != nil {
+		return xerrors.Errorf("Failed to notify the change. dn_norm: %s, err: %w", change.DNNorm, err)
+	}
+	return nil
+}
+
+// insertAudit writes the audit record to ldap_audit table in the transaction if it's enabled.
+func (r *HybridRepository) insertAudit(tx *sqlx.Tx, audit *ChangeRecord) error {
+	if audit == nil || !r.server.config.AuditDB {
+		return nil
+	}
+	if _, err := r.exec(tx, insertAuditStmt, map[string]interface{}{
+		"created":    audit.Time,
+		"actor":      audit.Actor,
+		"addr":       audit.Addr,
+		"dn_norm":    audit.DNNorm,
+		"changetype": audit.ChangeType,
+		"ldif":       audit.LDIF(),
+	}); err != nil {
+		return xerrors.Errorf("Failed to insert audit record. dn_norm: %s, err: %w", audit.DNNorm, err)
+	}
+	return nil
+}
+
 func (r *HybridRepository) begin(ctx context.Context) (*sqlx.Tx, error) {
 	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{
 		Isolation: sql.LevelReadCommitted,
@@ -2708,7 +3950,9 @@ func (r *HybridRepository) beginReadonly(ctx context.Context) (*sqlx.Tx, error)
 
 func (r *HybridRepository) exec(tx *sqlx.Tx, stmt *sqlx.NamedStmt, params map[string]interface{}) (sql.Result, error) {
 	debugSQL(r.server.config.LogLevel, stmt.QueryString, params)
+	start := time.Now()
 	result, err := tx.NamedStmt(stmt).Exec(params)
+	observeDBQuery(start)
 	errorSQL(err, stmt.QueryString, params)
 	if isForeignKeyError(err) {
 		return nil, NewRetryError(err)
@@ -2718,7 +3962,9 @@ func (r *HybridRepository) exec(tx *sqlx.Tx, stmt *sqlx.NamedStmt, params map[st
 
 func (r *HybridRepository) execQuery(tx *sqlx.Tx, query string) (sql.Result, error) {
 	debugSQL(r.server.config.LogLevel, query, nil)
+	start := time.Now()
 	result, err := tx.Exec(query)
+	observeDBQuery(start)
 	errorSQL(err, query, nil)
 	if isForeignKeyError(err) {
 		return nil, NewRetryError(err)
@@ -2728,7 +3974,9 @@ func (r *HybridRepository) execQuery(tx *sqlx.Tx, query string) (sql.Result, err
 
 func (r *HybridRepository) namedQuery(tx *sqlx.Tx, query string, params map[string]interface{}) (*sqlx.Rows, error) {
 	debugSQL(r.server.config.LogLevel, query, params)
+	start := time.Now()
 	rows, err := tx.NamedQuery(query, params)
+	observeDBQuery(start)
 	errorSQL(err, query, params)
 	if isForeignKeyError(err) {
 		return nil, NewRetryError(err)
@@ -2738,7 +3986,21 @@ func (r *HybridRepository) namedQuery(tx *sqlx.Tx, query string, params map[stri
 
 func (r *HybridRepository) get(tx *sqlx.Tx, stmt *sqlx.NamedStmt, dest interface{}, params map[string]interface{}) error {
 	debugSQL(r.server.config.LogLevel, stmt.QueryString, params)
+	start := time.Now()
 	err := tx.NamedStmt(stmt).Get(dest, params)
+	observeDBQuery(start)
+	errorSQL(err, stmt.QueryString, params)
+	if isForeignKeyError(err) {
+		return NewRetryError(err)
+	}
+	return err
+}
+
+func (r *HybridRepository) selectAll(tx *sqlx.Tx, stmt *sqlx.NamedStmt, dest interface{}, params map[string]interface{}) error {
+	debugSQL(r.server.config.LogLevel, stmt.QueryString, params)
+	start := time.Now()
+	err := tx.NamedStmt(stmt).Select(dest, params)
+	observeDBQuery(start)
 	errorSQL(err, stmt.QueryString, params)
 	if isForeignKeyError(err) {
 		return NewRetryError(err)
@@ -346,3 +346,68 @@ func createHybridFilterTestData() (ret []HybridFilterTestData) {
 		},
 	}
 }
+
+func TestHybridSortKey(t *testing.T) {
+	server := NewServer(&ServerConfig{
+		Suffix:          "dc=example,dc=com",
+		QueryTranslator: "default",
+	})
+	server.LoadSchema()
+
+	r := &HybridRepository{DBRepository: &DBRepository{server: server}}
+
+	testcases := []struct {
+		Attr     string
+		Reverse  bool
+		Expected string
+	}{
+		{"createTimestamp", false, `(SELECT min(v.value::numeric) FROM jsonb_array_elements_text(e.attrs_norm->:sort_0) AS v(value))`},
+		{"uidNumber", true, `(SELECT max(v.value::numeric) FROM jsonb_array_elements_text(e.attrs_norm->:sort_0) AS v(value))`},
+		{"telexNumber", false, `(SELECT min(v.value) FROM jsonb_array_elements_text(e.attrs_norm->:sort_0) AS v(value))`},
+		{"entryUUID", false, `(SELECT min(v.value) FROM jsonb_array_elements_text(e.attrs_norm->:sort_0) AS v(value))`},
+		{"cn", false, `(SELECT min(v.value) FROM jsonb_array_elements_text(e.attrs_norm->:sort_0) AS v(value))`},
+	}
+
+	for i, tc := range testcases {
+		at, ok := server.schemaMap.AttributeType(tc.Attr)
+		if !ok {
+			t.Fatalf("No attribute type on %d: %s", i, tc.Attr)
+		}
+		var sb strings.Builder
+		params := map[string]interface{}{}
+		r.writeSortKeySQL(&SortKey{AttributeType: at, ReverseOrder: tc.Reverse}, &sb, params)
+		if sb.String() != tc.Expected {
+			t.Errorf("Unexpected sort key SQL on %d: %s", i, sb.String())
+		}
+		if params["sort_0"] != at.Name {
+			t.Errorf("Unexpected params on %d: %v", i, params)
+		}
+	}
+}
+
+func TestSortOrderingRule(t *testing.T) {
+	server := NewServer(&ServerConfig{
+		Suffix:          "dc=example,dc=com",
+		QueryTranslator: "default",
+	})
+	server.LoadSchema()
+
+	testcases := map[string]string{
+		// Inherit name which doesn't have ORDERING rule
+		"cn":              "caseIgnoreOrderingMatch",
+		"sn":              "caseIgnoreOrderingMatch",
+		"createTimestamp": "generalizedTimeOrderingMatch",
+		"uidNumber":       "integerOrderingMatch",
+		"userPassword":    "",
+	}
+
+	for attr, expected := range testcases {
+		at, ok := server.schemaMap.AttributeType(attr)
+		if !ok {
+			t.Fatalf("No attribute type: %s", attr)
+		}
+		if got := sortOrderingRule(at); got != expected {
+			t.Errorf("Unexpected ordering rule of %s: expected %q, got %q", attr, expected, got)
+		}
+	}
+}
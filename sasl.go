@@ -0,0 +1,454 @@
+package ldap_pg
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
+)
+
+const (
+	SASLMechanismExternal    = "EXTERNAL"
+	SASLMechanismPlain       = "PLAIN"
+	SASLMechanismSCRAMSHA256 = "SCRAM-SHA-256"
+)
+
+// SASLMechanism is the SASL mechanism which can be used in the bind operation.
+type SASLMechanism interface {
+	Name() string
+	// Available returns true if the mechanism is advertised in the root DSE and accepted.
+	Available(s *Server) bool
+	// Start starts new authentication exchange on the connection.
+	Start(s *Server, m *ldap.Message) SASLConversation
+}
+
+// SASLConversation is the authentication exchange of the SASL mechanism.
+type SASLConversation interface {
+	// Next processes the credentials sent by the client.
+	// It returns the server challenge with nil result while the exchange is in progress.
+	// When the authentication completes, it returns the result and optional additional data for the client.
+	Next(ctx context.Context, credentials []byte) ([]byte, *SASLResult, error)
+}
+
+// SASLResult is the authenticated identity by SASL.
+// The password policy state is set if the mechanism verified the password of the entry.
+type SASLResult struct {
+	BindResult
+	DN     *DN
+	IsRoot bool
+}
+
+var saslMechanisms = map[string]SASLMechanism{}
+
+// RegisterSASLMechanism registers the SASL mechanism. The mechanism name is case-insensitive.
+func RegisterSASLMechanism(mechanism SASLMechanism) {
+	saslMechanisms[strings.ToUpper(mechanism.Name())] = mechanism
+}
+
+// availableSASLMechanisms returns the sorted names of the available mechanisms.
+func availableSASLMechanisms(s *Server) []string {
+	var names []string
+	for name, v := range saslMechanisms {
+		if v.Available(s) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type saslBindState struct {
+	mechanism    string
+	conversation SASLConversation
+}
+
+func getSASLBindState(m *ldap.Message) *saslBindState {
+	session := getSession(m)
+	if state, ok := session["sasl"]; ok {
+		return state.(*saslBindState)
+	}
+	return nil
+}
+
+func saveSASLBindState(m *ldap.Message, state *saslBindState) {
+	session := getSession(m)
+	session["sasl"] = state
+}
+
+// clearSASLBindState aborts the SASL bind in progress.
+func clearSASLBindState(m *ldap.Message) {
+	session := getSession(m)
+	delete(session, "sasl")
+}
+
+func handleSASLBind(s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.BindRequest) {
+	ctx := context.WithValue(context.Background(), clientIPContextKey, clientIP(m))
+
+	res := ldap.NewBindResponse(ldap.LDAPResultSuccess)
+
+	name, cred := r.AuthenticationSasl()
+	mechanism := strings.ToUpper(string(name))
+
+	var credentials []byte
+	if cred != nil {
+		credentials = []byte(*cred)
+	}
+
+	// Continue the exchange if the client sends the same mechanism, otherwise restart it
+	state := getSASLBindState(m)
+	if state == nil || state.mechanism != mechanism {
+		clearSASLBindState(m)
+
+		mech, ok := saslMechanisms[mechanism]
+		if !ok || !mech.Available(s) {
+			err := NewAuthMethodNotSupported(string(name))
+			log.Printf("info: SASL bind failed. mechanism: %s, err: %v", name, err)
+
+			res.SetResultCode(err.Code)
+			res.SetDiagnosticMessage(err.Msg)
+			w.Write(res)
+			return
+		}
+
+		state = &saslBindState{
+			mechanism:    mechanism,
+			conversation: mech.Start(s, m),
+		}
+	}
+
+	challenge, result, err := state.conversation.Next(ctx, credentials)
+
+	// Bind failure
+	if err != nil {
+		clearSASLBindState(m)
+
+		var lerr *LDAPError
+		if ok := xerrors.As(err, &lerr); ok {
+			log.Printf("info: SASL bind failed. mechanism: %s, err: %v", mechanism, err)
+			if lerr.IsAccountLocking() {
+				metricAccountLockouts.Inc()
+			}
+
+			res.SetResultCode(lerr.Code)
+			res.SetDiagnosticMessage(lerr.Msg)
+			writeBindResponse(w, m, res, -1, -1, lerr.PPolicyError())
+			return
+		}
+
+		log.Printf("error: SASL bind failed - System error. mechanism: %s, err: %+v", mechanism, err)
+
+		// Return system error
+		res.SetResultCode(ldap.LDAPResultUnavailable)
+		w.Write(res)
+		return
+	}
+
+	if challenge != nil {
+		data := message.OCTETSTRING(challenge)
+		res.SetSaslData(&data)
+	}
+
+	// Bind in progress
+	if result == nil {
+		saveSASLBindState(m, state)
+
+		res.SetResultCode(ldap.LDAPResultSaslBindInProgress)
+		w.Write(res)
+		return
+	}
+
+	clearSASLBindState(m)
+
+	if result.IsRoot {
+		saveAuthencatedDNAsRoot(m, result.DN)
+	} else {
+		saveAuthencatedDN(m, result.DN, result.Groups, result.MustChangePassword)
+	}
+
+	// Bind success
+	log.Printf("info: SASL bind ok. mechanism: %s, dn_norm: %s", mechanism, result.DN.DNNormStr())
+
+	writeBindResult(w, m, res, &result.BindResult)
+}
+
+// resolveSASLIdentity resolves the SASL identity to the entry.
+// The identity is "dn:<DN>", "u:<user name>" or bare user name which is mapped by the identity mapping.
+func resolveSASLIdentity(ctx context.Context, s *Server, id string) (*DN, []*DN, error) {
+	if strings.HasPrefix(id, "dn:") {
+		dn, err := s.NormalizeDN(strings.TrimPrefix(id, "dn:"))
+		if err != nil || dn.IsAnonymous() {
+			log.Printf("info: Invalid SASL identity. id: %s, err: %v", id, err)
+			return nil, nil, NewInvalidCredentials()
+		}
+		return resolveIdentityByDN(ctx, s, dn)
+	}
+	return s.saslIdentityMapping.Resolve(ctx, s, strings.TrimPrefix(id, "u:"))
+}
+
+// resolveAuthzID checks the authorization identity requested by the client.
+// The authorization identity must be the same as the authenticated identity,
+// the proxied authorization is supported only by the control.
+func resolveAuthzID(ctx context.Context, s *Server, result *SASLResult, authzID string) error {
+	if authzID == "" {
+		return nil
+	}
+	if strings.HasPrefix(authzID, "dn:") {
+		requested, err := s.NormalizeDN(strings.TrimPrefix(authzID, "dn:"))
+		if err == nil && requested.Equal(result.DN) {
+			return nil
+		}
+		log.Printf("info: Not allowed authorization identity. authcid: %s, authzid: %s", result.DN.DNNormStr(), authzID)
+		return NewInvalidCredentials()
+	}
+	requested, _, err := resolveSASLIdentity(ctx, s, authzID)
+	if err != nil {
+		var lerr *LDAPError
+		if ok := xerrors.As(err, &lerr); !ok {
+			return err
+		}
+	} else if requested.Equal(result.DN) {
+		return nil
+	}
+	log.Printf("info: Not allowed authorization identity. authcid: %s, authzid: %s", result.DN.DNNormStr(), authzID)
+	return NewInvalidCredentials()
+}
+
+func init() {
+	RegisterSASLMechanism(&saslExternalMechanism{})
+}
+
+type saslExternalMechanism struct{}
+
+func (e *saslExternalMechanism) Name() string {
+	return SASLMechanismExternal
+}
+
+// Available returns true when the client certificate can be verified.
+func (e *saslExternalMechanism) Available(s *Server) bool {
+	return s.tlsConfig != nil && s.tlsConfig.ClientCAs != nil
+}
+
+func (e *saslExternalMechanism) Start(s *Server, m *ldap.Message) SASLConversation {
+	return &saslExternalConversation{
+		server:  s,
+		message: m,
+	}
+}
+
+type saslExternalConversation struct {
+	server  *Server
+	message *ldap.Message
+}
+
+// Next authenticates the client by the verified client certificate.
+// The credentials are the authorization identity requested by the client (optional).
+func (c *saslExternalConversation) Next(ctx context.Context, credentials []byte) ([]byte, *SASLResult, error) {
+	s := c.server
+
+	conn, ok := c.message.Client.GetConn().(*tls.Conn)
+	if !ok {
+		return nil, nil, NewInappropriateAuthentication("SASL EXTERNAL requires TLS")
+	}
+
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil, nil, NewInappropriateAuthentication("SASL EXTERNAL requires a verified client certificate")
+	}
+
+	dn, groups, err := s.saslExternalMapping.Resolve(ctx, s, state.PeerCertificates[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &SASLResult{
+		BindResult: BindResult{Groups: groups},
+		DN:         dn,
+	}
+
+	if err := resolveAuthzID(ctx, s, result, string(credentials)); err != nil {
+		return nil, nil, err
+	}
+
+	return nil, result, nil
+}
+
+// NewSASLIdentityMapping parses the identity mapping config for the user name of PLAIN and SCRAM-SHA-256.
+// The format is <Base DN>:<Filter> (e.g. ou=Users,dc=example,dc=com:(mail=%s)).
+// Empty config means searching the user by uid under the suffix.
+func NewSASLIdentityMapping(server *Server) (*IdentityMapping, error) {
+	c := server.config.SASLIdentityMapping
+	if c == "" {
+		return NewIdentityMapping(server, "", "(uid=%s)")
+	}
+
+	kv := strings.SplitN(c, ":", 2)
+	if len(kv) != 2 {
+		return nil, xerrors.Errorf("Invalid format. Need <Base DN>:<Filter>: %s", c)
+	}
+
+	return NewIdentityMapping(server, strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+}
+
+// SASLExternalMapping maps the verified client certificate to the entry.
+// When Identity is nil, the subject of the certificate is used as the DN as is.
+// Otherwise, the value picked from the certificate by Source is searched by the identity mapping.
+type SASLExternalMapping struct {
+	Source   string
+	Identity *IdentityMapping
+}
+
+// NewSASLExternalMapping parses the mapping config.
+// The format is <Source(subject, cn, email, dns or uri)>:<Base DN>:<Filter> (e.g. email:ou=Users,dc=example,dc=com:(mail=%s)).
+// Empty config means the subject of the certificate is the DN.
+func NewSASLExternalMapping(server *Server) (*SASLExternalMapping, error) {
+	c := server.config.SASLExternalMapping
+	if c == "" {
+		return &SASLExternalMapping{
+			Source: "subject",
+		}, nil
+	}
+
+	kv := strings.SplitN(c, ":", 3)
+	if len(kv) != 3 {
+		return nil, xerrors.Errorf("Invalid format. Need <Source>:<Base DN>:<Filter>: %s", c)
+	}
+
+	source := strings.ToLower(strings.TrimSpace(kv[0]))
+	switch source {
+	case "subject", "cn", "email", "dns", "uri":
+	default:
+		return nil, xerrors.Errorf("Invalid source. Need subject, cn, email, dns or uri: %s", kv[0])
+	}
+
+	identity, err := NewIdentityMapping(server, strings.TrimSpace(kv[1]), strings.TrimSpace(kv[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SASLExternalMapping{
+		Source:   source,
+		Identity: identity,
+	}, nil
+}
+
+// Resolve returns the DN and the groups of the entry mapped from the certificate.
+func (e *SASLExternalMapping) Resolve(ctx context.Context, s *Server, cert *x509.Certificate) (*DN, []*DN, error) {
+	if e.Identity == nil {
+		dn, err := s.NormalizeDN(certSubjectToDN(cert))
+		if err != nil {
+			log.Printf("info: The certificate subject isn't a valid DN. subject: %s, err: %v", cert.Subject, err)
+			return nil, nil, NewInvalidCredentials()
+		}
+		return resolveIdentityByDN(ctx, s, dn)
+	}
+
+	var identities []string
+	switch e.Source {
+	case "subject":
+		identities = []string{certSubjectToDN(cert)}
+	case "cn":
+		identities = []string{cert.Subject.CommonName}
+	case "email":
+		identities = cert.EmailAddresses
+	case "dns":
+		identities = cert.DNSNames
+	case "uri":
+		for _, u := range cert.URIs {
+			identities = append(identities, u.String())
+		}
+	}
+
+	// Use the first value which is mapped to the entry
+	for _, v := range identities {
+		if v == "" {
+			continue
+		}
+		dn, groups, err := e.Identity.Resolve(ctx, s, v)
+		if err != nil {
+			var lerr *LDAPError
+			if ok := xerrors.As(err, &lerr); ok && lerr.IsInvalidCredentials() {
+				continue
+			}
+			return nil, nil, err
+		}
+		return dn, groups, nil
+	}
+
+	log.Printf("info: Not found the entry mapped from the certificate. source: %s, subject: %s", e.Source, cert.Subject)
+	return nil, nil, NewInvalidCredentials()
+}
+
+var certAttributeTypeNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "street",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.17":                   "postalCode",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+// certSubjectToDN returns the string representation of the certificate subject in RFC 4514 order.
+// Unlike pkix.Name.String(), well-known LDAP attribute types (DC, UID) are written by the name.
+func certSubjectToDN(cert *x509.Certificate) string {
+	var rdnSeq []string
+
+	seq := cert.Subject.ToRDNSequence()
+	for i := len(seq) - 1; i >= 0; i-- {
+		var rdn []string
+		for _, atv := range seq[i] {
+			rdn = append(rdn, formatCertAttribute(atv.Type, atv.Value))
+		}
+		rdnSeq = append(rdnSeq, strings.Join(rdn, "+"))
+	}
+
+	return strings.Join(rdnSeq, ",")
+}
+
+func formatCertAttribute(oid asn1.ObjectIdentifier, value interface{}) string {
+	name, ok := certAttributeTypeNames[oid.String()]
+	if !ok {
+		// Unknown attribute type is written as the OID with BER encoded value
+		if b, err := asn1.Marshal(value); err == nil {
+			return oid.String() + "=#" + hex.EncodeToString(b)
+		}
+		name = oid.String()
+	}
+	return name + "=" + escapeDNValue(fmt.Sprint(value))
+}
+
+// escapeDNValue escapes the attribute value of the DN.
+// See https://tools.ietf.org/html/rfc4514#section-2.4
+func escapeDNValue(s string) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch {
+		case strings.ContainsRune(`"+,;<>\`, r):
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case r == '#' && i == 0:
+			sb.WriteString(`\#`)
+		case r == ' ' && (i == 0 || i == len(s)-1):
+			sb.WriteString(`\ `)
+		case r == 0:
+			sb.WriteString(`\00`)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,103 @@
+package ldap_pg
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+
+	ldap "github.com/openstandia/ldapserver"
+)
+
+func init() {
+	RegisterSASLMechanism(&saslPlainMechanism{})
+}
+
+// saslPlainMechanism is the PLAIN SASL mechanism.
+// https://tools.ietf.org/html/rfc4616
+type saslPlainMechanism struct{}
+
+func (p *saslPlainMechanism) Name() string {
+	return SASLMechanismPlain
+}
+
+func (p *saslPlainMechanism) Available(s *Server) bool {
+	return true
+}
+
+func (p *saslPlainMechanism) Start(s *Server, m *ldap.Message) SASLConversation {
+	return &saslPlainConversation{
+		server:  s,
+		message: m,
+	}
+}
+
+type saslPlainConversation struct {
+	server  *Server
+	message *ldap.Message
+}
+
+// Next authenticates the client by the password.
+//
+//	message   = [authzid] UTF8NUL authcid UTF8NUL passwd
+func (c *saslPlainConversation) Next(ctx context.Context, credentials []byte) ([]byte, *SASLResult, error) {
+	s := c.server
+
+	// Same as simple bind, sending the password requires TLS if configured
+	if s.RequiredConfidentiality(c.message) {
+		return nil, nil, NewConfidentialityRequired()
+	}
+
+	fields := bytes.Split(credentials, []byte{0})
+	if len(fields) != 3 || len(fields[1]) == 0 || len(fields[2]) == 0 {
+		return nil, nil, NewInvalidCredentials()
+	}
+	authzID := string(fields[0])
+	authcID := string(fields[1])
+	passwd := string(fields[2])
+
+	result, err := c.authenticate(ctx, authcID, passwd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := resolveAuthzID(ctx, s, result, authzID); err != nil {
+		return nil, nil, err
+	}
+
+	return nil, result, nil
+}
+
+func (c *saslPlainConversation) authenticate(ctx context.Context, authcID, passwd string) (*SASLResult, error) {
+	s := c.server
+
+	// For rootdn
+	if strings.HasPrefix(authcID, "dn:") {
+		dn, err := s.NormalizeDN(strings.TrimPrefix(authcID, "dn:"))
+		if err == nil && dn.Equal(s.GetRootDN()) {
+			if ok := validateCred(s, passwd, s.GetRootPW()); !ok {
+				log.Printf("info: Bind failed - Invalid credentials. dn_norm: %s", dn.DNNormStr())
+				return nil, NewInvalidCredentials()
+			}
+			return &SASLResult{
+				DN:     dn,
+				IsRoot: true,
+			}, nil
+		}
+	}
+
+	dn, _, err := resolveSASLIdentity(ctx, s, authcID)
+	if err != nil {
+		return nil, err
+	}
+
+	bindResult, err := bindByPassword(ctx, s, dn, passwd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SASLResult{
+		BindResult: *bindResult,
+		DN:         dn,
+	}, nil
+}
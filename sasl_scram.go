@@ -0,0 +1,378 @@
+package ldap_pg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/xerrors"
+)
+
+const (
+	SCRAMSHA256Prefix = "{SCRAM-SHA-256}"
+
+	scramSHA256DefaultIterations = 4096
+	scramSHA256SaltLength        = 16
+)
+
+func init() {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	RegisterSASLMechanism(&saslSCRAMSHA256Mechanism{
+		fakeSaltKey: key,
+	})
+}
+
+// scramSHA256Verifier is the stored SCRAM-SHA-256 verifier in userPassword.
+// The format is {SCRAM-SHA-256}<iterations>:<salt>$<StoredKey>:<ServerKey> (base64 encoded).
+type scramSHA256Verifier struct {
+	iterations int
+	salt       []byte
+	storedKey  []byte
+	serverKey  []byte
+}
+
+func parseSCRAMSHA256Verifier(cred string) (*scramSHA256Verifier, error) {
+	if !strings.HasPrefix(cred, SCRAMSHA256Prefix) {
+		return nil, xerrors.Errorf("Not SCRAM-SHA-256 verifier")
+	}
+
+	v := strings.SplitN(strings.TrimPrefix(cred, SCRAMSHA256Prefix), "$", 2)
+	if len(v) != 2 {
+		return nil, xerrors.Errorf("Invalid SCRAM-SHA-256 verifier format")
+	}
+	params := strings.SplitN(v[0], ":", 2)
+	keys := strings.SplitN(v[1], ":", 2)
+	if len(params) != 2 || len(keys) != 2 {
+		return nil, xerrors.Errorf("Invalid SCRAM-SHA-256 verifier format")
+	}
+
+	iterations, err := strconv.Atoi(params[0])
+	if err != nil || iterations < 1 {
+		return nil, xerrors.Errorf("Invalid SCRAM-SHA-256 iterations: %s", params[0])
+	}
+
+	verifier := &scramSHA256Verifier{
+		iterations: iterations,
+	}
+	if verifier.salt, err = base64.StdEncoding.DecodeString(params[1]); err != nil {
+		return nil, xerrors.Errorf("Invalid SCRAM-SHA-256 salt. err: %w", err)
+	}
+	if verifier.storedKey, err = base64.StdEncoding.DecodeString(keys[0]); err != nil {
+		return nil, xerrors.Errorf("Invalid SCRAM-SHA-256 StoredKey. err: %w", err)
+	}
+	if verifier.serverKey, err = base64.StdEncoding.DecodeString(keys[1]); err != nil {
+		return nil, xerrors.Errorf("Invalid SCRAM-SHA-256 ServerKey. err: %w", err)
+	}
+
+	return verifier, nil
+}
+
+func newSCRAMSHA256Verifier(password string, salt []byte, iterations int) *scramSHA256Verifier {
+	saltedPassword := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+	clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+
+	return &scramSHA256Verifier{
+		iterations: iterations,
+		salt:       salt,
+		storedKey:  storedKey[:],
+		serverKey:  hmacSHA256(saltedPassword, []byte("Server Key")),
+	}
+}
+
+// generateSCRAMSHA256 returns the stored SCRAM-SHA-256 verifier of the password with random salt.
+func generateSCRAMSHA256(password string, iterations int) (string, error) {
+	salt := make([]byte, scramSHA256SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", xerrors.Errorf("Failed to generate salt. err: %w", err)
+	}
+	return newSCRAMSHA256Verifier(password, salt, iterations).String(), nil
+}
+
+func (v *scramSHA256Verifier) String() string {
+	return fmt.Sprintf("%s%d:%s$%s:%s", SCRAMSHA256Prefix, v.iterations,
+		base64.StdEncoding.EncodeToString(v.salt),
+		base64.StdEncoding.EncodeToString(v.storedKey),
+		base64.StdEncoding.EncodeToString(v.serverKey))
+}
+
+// validatePassword validates the plain password for simple bind.
+func (v *scramSHA256Verifier) validatePassword(password string) bool {
+	other := newSCRAMSHA256Verifier(password, v.salt, v.iterations)
+	return subtle.ConstantTimeCompare(v.storedKey, other.storedKey) == 1
+}
+
+// validateProof validates the ClientProof sent by the client.
+func (v *scramSHA256Verifier) validateProof(authMessage string, proof []byte) bool {
+	clientSignature := hmacSHA256(v.storedKey, []byte(authMessage))
+	if len(proof) != len(clientSignature) {
+		return false
+	}
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	return subtle.ConstantTimeCompare(v.storedKey, storedKey[:]) == 1
+}
+
+func (v *scramSHA256Verifier) serverSignature(authMessage string) []byte {
+	return hmacSHA256(v.serverKey, []byte(authMessage))
+}
+
+func validateSCRAMSHA256(input, cred string) (bool, error) {
+	v, err := parseSCRAMSHA256Verifier(cred)
+	if err != nil {
+		return false, err
+	}
+	return v.validatePassword(input), nil
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// saslSCRAMSHA256Mechanism is the SCRAM-SHA-256 SASL mechanism without channel binding.
+// https://tools.ietf.org/html/rfc5802
+// https://tools.ietf.org/html/rfc7677
+// Note: SASLprep isn't applied to the user name and the password.
+type saslSCRAMSHA256Mechanism struct {
+	// fakeSaltKey is used to return the consistent salt for unknown users
+	fakeSaltKey []byte
+}
+
+func (sc *saslSCRAMSHA256Mechanism) Name() string {
+	return SASLMechanismSCRAMSHA256
+}
+
+func (sc *saslSCRAMSHA256Mechanism) Available(s *Server) bool {
+	return true
+}
+
+func (sc *saslSCRAMSHA256Mechanism) Start(s *Server, m *ldap.Message) SASLConversation {
+	return &saslSCRAMSHA256Conversation{
+		server:    s,
+		mechanism: sc,
+	}
+}
+
+type saslSCRAMSHA256Conversation struct {
+	server    *Server
+	mechanism *saslSCRAMSHA256Mechanism
+	step      int
+
+	gs2Header       string
+	authzID         string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	dn              *DN
+	verifier        *scramSHA256Verifier
+}
+
+func (c *saslSCRAMSHA256Conversation) Next(ctx context.Context, credentials []byte) ([]byte, *SASLResult, error) {
+	c.step++
+
+	switch c.step {
+	case 1:
+		return c.handleClientFirst(ctx, string(credentials))
+	case 2:
+		return c.handleClientFinal(ctx, string(credentials))
+	default:
+		return nil, nil, NewInvalidCredentials()
+	}
+}
+
+// handleClientFirst processes the client-first-message then returns server-first-message.
+//
+//	client-first-message = gs2-header client-first-message-bare
+//	gs2-header = gs2-cbind-flag "," [ authzid ] ","
+//	client-first-message-bare = [reserved-mext ","] username "," nonce ["," extensions]
+func (c *saslSCRAMSHA256Conversation) handleClientFirst(ctx context.Context, msg string) ([]byte, *SASLResult, error) {
+	parts := strings.SplitN(msg, ",", 3)
+	if len(parts) != 3 {
+		return nil, nil, NewInvalidCredentials()
+	}
+
+	switch {
+	case parts[0] == "n", parts[0] == "y":
+	case strings.HasPrefix(parts[0], "p="):
+		return nil, nil, NewInappropriateAuthentication("SCRAM channel binding not supported")
+	default:
+		return nil, nil, NewInvalidCredentials()
+	}
+
+	if parts[1] != "" {
+		if !strings.HasPrefix(parts[1], "a=") {
+			return nil, nil, NewInvalidCredentials()
+		}
+		c.authzID = decodeSCRAMSaslName(strings.TrimPrefix(parts[1], "a="))
+	}
+
+	c.gs2Header = parts[0] + "," + parts[1] + ","
+	c.clientFirstBare = parts[2]
+
+	attrs := parseSCRAMAttributes(c.clientFirstBare)
+	if _, ok := attrs["m"]; ok {
+		return nil, nil, NewInappropriateAuthentication("SCRAM mandatory extension not supported")
+	}
+	username := decodeSCRAMSaslName(attrs["n"])
+	clientNonce := attrs["r"]
+	if username == "" || clientNonce == "" {
+		return nil, nil, NewInvalidCredentials()
+	}
+
+	serverNonce := make([]byte, 18)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, nil, xerrors.Errorf("Failed to generate nonce. err: %w", err)
+	}
+	c.nonce = clientNonce + base64.RawStdEncoding.EncodeToString(serverNonce)
+
+	if err := c.lookupVerifier(ctx, username); err != nil {
+		return nil, nil, err
+	}
+
+	var salt []byte
+	iterations := scramSHA256DefaultIterations
+
+	if c.verifier != nil {
+		salt = c.verifier.salt
+		iterations = c.verifier.iterations
+	} else {
+		// Don't disclose the user doesn't exist
+		salt = hmacSHA256(c.mechanism.fakeSaltKey, []byte(username))[:scramSHA256SaltLength]
+	}
+
+	c.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", c.nonce, base64.StdEncoding.EncodeToString(salt), iterations)
+
+	return []byte(c.serverFirst), nil, nil
+}
+
+func (c *saslSCRAMSHA256Conversation) lookupVerifier(ctx context.Context, username string) error {
+	s := c.server
+
+	dn, _, err := resolveSASLIdentity(ctx, s, username)
+	if err != nil {
+		var lerr *LDAPError
+		if ok := xerrors.As(err, &lerr); ok {
+			log.Printf("info: Not found SCRAM user. username: %s", username)
+			return nil
+		}
+		return err
+	}
+	c.dn = dn
+
+	entry, err := s.Repo().FindByDN(ctx, dn, &SearchOption{
+		RequestedAssocation: []string{},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, values, ok := entry.GetAttrOrig("userPassword"); ok {
+		for _, v := range values {
+			if verifier, err := parseSCRAMSHA256Verifier(v); err == nil {
+				c.verifier = verifier
+				return nil
+			}
+		}
+	}
+
+	log.Printf("info: Not found SCRAM-SHA-256 verifier. dn_norm: %s", dn.DNNormStr())
+	return nil
+}
+
+// handleClientFinal processes the client-final-message then returns server-final-message.
+//
+//	client-final-message = client-final-message-without-proof "," proof
+//	client-final-message-without-proof = channel-binding "," nonce ["," extensions]
+func (c *saslSCRAMSHA256Conversation) handleClientFinal(ctx context.Context, msg string) ([]byte, *SASLResult, error) {
+	i := strings.LastIndex(msg, ",p=")
+	if i == -1 {
+		return nil, nil, NewInvalidCredentials()
+	}
+	withoutProof := msg[:i]
+	proof, err := base64.StdEncoding.DecodeString(msg[i+3:])
+	if err != nil {
+		return nil, nil, NewInvalidCredentials()
+	}
+
+	attrs := parseSCRAMAttributes(withoutProof)
+	if attrs["c"] != base64.StdEncoding.EncodeToString([]byte(c.gs2Header)) {
+		log.Printf("info: Invalid SCRAM channel binding")
+		return nil, nil, NewInvalidCredentials()
+	}
+	if attrs["r"] != c.nonce {
+		log.Printf("info: Invalid SCRAM nonce")
+		return nil, nil, NewInvalidCredentials()
+	}
+
+	if c.dn == nil {
+		return nil, nil, NewInvalidCredentials()
+	}
+
+	authMessage := c.clientFirstBare + "," + c.serverFirst + "," + withoutProof
+
+	var verified *scramSHA256Verifier
+
+	// Verify in Repository.Bind for the password policy
+	bindResult, err := bindWithVerifier(ctx, c.server, c.dn, func(current *FetchedCredential) bool {
+		for _, v := range current.Credential {
+			verifier, err := parseSCRAMSHA256Verifier(v)
+			if err != nil {
+				continue
+			}
+			if verifier.validateProof(authMessage, proof) {
+				verified = verifier
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &SASLResult{
+		BindResult: *bindResult,
+		DN:         c.dn,
+	}
+
+	if err := resolveAuthzID(ctx, c.server, result, c.authzID); err != nil {
+		return nil, nil, err
+	}
+
+	serverFinal := "v=" + base64.StdEncoding.EncodeToString(verified.serverSignature(authMessage))
+
+	return []byte(serverFinal), result, nil
+}
+
+func parseSCRAMAttributes(msg string) map[string]string {
+	attrs := map[string]string{}
+	for _, v := range strings.Split(msg, ",") {
+		if len(v) < 2 || v[1] != '=' {
+			continue
+		}
+		attrs[v[:1]] = v[2:]
+	}
+	return attrs
+}
+
+func decodeSCRAMSaslName(name string) string {
+	name = strings.ReplaceAll(name, "=2C", ",")
+	return strings.ReplaceAll(name, "=3D", "=")
+}
@@ -0,0 +1,133 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/openstandia/goldap/message"
+)
+
+func TestCertSubjectToDN(t *testing.T) {
+	testcases := []struct {
+		Subject  pkix.Name
+		Expected string
+	}{
+		{
+			pkix.Name{
+				CommonName:         "user1",
+				OrganizationalUnit: []string{"Users"},
+				Organization:       []string{"Example"},
+				Country:            []string{"JP"},
+			},
+			"CN=user1,OU=Users,O=Example,C=JP",
+		},
+		{
+			pkix.Name{
+				ExtraNames: []pkix.AttributeTypeAndValue{
+					{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}, Value: "com"},
+					{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}, Value: "example"},
+					{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: "user1"},
+				},
+			},
+			"UID=user1,DC=example,DC=com",
+		},
+		{
+			pkix.Name{
+				CommonName:   "Sato, Taro",
+				Organization: []string{" Example+Co "},
+			},
+			`CN=Sato\, Taro,O=\ Example\+Co\ `,
+		},
+	}
+
+	for i, tc := range testcases {
+		cert := &x509.Certificate{
+			Subject: tc.Subject,
+		}
+
+		dn := certSubjectToDN(cert)
+		if dn != tc.Expected {
+			t.Errorf("Unexpected DN on %d: expected %s, got %s", i, tc.Expected, dn)
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	f, err := compileFilter("(&(objectClass=inetOrgPerson)(mail=user1@example.com))")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	and, ok := f.(message.FilterAnd)
+	if !ok {
+		t.Fatalf("Unexpected filter: %#v", f)
+	}
+	if len(and) != 2 {
+		t.Fatalf("Unexpected filter size: %d", len(and))
+	}
+	eq, ok := and[1].(message.FilterEqualityMatch)
+	if !ok {
+		t.Fatalf("Unexpected filter: %#v", and[1])
+	}
+	if string(eq.AttributeDesc()) != "mail" || string(eq.AssertionValue()) != "user1@example.com" {
+		t.Errorf("Unexpected equality match: %s=%s", eq.AttributeDesc(), eq.AssertionValue())
+	}
+
+	if _, err := compileFilter("(mail=user1"); err == nil {
+		t.Errorf("Expected error for invalid filter")
+	}
+}
+
+func TestSCRAMSHA256Verifier(t *testing.T) {
+	// Test vector from RFC 7677
+	salt, _ := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	verifier := newSCRAMSHA256Verifier("pencil", salt, 4096)
+
+	parsed, err := parseSCRAMSHA256Verifier(verifier.String())
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(parsed, verifier) {
+		t.Errorf("Unexpected verifier: expected %v, got %v", verifier, parsed)
+	}
+
+	clientFirstBare := "n=user,r=rOprNGfwEbeRWgbNEkqO"
+	serverFirst := "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	withoutProof := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	proof, _ := base64.StdEncoding.DecodeString("dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=")
+	if !parsed.validateProof(authMessage, proof) {
+		t.Errorf("Expected valid proof")
+	}
+	proof[0] ^= 0xff
+	if parsed.validateProof(authMessage, proof) {
+		t.Errorf("Expected invalid proof")
+	}
+
+	signature := base64.StdEncoding.EncodeToString(parsed.serverSignature(authMessage))
+	if signature != "6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=" {
+		t.Errorf("Unexpected server signature: %s", signature)
+	}
+
+	if !parsed.validatePassword("pencil") {
+		t.Errorf("Expected valid password")
+	}
+	if parsed.validatePassword("pencil2") {
+		t.Errorf("Expected invalid password")
+	}
+
+	generated, err := generateSCRAMSHA256("secret", 4096)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if ok, err := validateSCRAMSHA256("secret", generated); !ok || err != nil {
+		t.Errorf("Unexpected validation result: %v, err: %v", ok, err)
+	}
+}
@@ -929,5 +929,5 @@ func (s *AttributeType) IsNumberOrdering() bool {
 }
 
 func (s *AttributeType) IsNanoFormat() bool {
-	return s.Name == "pwdFailureTime"
+	return s.Name == "pwdFailureTime" || s.Name == "pwdGraceUseTime"
 }
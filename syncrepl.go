@@ -0,0 +1,528 @@
+package ldap_pg
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openstandia/goldap/message"
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
+)
+
+// syncPollInterval is the interval to poll the changelog in the persist stage.
+const syncPollInterval = time.Second
+
+// syncIDSetSize is the max number of entryUUIDs in one syncIdSet message.
+const syncIDSetSize = 1000
+
+// syncUUIDFilterSize is the max number of entryUUIDs to narrow the search by the filter.
+// The changed entries are picked from all entries in the scope if there are more.
+const syncUUIDFilterSize = 100
+
+// handleSyncSearch handles the search with the sync request control, the provider side of syncrepl.
+// The cookie is the change number of ldap_changelog table.
+// The refresh stage uses the delete phase if the changelog covers the cookie, it sends the changed entries
+// and the entryUUIDs of the deleted entries. Otherwise, or the entries were renamed, it uses the present phase
+// which sends the changed entries and the entryUUIDs of all unchanged entries.
+// https://tools.ietf.org/html/rfc4533
+func handleSyncSearch(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, baseDN *DN, syncControl *SyncRequestControl) {
+	first, last, err := s.Repo().ChangeNumberRange(ctx)
+	if err != nil {
+		responseSearchError(w, err)
+		return
+	}
+
+	var changes *syncChanges
+	if cookie, ok := parseSyncCookie(syncControl.Cookie); ok && first > 0 && cookie >= first-1 && cookie <= last {
+		entries, err := fetchChangelog(ctx, s, cookie+1, last)
+		if err != nil {
+			responseSearchError(w, err)
+			return
+		}
+		changes = newSyncChanges(s, entries)
+	} else if syncControl.Cookie != "" {
+		log.Printf("info: The sync cookie isn't covered by the changelog, use the present phase. cookie: %s, first: %d, last: %d", syncControl.Cookie, first, last)
+	}
+
+	present := changes == nil || len(changes.moved) > 0
+	if present {
+		err = refreshPresent(ctx, s, w, m, r, baseDN, changes)
+	} else {
+		err = refreshDelete(ctx, s, w, m, r, baseDN, changes)
+	}
+	if err != nil {
+		responseSearchError(w, err)
+		return
+	}
+
+	cookie := syncCookie(last)
+
+	if syncControl.Mode == SyncModeRefreshOnly {
+		control, err := newSyncDoneControl(cookie, !present)
+		if err != nil {
+			responseSearchError(w, err)
+			return
+		}
+		writeSearchResultDone(w, ldap.NewSearchResultDoneResponse(ldap.LDAPResultSuccess), message.Controls{control})
+		return
+	}
+
+	info, err := newSyncInfoRefreshDone(present, cookie)
+	if err != nil {
+		responseSearchError(w, err)
+		return
+	}
+	w.Write(info)
+
+	persistSync(ctx, s, w, m, r, baseDN, last)
+}
+
+// refreshPresent sends all entries in the scope. The changed entries have the attributes,
+// the others are sent as the entryUUIDs by syncIdSet messages.
+// All entries are changed if the changes are nil.
+func refreshPresent(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, baseDN *DN, changes *syncChanges) error {
+	var presentUUIDs []string
+
+	flush := func() error {
+		if len(presentUUIDs) == 0 {
+			return nil
+		}
+		info, err := newSyncInfoIDSet("", false, presentUUIDs)
+		if err != nil {
+			return err
+		}
+		w.Write(info)
+		presentUUIDs = presentUUIDs[:0]
+		return nil
+	}
+
+	err := searchSyncEntries(ctx, s, baseDN, r, nil, func(entry *SearchEntry, entryUUID string, dn *DN) error {
+		if changes == nil || changes.isChanged(entryUUID, dn) {
+			responseSyncEntry(ctx, s, w, m, r, entry, entryUUID, SyncStateAdd)
+			return nil
+		}
+		if s.AccessLevel(ctx, m, dn, entry, ACLAttrEntry) < AccessRead {
+			return nil
+		}
+		presentUUIDs = append(presentUUIDs, entryUUID)
+		if len(presentUUIDs) >= syncIDSetSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// refreshDelete sends the changed entries, then sends the entryUUIDs of the deleted entries by syncIdSet messages.
+// The entries which are out of the scope or the filter now are also deleted for the client.
+func refreshDelete(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, baseDN *DN, changes *syncChanges) error {
+	if len(changes.uuids) == 0 {
+		return nil
+	}
+
+	sent := map[string]bool{}
+
+	err := searchSyncEntries(ctx, s, baseDN, r, changes.uuids, func(entry *SearchEntry, entryUUID string, dn *DN) error {
+		if changes.contains(entryUUID) && responseSyncEntry(ctx, s, w, m, r, entry, entryUUID, SyncStateAdd) {
+			sent[entryUUID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var deleted []string
+	for _, u := range changes.uuids {
+		if !sent[u] && changes.inScope(u, baseDN, int(r.Scope())) {
+			deleted = append(deleted, u)
+		}
+	}
+
+	for len(deleted) > 0 {
+		n := len(deleted)
+		if n > syncIDSetSize {
+			n = syncIDSetSize
+		}
+		info, err := newSyncInfoIDSet("", true, deleted[:n])
+		if err != nil {
+			return err
+		}
+		w.Write(info)
+		deleted = deleted[n:]
+	}
+
+	return nil
+}
+
+// persistSync polls the changelog and sends the changes until the search is abandoned.
+func persistSync(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, baseDN *DN, cookie int64) {
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.Done:
+			log.Printf("info: Stop the sync persist stage. conn: %d, msgid: %d", m.Client.Numero, int(m.MessageID()))
+			return
+		case <-ticker.C:
+		}
+
+		entries, err := fetchChangelog(ctx, s, cookie+1, 0)
+		if err != nil {
+			responseSearchError(w, err)
+			return
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		refreshRequired, err := persistChanges(ctx, s, w, m, r, baseDN, newSyncChanges(s, entries))
+		if err != nil {
+			responseSearchError(w, err)
+			return
+		}
+		if refreshRequired {
+			log.Printf("info: The renamed entry has subordinates, the sync client needs to refresh. conn: %d, msgid: %d", m.Client.Numero, int(m.MessageID()))
+
+			res := ldap.NewSearchResultDoneResponse(LDAPResultSyncRefreshRequired)
+			res.SetDiagnosticMessage("the renamed entry has subordinates")
+			w.Write(res)
+			return
+		}
+
+		cookie = entries[len(entries)-1].ChangeNumber
+
+		info, err := newSyncInfoNewCookie(syncCookie(cookie))
+		if err != nil {
+			responseSearchError(w, err)
+			return
+		}
+		w.Write(info)
+	}
+}
+
+// persistChanges sends the changed entries with add or modify state, and the deleted entries with delete state.
+// It returns true if the renamed entry has subordinates because their changes aren't in the changelog.
+func persistChanges(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, baseDN *DN, changes *syncChanges) (bool, error) {
+	for _, dn := range changes.moved {
+		entry, err := s.Repo().FindByDN(ctx, dn, &SearchOption{IsHasSubordinatesRequested: true})
+		if err != nil {
+			var ldapErr *LDAPError
+			if ok := xerrors.As(err, &ldapErr); ok && ldapErr.IsNoSuchObjectError() {
+				// Deleted after renaming
+				continue
+			}
+			return false, err
+		}
+		if _, v, ok := entry.GetAttrOrig("hasSubordinates"); ok && len(v) > 0 && v[0] == "TRUE" {
+			return true, nil
+		}
+	}
+
+	if len(changes.uuids) == 0 {
+		return false, nil
+	}
+
+	found := map[string]*SearchEntry{}
+	err := searchSyncEntries(ctx, s, baseDN, r, changes.uuids, func(entry *SearchEntry, entryUUID string, dn *DN) error {
+		if changes.contains(entryUUID) {
+			found[entryUUID] = entry
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, u := range changes.uuids {
+		if entry, ok := found[u]; ok {
+			state := SyncStateModify
+			if changes.added[u] {
+				state = SyncStateAdd
+			}
+			if responseSyncEntry(ctx, s, w, m, r, entry, u, state) {
+				continue
+			}
+		}
+		if changes.inScope(u, baseDN, int(r.Scope())) {
+			responseSyncDeletedEntry(w, changes.lastDN(u), u)
+		}
+	}
+
+	return false, nil
+}
+
+// searchSyncEntries searches all entries in the scope, the handler receives the entry with the normalized entryUUID and DN.
+// The search is narrowed by entryUUID if the entryUUIDs are specified and not too many.
+func searchSyncEntries(ctx context.Context, s *Server, baseDN *DN, r message.SearchRequest, entryUUIDs []string, handler func(entry *SearchEntry, entryUUID string, dn *DN) error) error {
+	filter := r.Filter()
+	if len(entryUUIDs) > 0 && len(entryUUIDs) <= syncUUIDFilterSize {
+		var b strings.Builder
+		b.WriteString("(|")
+		for _, u := range entryUUIDs {
+			fmt.Fprintf(&b, "(entryUUID=%s)", u)
+		}
+		b.WriteString(")")
+
+		f, err := compileFilter(b.String())
+		if err != nil {
+			return xerrors.Errorf("Failed to compile entryUUID filter. err: %w", err)
+		}
+		filter = message.FilterAnd{r.Filter(), f}
+	}
+
+	// Fetch all entries by one query, the paging by the offset may skip the entries when they are changed
+	option := &SearchOption{
+		Scope:                      int(r.Scope()),
+		Filter:                     filter,
+		PageSize:                   math.MaxInt32,
+		RequestedAssocation:        getRequestedMemberAttrs(r),
+		IsMemberOfRequested:        isMemberOfRequested(r),
+		IsHasSubordinatesRequested: isHasSubOrdinatesRequested(r),
+	}
+
+	_, _, err := s.Repo().Search(ctx, baseDN, option, func(entry *SearchEntry) error {
+		_, v, _ := entry.GetAttrOrig("entryUUID")
+		if len(v) == 0 {
+			log.Printf("warn: The entry doesn't have entryUUID, ignore for sync. dn: %s", entry.DNOrig())
+			return nil
+		}
+		entryUUID, ok := normalizeEntryUUID(v[0])
+		if !ok {
+			log.Printf("warn: Invalid entryUUID, ignore for sync. dn: %s, entryUUID: %s", entry.DNOrig(), v[0])
+			return nil
+		}
+		dn, err := s.NormalizeDN(resolveSuffix(s, entry.DNOrig()))
+		if err != nil {
+			log.Printf("warn: Invalid DN of the search result, ignore for sync. dn: %s, err: %v", entry.DNOrig(), err)
+			return nil
+		}
+		return handler(entry, entryUUID, dn)
+	})
+	return err
+}
+
+// responseSyncEntry sends the entry with the sync state control.
+// It returns false if the entry isn't sent because of the access control.
+func responseSyncEntry(ctx context.Context, s *Server, w ldap.ResponseWriter, m *ldap.Message, r message.SearchRequest, entry *SearchEntry, entryUUID string, state int) bool {
+	control, err := newSyncStateControl(state, entryUUID, "")
+	if err != nil {
+		log.Printf("warn: Failed to create sync state control, ignore. dn: %s, err: %+v", entry.DNOrig(), err)
+		return false
+	}
+	cw := &controlsResponseWriter{
+		ResponseWriter: w,
+		controls:       message.Controls{control},
+	}
+	return responseEntryWithDN(ctx, s, cw, m, r, resolveSuffix(s, entry.DNOrig()), entry)
+}
+
+// responseSyncDeletedEntry sends the entry which has only the DN with delete state.
+func responseSyncDeletedEntry(w ldap.ResponseWriter, dn, entryUUID string) {
+	control, err := newSyncStateControl(SyncStateDelete, entryUUID, "")
+	if err != nil {
+		log.Printf("warn: Failed to create sync state control, ignore. dn: %s, err: %+v", dn, err)
+		return
+	}
+	w.WriteControls(ldap.NewSearchResultEntry(dn), &message.Controls{control})
+}
+
+// controlsResponseWriter attaches the controls to the written response.
+type controlsResponseWriter struct {
+	ldap.ResponseWriter
+	controls message.Controls
+}
+
+func (w *controlsResponseWriter) Write(po message.ProtocolOp) {
+	w.ResponseWriter.WriteControls(po, &w.controls)
+}
+
+func fetchChangelog(ctx context.Context, s *Server, first, last int64) ([]*ChangelogEntry, error) {
+	var entries []*ChangelogEntry
+	err := s.Repo().SearchChangelog(ctx, &ChangelogSearchOption{
+		FirstChangeNumber: first,
+		LastChangeNumber:  last,
+	}, func(entry *ChangelogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// syncChanges is the summary of the changelog entries per entryUUID.
+type syncChanges struct {
+	// uuids is the changed entryUUIDs ordered by the last change
+	uuids []string
+	index map[string]int
+	// added is the entryUUIDs which were added in the changes
+	added map[string]bool
+	// dns is the DNs of the entry before and after renaming
+	dns map[string][]*DN
+	// moved is the new DNs of the renamed entries
+	moved []*DN
+}
+
+func newSyncChanges(s *Server, entries []*ChangelogEntry) *syncChanges {
+	c := &syncChanges{
+		index: map[string]int{},
+		added: map[string]bool{},
+		dns:   map[string][]*DN{},
+	}
+
+	for i, e := range entries {
+		u, ok := normalizeEntryUUID(e.EntryUUID)
+		if !ok {
+			log.Printf("warn: Invalid entryUUID of the changelog, ignore for sync. change_number: %d, entryUUID: %s", e.ChangeNumber, e.EntryUUID)
+			continue
+		}
+		if _, ok := c.index[u]; !ok && e.ChangeType == "add" {
+			c.added[u] = true
+		}
+		c.index[u] = i
+
+		if dn, err := s.NormalizeDN(e.TargetDN); err == nil {
+			c.dns[u] = append(c.dns[u], dn)
+		}
+		if e.ChangeType == "modrdn" {
+			newDN, err := changelogNewDN(s, e)
+			if err != nil {
+				log.Printf("warn: Invalid new DN of the changelog, ignore for sync. change_number: %d, err: %v", e.ChangeNumber, err)
+				continue
+			}
+			c.dns[u] = append(c.dns[u], newDN)
+			c.moved = append(c.moved, newDN)
+		}
+	}
+
+	for u := range c.index {
+		c.uuids = append(c.uuids, u)
+	}
+	sort.Slice(c.uuids, func(i, j int) bool {
+		return c.index[c.uuids[i]] < c.index[c.uuids[j]]
+	})
+
+	return c
+}
+
+func (c *syncChanges) contains(entryUUID string) bool {
+	_, ok := c.index[entryUUID]
+	return ok
+}
+
+// isChanged returns true if the entry was changed or it's under the renamed entry.
+func (c *syncChanges) isChanged(entryUUID string, dn *DN) bool {
+	if c.contains(entryUUID) {
+		return true
+	}
+	for _, moved := range c.moved {
+		if dn.IsSubOf(moved) {
+			return true
+		}
+	}
+	return false
+}
+
+// inScope returns true if any DN of the entry is in the search scope.
+func (c *syncChanges) inScope(entryUUID string, baseDN *DN, scope int) bool {
+	for _, dn := range c.dns[entryUUID] {
+		if inSearchScope(baseDN, dn, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *syncChanges) lastDN(entryUUID string) string {
+	dns := c.dns[entryUUID]
+	if len(dns) == 0 {
+		return ""
+	}
+	return dns[len(dns)-1].DNOrigStr()
+}
+
+// changelogNewDN returns the DN after renaming of the modrdn changelog entry.
+func changelogNewDN(s *Server, e *ChangelogEntry) (*DN, error) {
+	if !e.NewRDN.Valid {
+		return nil, xerrors.Errorf("No newRDN. change_number: %d", e.ChangeNumber)
+	}
+	return renamedDN(s, e.TargetDN, e.NewRDN.String, e.NewSuperior.String)
+}
+
+// renamedDN returns the DN after renaming by modrdn. The parent of the target DN is used if newSuperior is empty.
+func renamedDN(s *Server, targetDN, newRDN, newSuperior string) (*DN, error) {
+	parent := newSuperior
+	if parent == "" {
+		target, err := s.NormalizeDN(targetDN)
+		if err != nil {
+			return nil, err
+		}
+		if p := target.ParentDN(); p != nil {
+			parent = p.DNOrigStr()
+		}
+	}
+	if parent == "" {
+		return s.NormalizeDN(newRDN)
+	}
+	return s.NormalizeDN(newRDN + "," + parent)
+}
+
+func inSearchScope(baseDN, dn *DN, scope int) bool {
+	switch scope {
+	case message.SearchRequestScopeBaseObject:
+		return dn.Equal(baseDN)
+	case message.SearchRequestSingleLevel:
+		parent := dn.ParentDN()
+		return parent != nil && parent.Equal(baseDN)
+	case message.SearchRequestHomeSubtree:
+		return dn.Equal(baseDN) || dn.IsSubOf(baseDN)
+	case message.SearchRequestSubordinateSubtree:
+		return dn.IsSubOf(baseDN)
+	default:
+		return false
+	}
+}
+
+func normalizeEntryUUID(entryUUID string) (string, bool) {
+	u, err := uuid.Parse(entryUUID)
+	if err != nil {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// syncCookie returns the sync cookie of the change number.
+func syncCookie(changeNumber int64) string {
+	return "changeNumber=" + strconv.FormatInt(changeNumber, 10)
+}
+
+// parseSyncCookie returns the change number of the sync cookie.
+// The other fields like rid=xxx added by the client are ignored.
+func parseSyncCookie(cookie string) (int64, bool) {
+	for _, v := range strings.Split(cookie, ",") {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), "changeNumber") {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 64)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,113 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/openstandia/goldap/message"
+)
+
+func TestParseSyncCookie(t *testing.T) {
+	testcases := []struct {
+		Cookie   string
+		Expected int64
+		OK       bool
+	}{
+		{syncCookie(10), 10, true},
+		{"rid=001,changeNumber=25", 25, true},
+		{"changeNumber=0", 0, true},
+		{"", 0, false},
+		{"rid=001,csn=20211001000000.000000Z#000000#000#000000", 0, false},
+		{"changeNumber=abc", 0, false},
+		{"changeNumber=-1", 0, false},
+	}
+
+	for i, tc := range testcases {
+		n, ok := parseSyncCookie(tc.Cookie)
+		if n != tc.Expected || ok != tc.OK {
+			t.Errorf("Unexpected result on %d: expected %d %v, got %d %v", i, tc.Expected, tc.OK, n, ok)
+		}
+	}
+}
+
+func TestNewSyncChanges(t *testing.T) {
+	server := newACLTestServer(t)
+
+	u1 := "0b05df74-1219-495d-9d95-dc0c05e00aa1"
+	u2 := "0B05DF74-1219-495D-9D95-DC0C05E00AA2"
+	u3 := "0b05df74-1219-495d-9d95-dc0c05e00aa3"
+
+	changes := newSyncChanges(server, []*ChangelogEntry{
+		{ChangeNumber: 1, TargetDN: "uid=user1,ou=Users,dc=example,dc=com", EntryUUID: u1, ChangeType: "add"},
+		{ChangeNumber: 2, TargetDN: "uid=user2,ou=Users,dc=example,dc=com", EntryUUID: u2, ChangeType: "modify"},
+		{ChangeNumber: 3, TargetDN: "uid=user1,ou=Users,dc=example,dc=com", EntryUUID: u1, ChangeType: "modify"},
+		{ChangeNumber: 4, TargetDN: "ou=Groups,dc=example,dc=com", EntryUUID: u3, ChangeType: "modrdn",
+			NewRDN: sql.NullString{String: "ou=Teams", Valid: true}, NewSuperior: sql.NullString{String: "ou=Org,dc=example,dc=com", Valid: true}},
+		{ChangeNumber: 5, TargetDN: "uid=unknown,dc=example,dc=com", EntryUUID: "", ChangeType: "delete"},
+	})
+
+	// Ordered by the last change, the entryUUIDs are normalized
+	expected := []string{"0b05df74-1219-495d-9d95-dc0c05e00aa2", u1, u3}
+	if len(changes.uuids) != len(expected) {
+		t.Fatalf("Unexpected uuids: %v", changes.uuids)
+	}
+	for i, u := range expected {
+		if changes.uuids[i] != u {
+			t.Errorf("Unexpected uuid on %d: expected %s, got %s", i, u, changes.uuids[i])
+		}
+	}
+
+	if !changes.added[u1] || changes.added[u3] {
+		t.Errorf("Unexpected added: %v", changes.added)
+	}
+
+	if len(changes.moved) != 1 || changes.moved[0].DNNormStr() != "ou=teams,ou=org,dc=example,dc=com" {
+		t.Fatalf("Unexpected moved: %v", changes.moved)
+	}
+	if dn := changes.lastDN(u3); dn != "ou=Teams,ou=Org,dc=example,dc=com" {
+		t.Errorf("Unexpected last DN: %s", dn)
+	}
+
+	child, _ := server.NormalizeDN("cn=group1,ou=Teams,ou=Org,dc=example,dc=com")
+	if !changes.isChanged("0b05df74-1219-495d-9d95-dc0c05e00aa9", child) {
+		t.Errorf("The entry under the renamed entry must be changed")
+	}
+
+	users, _ := server.NormalizeDN("ou=Users,dc=example,dc=com")
+	if !changes.inScope(u1, users, message.SearchRequestSingleLevel) {
+		t.Errorf("The entry must be in the scope")
+	}
+	if changes.inScope(u3, users, message.SearchRequestHomeSubtree) {
+		t.Errorf("The entry must be out of the scope")
+	}
+}
+
+func TestInSearchScope(t *testing.T) {
+	server := newACLTestServer(t)
+	base, _ := server.NormalizeDN("ou=Users,dc=example,dc=com")
+	child, _ := server.NormalizeDN("uid=user1,ou=Users,dc=example,dc=com")
+	grandchild, _ := server.NormalizeDN("cn=a,uid=user1,ou=Users,dc=example,dc=com")
+
+	testcases := []struct {
+		DN       *DN
+		Scope    int
+		Expected bool
+	}{
+		{base, message.SearchRequestScopeBaseObject, true},
+		{child, message.SearchRequestScopeBaseObject, false},
+		{child, message.SearchRequestSingleLevel, true},
+		{grandchild, message.SearchRequestSingleLevel, false},
+		{base, message.SearchRequestHomeSubtree, true},
+		{grandchild, message.SearchRequestHomeSubtree, true},
+		{base, message.SearchRequestSubordinateSubtree, false},
+		{grandchild, message.SearchRequestSubordinateSubtree, true},
+	}
+
+	for i, tc := range testcases {
+		if inSearchScope(base, tc.DN, tc.Scope) != tc.Expected {
+			t.Errorf("Unexpected result on %d: expected %v", i, tc.Expected)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/jsimonetti/pwscheme/ssha512"
 	_ "github.com/lib/pq"
 	"golang.org/x/xerrors"
+	ber "gopkg.in/asn1-ber.v1"
 )
 
 func IntegrationTestRunner(m *testing.M) int {
@@ -170,21 +171,21 @@ type ModifyAdd struct {
 	rdn    string
 	baseDN string
 	attrs  map[string][]string
-	assert *AssertEntry
+	assert Assert
 }
 
 type ModifyReplace struct {
 	rdn    string
 	baseDN string
 	attrs  map[string][]string
-	assert *AssertEntry
+	assert Assert
 }
 
 type ModifyDelete struct {
 	rdn    string
 	baseDN string
 	attrs  map[string][]string
-	assert *AssertEntry
+	assert Assert
 }
 
 type ModifyDN struct {
@@ -200,7 +201,16 @@ type ModifyDN struct {
 type Delete struct {
 	rdn    string
 	baseDN string
-	assert *AssertNoEntry
+	assert Assert
+}
+
+type Compare struct {
+	rdn    string
+	baseDN string
+	attr   string
+	value  string
+	expect bool
+	assert *AssertResponse
 }
 
 type Search struct {
@@ -238,6 +248,59 @@ func (s Search) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
 	return conn, nil
 }
 
+// SortedSearch searches with the server side sort control and checks the order of the entries.
+type SortedSearch struct {
+	baseDN  string
+	filter  string
+	sortKey string
+	reverse bool
+	// expect is DNs of the entries in the order
+	expect []string
+	assert *AssertResponse
+}
+
+func (s SortedSearch) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
+	keys := ber.NewSequence("SortKeyList")
+	key := ber.NewSequence("SortKey")
+	key.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, s.sortKey, "attributeType"))
+	if s.reverse {
+		key.AppendChild(ber.NewBoolean(ber.ClassContext, ber.TypePrimitive, 1, true, "reverseOrder"))
+	}
+	keys.AppendChild(key)
+
+	search := ldap.NewSearchRequest(
+		s.baseDN+","+testServer.GetSuffix(),
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, // Size Limit
+		0, // Time Limit
+		false,
+		"("+s.filter+")",
+		nil,
+		[]ldap.Control{ldap.NewControlString(SortRequestControlOID, true, string(keys.Bytes()))},
+	)
+	sr, err := conn.Search(search)
+	if s.assert != nil {
+		return conn, s.assert.AssertResponse(conn, err)
+	}
+	if err != nil {
+		return conn, err
+	}
+
+	dns := make([]string, len(sr.Entries))
+	for i, e := range sr.Entries {
+		dns[i] = strings.ToLower(e.DN)
+	}
+	expect := make([]string, len(s.expect))
+	for i, dn := range s.expect {
+		expect[i] = strings.ToLower(dn + "," + testServer.GetSuffix())
+	}
+	if !reflect.DeepEqual(dns, expect) {
+		return conn, xerrors.Errorf("Unexpected order of the sorted entries. want: %v, got: %v", expect, dns)
+	}
+	return conn, nil
+}
+
 func resolveDN(rdn, baseDN string) string {
 	dn := rdn
 	if baseDN != "" {
@@ -350,11 +413,119 @@ func (d Delete) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
 	err := conn.Del(del)
 
 	if d.assert != nil {
-		err = d.assert.AssertNoEntry(conn, err, d.rdn, d.baseDN)
+		err = d.assert.AssertEntry(conn, err, d.rdn, d.baseDN, nil)
 	}
 	return conn, err
 }
 
+// DeleteTree deletes the entry with all its descendants by the tree delete control.
+type DeleteTree struct {
+	rdn    string
+	baseDN string
+	assert Assert
+}
+
+func (d DeleteTree) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
+	dn := resolveDN(d.rdn, d.baseDN)
+
+	del := ldap.NewDelRequest(dn, []ldap.Control{ldap.NewControlString(TreeDeleteControlOID, true, "")})
+
+	log.Printf("info: Exec tree delete operation: %v", del)
+
+	err := conn.Del(del)
+
+	if d.assert != nil {
+		err = d.assert.AssertEntry(conn, err, d.rdn, d.baseDN, nil)
+	}
+	return conn, err
+}
+
+func (c Compare) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
+	dn := resolveDN(c.rdn, c.baseDN)
+
+	log.Printf("info: Exec compare operation: dn: %s, attr: %s", dn, c.attr)
+
+	matched, err := conn.Compare(dn, c.attr, c.value)
+
+	if c.assert != nil {
+		return conn, c.assert.AssertResponse(conn, err)
+	}
+	if err != nil {
+		return conn, err
+	}
+	if matched != c.expect {
+		return conn, xerrors.Errorf("Unexpected compare result. dn: %s, attr: %s, want: %v, got: %v", dn, c.attr, c.expect, matched)
+	}
+	return conn, nil
+}
+
+type PasswordModify struct {
+	rdn         string
+	oldPassword string
+	newPassword string
+	assert      *AssertResponse
+}
+
+func (c PasswordModify) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
+	var dn string
+	if c.rdn != "" {
+		dn = c.rdn + "," + testServer.GetSuffix()
+	}
+
+	log.Printf("info: Exec password modify operation: dn: %s", dn)
+
+	res, err := conn.PasswordModify(ldap.NewPasswordModifyRequest(dn, c.oldPassword, c.newPassword))
+
+	if c.assert != nil {
+		return conn, c.assert.AssertResponse(conn, err)
+	}
+	if err != nil {
+		return conn, err
+	}
+	if c.newPassword == "" && res.GeneratedPassword == "" {
+		return conn, xerrors.Errorf("No generated password. dn: %s", dn)
+	}
+	return conn, nil
+}
+
+type WhoAmI struct {
+	controls []ldap.Control
+	expect   string
+	assert   *AssertResponse
+}
+
+func (c WhoAmI) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
+	res, err := conn.WhoAmI(c.controls)
+
+	if c.assert != nil {
+		return conn, c.assert.AssertResponse(conn, err)
+	}
+	if err != nil {
+		return conn, err
+	}
+	if res.AuthzID != c.expect {
+		return conn, xerrors.Errorf("Unexpected authzId. want: %s, got: %s", c.expect, res.AuthzID)
+	}
+	return conn, nil
+}
+
+// SetACL replaces the access rules of the test server until the test ends.
+type SetACL struct {
+	acl string
+}
+
+func (c SetACL) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
+	acl, err := ParseACL(testServer, strings.NewReader(c.acl))
+	if err != nil {
+		return conn, err
+	}
+	testServer.aclStore.set(acl, nil)
+	t.Cleanup(func() {
+		testServer.aclStore.set(testServer.aclStore.base, nil)
+	})
+	return conn, nil
+}
+
 type AssertResponse struct {
 	expect uint16
 }
@@ -601,6 +772,50 @@ func (n AssertNoEntry) AssertNoEntry(conn *ldap.Conn, err error, rdn, baseDN str
 	return nil
 }
 
+func (n AssertNoEntry) AssertEntry(conn *ldap.Conn, err error, rdn, baseDN string, attrs map[string][]string) error {
+	return n.AssertNoEntry(conn, err, rdn, baseDN)
+}
+
+// AssertChangeRecord checks the change of the entry is recorded in ldap_audit and ldap_changelog tables once.
+type AssertChangeRecord struct {
+	rdn        string
+	baseDN     string
+	changeType string
+	// changes is contained in LDIF of the audit record and the changes of the changelog
+	changes string
+}
+
+func (c AssertChangeRecord) Run(t *testing.T, conn *ldap.Conn) (*ldap.Conn, error) {
+	dn, err := testServer.NormalizeDN(resolveDN(c.rdn, c.baseDN))
+	if err != nil {
+		return conn, err
+	}
+
+	db, err := sql.Open("postgres", fmt.Sprintf("host=127.0.0.1 port=%d user=dev password=dev dbname=ldap sslmode=disable search_path=public", testPGPort))
+	if err != nil {
+		return conn, err
+	}
+	defer db.Close()
+
+	var num int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ldap_audit WHERE dn_norm = $1 AND changetype = $2 AND ($3 = '' OR strpos(ldif, $3) > 0)`,
+		dn.DNNormStr(), c.changeType, c.changes).Scan(&num); err != nil {
+		return conn, err
+	}
+	if num != 1 {
+		return conn, xerrors.Errorf("Unexpected audit record count. dn: %s, changetype: %s, want: 1, got: %d", dn.DNNormStr(), c.changeType, num)
+	}
+
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ldap_changelog WHERE lower(target_dn) = $1 AND change_type = $2 AND ($3 = '' OR strpos(changes, $3) > 0)`,
+		strings.ToLower(dn.DNOrigStr()), c.changeType, c.changes).Scan(&num); err != nil {
+		return conn, err
+	}
+	if num != 1 {
+		return conn, xerrors.Errorf("Unexpected changelog count. dn: %s, changetype: %s, want: 1, got: %d", dn.DNNormStr(), c.changeType, num)
+	}
+	return conn, nil
+}
+
 func SSHA(p string) string {
 	h, _ := ssha.Generate(p, 8)
 	return h
@@ -688,6 +903,8 @@ func setupLDAPServer() *Server {
 		PProfServer:     "127.0.0.1:10000",
 		GoMaxProcs:      0,
 		QueryTranslator: "default",
+		AuditDB:         true,
+		Changelog:       true,
 	})
 	go testServer.Start()
 
@@ -716,7 +933,7 @@ func truncateTables() {
 	}
 	defer db.Close()
 
-	_, err = db.Exec("TRUNCATE ldap_entry, ldap_container, ldap_association")
+	_, err = db.Exec("TRUNCATE ldap_entry, ldap_container, ldap_association, ldap_audit, ldap_changelog")
 	if err != nil {
 		log.Fatal("truncate table error:", err)
 	}
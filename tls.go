@@ -0,0 +1,216 @@
+package ldap_pg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	ldap "github.com/openstandia/ldapserver"
+	"golang.org/x/xerrors"
+)
+
+// Mozilla intermediate compatibility cipher suites for TLS 1.2.
+// TLS 1.3 cipher suites aren't configurable.
+// https://wiki.mozilla.org/Security/Server_Side_TLS#Intermediate_compatibility_.28recommended.29
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// certStore holds the current server certificate.
+// The certificate can be reloaded without restarting the server.
+type certStore struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+func (c *certStore) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return xerrors.Errorf("Failed to load the certificate. cert: %s, key: %s, err: %w", c.certFile, c.keyFile, err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *certStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// initTLS builds the TLS configuration used for LDAPS and StartTLS.
+// TLS is disabled when no certificate is configured.
+func (s *Server) initTLS() error {
+	if s.config.TLSCertFile == "" && s.config.TLSKeyFile == "" {
+		return nil
+	}
+	if s.config.TLSCertFile == "" || s.config.TLSKeyFile == "" {
+		return xerrors.Errorf("Both of the certificate and the key are required")
+	}
+
+	store := &certStore{
+		certFile: s.config.TLSCertFile,
+		keyFile:  s.config.TLSKeyFile,
+	}
+	if err := store.load(); err != nil {
+		return err
+	}
+
+	minVersion, err := parseTLSVersion(s.config.TLSMinVersion)
+	if err != nil {
+		return err
+	}
+
+	cipherSuites, err := parseCipherPolicy(s.config.TLSCipherPolicy)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(s.config.TLSCipherPolicy, "modern") {
+		// Modern policy allows TLS 1.3 only
+		minVersion = tls.VersionTLS13
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: store.getCertificate,
+	}
+
+	if s.config.TLSCACertFile != "" {
+		pem, err := os.ReadFile(s.config.TLSCACertFile)
+		if err != nil {
+			return xerrors.Errorf("Failed to read the CA certificate. file: %s, err: %w", s.config.TLSCACertFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return xerrors.Errorf("No valid CA certificate. file: %s", s.config.TLSCACertFile)
+		}
+		// Verify the client certificate if the client sends it
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	s.certStore = store
+	s.tlsConfig = tlsConfig
+
+	return nil
+}
+
+// ReloadTLSCertificate reloads the certificate and the key from the configured files.
+// The current certificate is kept if it fails to load them.
+func (s *Server) ReloadTLSCertificate() error {
+	if s.certStore == nil {
+		return nil
+	}
+	if err := s.certStore.load(); err != nil {
+		return err
+	}
+	log.Printf("info: Reloaded the certificate. cert: %s", s.certStore.certFile)
+	return nil
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, xerrors.Errorf("Invalid TLS version. Need 1.0, 1.1, 1.2 or 1.3: %s", v)
+	}
+}
+
+// parseCipherPolicy returns the cipher suites from the policy.
+// The policy is "intermediate", "modern" or comma separated cipher suite names.
+func parseCipherPolicy(policy string) ([]uint16, error) {
+	switch strings.ToLower(policy) {
+	case "", "intermediate":
+		return intermediateCipherSuites, nil
+	case "modern":
+		return nil, nil
+	}
+
+	suites := map[string]uint16{}
+	for _, c := range tls.CipherSuites() {
+		suites[c.Name] = c.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(policy, ",") {
+		id, ok := suites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, xerrors.Errorf("Unsupported cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func isTLSConn(m *ldap.Message) bool {
+	_, ok := m.Client.GetConn().(*tls.Conn)
+	return ok
+}
+
+// RequiredConfidentiality returns true if the operation must be rejected with confidentialityRequired
+// because TLS is required but the connection isn't protected.
+func (s *Server) RequiredConfidentiality(m *ldap.Message) bool {
+	if s.config.TLSRequired && !isTLSConn(m) {
+		log.Printf("warn: TLS is required. client: %s", m.Client.Addr())
+		return true
+	}
+	return false
+}
+
+func handleStartTLS(s *Server, w ldap.ResponseWriter, m *ldap.Message) {
+	res := ldap.NewExtendedResponse(ldap.LDAPResultSuccess)
+	res.SetResponseName(ldap.NoticeOfStartTLS)
+
+	if s.tlsConfig == nil {
+		log.Printf("warn: StartTLS is requested but TLS isn't configured")
+		res.SetResultCode(ldap.LDAPResultUnavailable)
+		res.SetDiagnosticMessage("TLS is not configured")
+		w.Write(res)
+		return
+	}
+
+	if isTLSConn(m) {
+		res.SetResultCode(ldap.LDAPResultOperationsError)
+		res.SetDiagnosticMessage("TLS already started")
+		w.Write(res)
+		return
+	}
+
+	tlsConn := tls.Server(m.Client.GetConn(), s.tlsConfig)
+	w.Write(res)
+
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("warn: StartTLS Handshake error %+v", err)
+		res.SetDiagnosticMessage(fmt.Sprintf("StartTLS Handshake error : \"%s\"", err.Error()))
+		res.SetResultCode(ldap.LDAPResultOperationsError)
+		w.Write(res)
+		return
+	}
+
+	m.Client.SetConn(tlsConn)
+	log.Println("info: StartTLS OK")
+}
@@ -0,0 +1,90 @@
+//go:build test
+
+package ldap_pg
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+func TestParseCipherPolicy(t *testing.T) {
+	testcases := []struct {
+		Policy   string
+		Expected []uint16
+		Err      bool
+	}{
+		{
+			"",
+			intermediateCipherSuites,
+			false,
+		},
+		{
+			"Intermediate",
+			intermediateCipherSuites,
+			false,
+		},
+		{
+			"modern",
+			nil,
+			false,
+		},
+		{
+			"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			false,
+		},
+		{
+			"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,UNKNOWN",
+			nil,
+			true,
+		},
+	}
+
+	for i, tc := range testcases {
+		ids, err := parseCipherPolicy(tc.Policy)
+		if tc.Err {
+			if err == nil {
+				t.Errorf("Unexpected success on %d: policy: %s", i, tc.Policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error on %d: %+v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(ids, tc.Expected) {
+			t.Errorf("Unexpected cipher suites on %d: expected %v, got %v", i, tc.Expected, ids)
+		}
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	testcases := []struct {
+		Version  string
+		Expected uint16
+		Err      bool
+	}{
+		{"", tls.VersionTLS12, false},
+		{"1.0", tls.VersionTLS10, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"3.0", 0, true},
+	}
+
+	for i, tc := range testcases {
+		v, err := parseTLSVersion(tc.Version)
+		if tc.Err {
+			if err == nil {
+				t.Errorf("Unexpected success on %d: version: %s", i, tc.Version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error on %d: %+v", i, err)
+			continue
+		}
+		if v != tc.Expected {
+			t.Errorf("Unexpected version on %d: expected %d, got %d", i, tc.Expected, v)
+		}
+	}
+}
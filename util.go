@@ -12,6 +12,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,6 +32,8 @@ type AuthSession struct {
 	DN     *DN
 	Groups []*DN
 	IsRoot bool
+	// MustChangePassword is true while the reset password isn't changed by the user
+	MustChangePassword bool
 }
 
 func getSession(m *ldap.Message) map[string]interface{} {
@@ -44,7 +47,20 @@ func getSession(m *ldap.Message) map[string]interface{} {
 	}
 }
 
+// proxiedAuthSessions holds the effective session of the request which has the proxied authorization control.
+var proxiedAuthSessions sync.Map
+
+// getAuthSession returns the effective session of the request.
+// It's the proxied identity while processing the request with the proxied authorization control.
 func getAuthSession(m *ldap.Message) *AuthSession {
+	if proxied, ok := proxiedAuthSession(m); ok {
+		return proxied
+	}
+	return getBoundAuthSession(m)
+}
+
+// getBoundAuthSession returns the session of the bound identity of the connection.
+func getBoundAuthSession(m *ldap.Message) *AuthSession {
 	session := getSession(m)
 	if authSession, ok := session["auth"]; ok {
 		return authSession.(*AuthSession)
@@ -67,7 +83,11 @@ func getPageSession(m *ldap.Message) map[string]int32 {
 }
 
 func isOperationalAttributesRequested(r message.SearchRequest) bool {
-	for _, attr := range r.Attributes() {
+	return isOperationalAttributesSelected(r.Attributes())
+}
+
+func isOperationalAttributesSelected(attrs message.AttributeSelection) bool {
+	for _, attr := range attrs {
 		if string(attr) == "+" {
 			return true
 		}
@@ -76,10 +96,14 @@ func isOperationalAttributesRequested(r message.SearchRequest) bool {
 }
 
 func isAllAttributesRequested(r message.SearchRequest) bool {
-	if len(r.Attributes()) == 0 {
+	return isAllAttributesSelected(r.Attributes())
+}
+
+func isAllAttributesSelected(attrs message.AttributeSelection) bool {
+	if len(attrs) == 0 {
 		return true
 	}
-	for _, attr := range r.Attributes() {
+	for _, attr := range attrs {
 		if string(attr) == "*" {
 			return true
 		}